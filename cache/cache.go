@@ -0,0 +1,391 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Cache en memoria de las respuestas de la API.
+
+	Los dashboards repiten las mismas consultas mensuales
+	una y otra vez, y cada una ejecuta un COUNT(*) y el
+	SELECT completo contra la BD de Galac. Para evitarlo se
+	guardan las respuestas en un LRU en memoria, con una
+	clave construida a partir de la ruta y los parametros
+	de busqueda normalizados.
+
+	Una respuesta guardada deja de ser valida cuando:
+	  - vence su TTL (definido por cada ruta)
+	  - cambia la marca de alguna de las tablas que lee la
+	    ruta. La marca es el maximo de fldTimeStamp (Galac
+	    actualiza esa columna en cada insercion o
+	    modificacion) junto con la cantidad de registros,
+	    que es la que cambia cuando se elimina uno
+
+	Tambien se maneja ETag/If-None-Match, de modo que si el
+	cliente ya tiene la respuesta se devuelve un 304 sin cuerpo.
+*/
+
+// Cantidad de respuestas que se guardan por defecto
+const capacidadPorDefecto = 500
+
+// Tiempo minimo entre consultas de la marca de una misma tabla
+const intervaloMarca = 10 * time.Second
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+// Respuesta guardada en el cache
+type entrada struct {
+	clave  string
+	tablas []string
+	marca  string // Marcas de las tablas al guardar la respuesta
+	vence  time.Time
+	status int
+	tipo   string
+	anexo  string // Content-Disposition de los reportes exportados
+	etag   string
+	cuerpo []byte
+}
+
+// Ultima marca leida de una tabla: MAX(fldTimeStamp) y COUNT(*)
+type marcaTabla struct {
+	valor    string
+	leidaEn  time.Time
+	conocida bool
+}
+
+// LRU de respuestas, seguro para uso concurrente
+type lru struct {
+	mu        sync.Mutex
+	capacidad int
+	orden     *list.List
+	entradas  map[string]*list.Element
+	marcas    map[string]*marcaTabla
+}
+
+var almacen = nuevoLRU(capacidadPorDefecto)
+
+func nuevoLRU(capacidad int) *lru {
+	if capacidad < 1 {
+		capacidad = capacidadPorDefecto
+	}
+	return &lru{
+		capacidad: capacidad,
+		orden:     list.New(),
+		entradas:  map[string]*list.Element{},
+		marcas:    map[string]*marcaTabla{},
+	}
+}
+
+// Inicializar reemplaza el cache global por uno vacio con la capacidad indicada
+func Inicializar(capacidad int) {
+	almacen = nuevoLRU(capacidad)
+	log.Printf("Cache de respuestas inicializado (capacidad: %d)\n", almacen.capacidad)
+}
+
+func (l *lru) obtener(clave string) (*entrada, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.entradas[clave]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entrada)
+	if time.Now().After(e.vence) {
+		l.orden.Remove(elem)
+		delete(l.entradas, clave)
+		return nil, false
+	}
+	l.orden.MoveToFront(elem)
+	return e, true
+}
+
+func (l *lru) guardar(e *entrada) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.entradas[e.clave]; ok {
+		elem.Value = e
+		l.orden.MoveToFront(elem)
+		return
+	}
+	l.entradas[e.clave] = l.orden.PushFront(e)
+
+	for l.orden.Len() > l.capacidad {
+		ultimo := l.orden.Back()
+		l.orden.Remove(ultimo)
+		delete(l.entradas, ultimo.Value.(*entrada).clave)
+	}
+}
+
+// Elimina todas las respuestas que dependen de la tabla indicada
+func (l *lru) invalidarTabla(tabla string) {
+	for elem := l.orden.Front(); elem != nil; {
+		siguiente := elem.Next()
+		e := elem.Value.(*entrada)
+		for _, t := range e.tablas {
+			if t == tabla {
+				l.orden.Remove(elem)
+				delete(l.entradas, e.clave)
+				break
+			}
+		}
+		elem = siguiente
+	}
+}
+
+/*
+Devuelve la marca de la tabla: el maximo de fldTimeStamp
+y la cantidad de registros. Para no recorrer la tabla en
+cada peticion, la marca se consulta como maximo una vez
+cada intervaloMarca. Si la marca cambio, se descartan las
+respuestas que dependen de esa tabla.
+*/
+func (l *lru) marcaActual(db *sql.DB, tabla string) (string, error) {
+	l.mu.Lock()
+	m, ok := l.marcas[tabla]
+	if ok && m.conocida && time.Since(m.leidaEn) < intervaloMarca {
+		valor := m.valor
+		l.mu.Unlock()
+		return valor, nil
+	}
+	l.mu.Unlock()
+
+	var maximo sql.NullInt64
+	var cantidad int64
+	err := db.QueryRow("SELECT CONVERT(BIGINT, MAX(fldTimeStamp)), COUNT_BIG(*) FROM dbo."+tabla).Scan(&maximo, &cantidad)
+	if err != nil {
+		return "", err
+	}
+	marca := strconv.FormatInt(maximo.Int64, 10) + ":" + strconv.FormatInt(cantidad, 10)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !ok {
+		m = &marcaTabla{}
+		l.marcas[tabla] = m
+	}
+	if m.conocida && m.valor != marca {
+		l.invalidarTabla(tabla)
+	}
+	m.valor = marca
+	m.leidaEn = time.Now()
+	m.conocida = true
+
+	return marca, nil
+}
+
+// Marca conjunta de las tablas de una ruta
+func (l *lru) marcaDeTablas(db *sql.DB, tablas []string) (string, error) {
+	marcas := make([]string, len(tablas))
+	for i, tabla := range tablas {
+		marca, err := l.marcaActual(db, tabla)
+		if err != nil {
+			return "", err
+		}
+		marcas[i] = tabla + "=" + marca
+	}
+	return strings.Join(marcas, "|"), nil
+}
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+/*
+Construye la clave del cache a partir de la ruta y de
+los parametros de la peticion. Los nombres se ordenan y
+se descartan los parametros vacios, de modo que
+"?anio=2025&mes=4" y "?mes=4&anio=2025&page=" generan la
+misma clave. Los valores repetidos de un parametro se
+dejan en el orden recibido y tal cual llegan: los handlers
+leen el primero, asi que "?x=a&x=b" y "?x=b&x=a" son
+peticiones distintas.
+*/
+func claveDePeticion(c *gin.Context) string {
+	valores := c.Request.URL.Query()
+
+	nombres := make([]string, 0, len(valores))
+	for nombre := range valores {
+		nombres = append(nombres, nombre)
+	}
+	sort.Strings(nombres)
+
+	var clave strings.Builder
+	clave.WriteString(c.Request.URL.Path)
+	for _, nombre := range nombres {
+		vacio := true
+		for _, v := range valores[nombre] {
+			if strings.TrimSpace(v) != "" {
+				vacio = false
+				break
+			}
+		}
+		if vacio {
+			continue
+		}
+		clave.WriteString("|" + url.QueryEscape(nombre) + "=")
+		for i, v := range valores[nombre] {
+			if i > 0 {
+				clave.WriteString(",")
+			}
+			clave.WriteString(url.QueryEscape(v))
+		}
+	}
+
+	return clave.String()
+}
+
+// Verifica si el ETag de la respuesta esta en el If-None-Match del cliente
+func coincideETag(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidato := range strings.Split(ifNoneMatch, ",") {
+		candidato = strings.TrimPrefix(strings.TrimSpace(candidato), "W/")
+		if candidato == "*" || candidato == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Envia al cliente una respuesta guardada (o un 304 si ya la tiene)
+func responder(c *gin.Context, e *entrada, estadoCache string) {
+	c.Header("ETag", e.etag)
+	c.Header("X-Cache", estadoCache)
+	if e.anexo != "" {
+		c.Header("Content-Disposition", e.anexo)
+	}
+
+	if coincideETag(c.GetHeader("If-None-Match"), e.etag) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	c.Data(e.status, e.tipo, e.cuerpo)
+	c.Abort()
+}
+
+/*
+	Writer que retiene el cuerpo de la respuesta en memoria
+	en lugar de enviarlo, para poder calcular el ETag y
+	guardarlo antes de responder al cliente.
+*/
+
+type writerRetenido struct {
+	gin.ResponseWriter
+	status int
+	cuerpo bytes.Buffer
+}
+
+func (w *writerRetenido) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *writerRetenido) WriteHeaderNow() {}
+
+func (w *writerRetenido) Write(data []byte) (int, error) {
+	return w.cuerpo.Write(data)
+}
+
+func (w *writerRetenido) WriteString(s string) (int, error) {
+	return w.cuerpo.WriteString(s)
+}
+
+func (w *writerRetenido) Status() int {
+	return w.status
+}
+
+func (w *writerRetenido) Size() int {
+	return w.cuerpo.Len()
+}
+
+func (w *writerRetenido) Written() bool {
+	return false
+}
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+/*
+Middleware que cachea las respuestas GET de una ruta.
+
+ttl: tiempo maximo que se guarda una respuesta
+tablas: todas las tablas de Galac que lee la ruta, incluidas
+
+	las de los joins y las consultas auxiliares. Se usa
+	su marca (fldTimeStamp y cantidad) para invalidar
+
+Solo se guardan las respuestas 200. Si no es posible leer
+la marca de alguna tabla, la peticion se atiende sin cache.
+*/
+func Respuestas(db *sql.DB, ttl time.Duration, tablas ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		marca, err := almacen.marcaDeTablas(db, tablas)
+		if err != nil {
+			log.Printf("[CACHE] No se pudo leer la marca de %s: %v\n", strings.Join(tablas, ", "), err)
+			c.Next()
+			return
+		}
+
+		clave := claveDePeticion(c)
+		if e, ok := almacen.obtener(clave); ok && e.marca == marca {
+			responder(c, e, "HIT")
+			return
+		}
+
+		// Ejecutar el handler reteniendo la respuesta
+		original := c.Writer
+		retenido := &writerRetenido{ResponseWriter: original, status: http.StatusOK}
+		c.Writer = retenido
+		c.Next()
+		c.Writer = original
+
+		if retenido.status != http.StatusOK {
+			c.Data(retenido.status, retenido.Header().Get("Content-Type"), retenido.cuerpo.Bytes())
+			return
+		}
+
+		suma := sha1.Sum(retenido.cuerpo.Bytes())
+		e := &entrada{
+			clave:  clave,
+			tablas: tablas,
+			marca:  marca,
+			vence:  time.Now().Add(ttl),
+			status: retenido.status,
+			tipo:   retenido.Header().Get("Content-Type"),
+			anexo:  retenido.Header().Get("Content-Disposition"),
+			etag:   `"` + hex.EncodeToString(suma[:]) + `"`,
+			cuerpo: retenido.cuerpo.Bytes(),
+		}
+		almacen.guardar(e)
+
+		responder(c, e, "MISS")
+	}
+}
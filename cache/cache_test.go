@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Clave de una peticion GET a la URL indicada
+func clave(destino string) string {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, destino, nil)
+	return claveDePeticion(c)
+}
+
+func TestClaveDePeticion(t *testing.T) {
+	casos := []struct {
+		url      string
+		esperado string
+	}{
+		{"/facturas", "/facturas"},
+		{"/facturas?mes=4&anio=2025", "/facturas|anio=2025|mes=4"},
+		{"/facturas?anio=2025&mes=4", "/facturas|anio=2025|mes=4"},
+		{"/facturas?mes=4&anio=2025&page=", "/facturas|anio=2025|mes=4"},
+		{"/facturas?page=%20&mes=4", "/facturas|mes=4"},
+		{"/facturas?x=b&x=a", "/facturas|x=b,a"},
+		{"/facturas?x=a&x=b", "/facturas|x=a,b"},
+		{"/facturas?x=&x=b", "/facturas|x=,b"},
+		{"/facturas?x=a,b", "/facturas|x=a%2Cb"},
+		{"/facturas?buscar=%20abc", "/facturas|buscar=+abc"},
+	}
+
+	for _, caso := range casos {
+		if obtenida := clave(caso.url); obtenida != caso.esperado {
+			t.Errorf("claveDePeticion(%q) = %q, se esperaba %q", caso.url, obtenida, caso.esperado)
+		}
+	}
+}
+
+func TestClaveDePeticionDistingue(t *testing.T) {
+	casos := []struct {
+		a, b string
+	}{
+		{"/facturas?x=a&x=b", "/facturas?x=b&x=a"},
+		{"/facturas?x=a,b", "/facturas?x=a&x=b"},
+		{"/facturas?x=&x=b", "/facturas?x=b"},
+		{"/facturas?mes=4", "/notas?mes=4"},
+	}
+
+	for _, caso := range casos {
+		if clave(caso.a) == clave(caso.b) {
+			t.Errorf("claveDePeticion(%q) = claveDePeticion(%q) = %q, se esperaban claves distintas",
+				caso.a, caso.b, clave(caso.a))
+		}
+	}
+}
+
+func TestCoincideETag(t *testing.T) {
+	etag := `"abc"`
+	casos := []struct {
+		ifNoneMatch string
+		esperado    bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz"`, false},
+		{"*", true},
+		{"abc", false},
+	}
+
+	for _, caso := range casos {
+		if obtenido := coincideETag(caso.ifNoneMatch, etag); obtenido != caso.esperado {
+			t.Errorf("coincideETag(%q) = %v, se esperaba %v", caso.ifNoneMatch, obtenido, caso.esperado)
+		}
+	}
+}
+
+// Router con una ruta cacheada sin tablas (no consulta la BD) que cuenta sus llamadas
+func routerDePrueba(status int, llamadas *int) *gin.Engine {
+	Inicializar(10)
+	router := gin.New()
+	router.GET("/prueba", Respuestas(nil, time.Minute), func(c *gin.Context) {
+		*llamadas++
+		c.JSON(status, gin.H{"mes": c.Query("mes")})
+	})
+	return router
+}
+
+func peticion(router *gin.Engine, destino, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, destino, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRespuestasETag(t *testing.T) {
+	llamadas := 0
+	router := routerDePrueba(http.StatusOK, &llamadas)
+
+	primera := peticion(router, "/prueba?mes=4", "")
+	etag := primera.Header().Get("ETag")
+	if primera.Code != http.StatusOK || primera.Header().Get("X-Cache") != "MISS" || etag == "" {
+		t.Fatalf("primera peticion = %d, X-Cache %q, ETag %q; se esperaba 200, MISS y un ETag",
+			primera.Code, primera.Header().Get("X-Cache"), etag)
+	}
+
+	casos := []struct {
+		url         string
+		ifNoneMatch string
+		status      int
+		cache       string
+		cuerpo      bool
+	}{
+		{"/prueba?mes=4", "", http.StatusOK, "HIT", true},
+		{"/prueba?mes=4&page=", "", http.StatusOK, "HIT", true},
+		{"/prueba?mes=4", etag, http.StatusNotModified, "HIT", false},
+		{"/prueba?mes=4", `W/` + etag, http.StatusNotModified, "HIT", false},
+		{"/prueba?mes=4", `"otro"`, http.StatusOK, "HIT", true},
+		{"/prueba?mes=5", etag, http.StatusOK, "MISS", true},
+	}
+
+	for _, caso := range casos {
+		w := peticion(router, caso.url, caso.ifNoneMatch)
+		if w.Code != caso.status || w.Header().Get("X-Cache") != caso.cache || (w.Body.Len() > 0) != caso.cuerpo {
+			t.Errorf("GET %s (If-None-Match %q) = %d, X-Cache %q, cuerpo %d bytes; se esperaba %d, %q, cuerpo %v",
+				caso.url, caso.ifNoneMatch, w.Code, w.Header().Get("X-Cache"), w.Body.Len(),
+				caso.status, caso.cache, caso.cuerpo)
+		}
+	}
+
+	if llamadas != 2 {
+		t.Errorf("el handler se llamo %d veces, se esperaban 2 (mes=4 y mes=5)", llamadas)
+	}
+}
+
+func TestRespuestasSoloGuarda200(t *testing.T) {
+	llamadas := 0
+	router := routerDePrueba(http.StatusNotFound, &llamadas)
+
+	for i := 0; i < 2; i++ {
+		if w := peticion(router, "/prueba?mes=4", ""); w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" {
+			t.Errorf("peticion %d = %d, ETag %q; se esperaba 404 sin ETag", i+1, w.Code, w.Header().Get("ETag"))
+		}
+	}
+	if llamadas != 2 {
+		t.Errorf("el handler se llamo %d veces, se esperaban 2", llamadas)
+	}
+}
+
+func TestInvalidarTabla(t *testing.T) {
+	l := nuevoLRU(10)
+	vence := time.Now().Add(time.Minute)
+	l.guardar(&entrada{clave: "facturas", tablas: []string{"factura"}, vence: vence})
+	l.guardar(&entrada{clave: "notas", tablas: []string{"factura", "Cliente"}, vence: vence})
+	l.guardar(&entrada{clave: "clientes", tablas: []string{"Cliente"}, vence: vence})
+
+	l.invalidarTabla("factura")
+
+	casos := []struct {
+		clave    string
+		esperado bool
+	}{
+		{"facturas", false},
+		{"notas", false},
+		{"clientes", true},
+	}
+	for _, caso := range casos {
+		if _, ok := l.obtener(caso.clave); ok != caso.esperado {
+			t.Errorf("obtener(%q) despues de invalidar factura = %v, se esperaba %v", caso.clave, ok, caso.esperado)
+		}
+	}
+}
+
+func TestVencimientoYCapacidad(t *testing.T) {
+	l := nuevoLRU(2)
+	l.guardar(&entrada{clave: "vencida", vence: time.Now().Add(-time.Second)})
+	if _, ok := l.obtener("vencida"); ok {
+		t.Errorf("obtener(%q) = true, se esperaba false por el TTL", "vencida")
+	}
+
+	vence := time.Now().Add(time.Minute)
+	l.guardar(&entrada{clave: "a", vence: vence})
+	l.guardar(&entrada{clave: "b", vence: vence})
+	l.obtener("a") // a pasa a ser la mas reciente
+	l.guardar(&entrada{clave: "c", vence: vence})
+
+	casos := []struct {
+		clave    string
+		esperado bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+	for _, caso := range casos {
+		if _, ok := l.obtener(caso.clave); ok != caso.esperado {
+			t.Errorf("obtener(%q) con capacidad 2 = %v, se esperaba %v", caso.clave, ok, caso.esperado)
+		}
+	}
+}
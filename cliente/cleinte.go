@@ -10,6 +10,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
 	"github.com/gin-gonic/gin"
 )
 
@@ -54,11 +56,14 @@ func logError(mensaje string, err error) {
 /////////////////////////////////////////////////////
 /////////////////////////////////////////////////////
 
+// Tiempo maximo que se guarda en cache una busqueda de clientes
+const ttlCacheClientes = 10 * time.Minute
+
 func ClienteRoutes(api *gin.RouterGroup, db *sql.DB) {
 	// Inicializar el logger de errores
 	initErrorLogger()
 
-	api.GET("/existe-cliente", buscarClientes(db))
+	api.GET("/existe-cliente", cache.Respuestas(db, ttlCacheClientes, "Cliente"), buscarClientes(db))
 }
 
 /////////////////////////////////////////////////////
@@ -271,11 +276,7 @@ func buscarClientes(db *sql.DB) gin.HandlerFunc {
 		// Verificar que al menos uno de los parámetros (rif o codigo) esté presente
 		if rif == "" && codigo == "" {
 			logError(requestID+" - Falta el parámetro RIF o Código", nil)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message":    "Falta el RIF o Código a buscar",
-				"statusCode": http.StatusBadRequest,
-				"success":    false,
-			})
+			respuesta.Error(c, http.StatusBadRequest, respuesta.ParametroFaltante, "Falta el RIF o Código a buscar")
 			return
 		}
 
@@ -312,23 +313,14 @@ func buscarClientes(db *sql.DB) gin.HandlerFunc {
 		codigos, err = obtenerCodigosCliente(db, requestID, query, param)
 		if err != nil {
 			logError(requestID+" - Error al consultar los códigos de cliente", err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message":    "Error al consultar los clientes",
-				"error":      err.Error(),
-				"statusCode": http.StatusInternalServerError,
-				"success":    false,
-			})
+			respuesta.ErrorBD(c, "Error al consultar los clientes")
 			return
 		}
 
 		// ----- Manejo de resultado de búsqueda ---- //
 		if len(codigos) == 0 {
 			logError(requestID+" - No se encontraron códigos de cliente", nil)
-			c.JSON(http.StatusOK, gin.H{
-				"message":    "No se encontraron códigos de cliente",
-				"statusCode": http.StatusOK,
-				"success":    false,
-			})
+			respuesta.SinResultados(c, "No se encontraron códigos de cliente")
 			return
 		}
 
@@ -339,23 +331,12 @@ func buscarClientes(db *sql.DB) gin.HandlerFunc {
 			clientes, err := obtenerDetallesClientes(db, requestID, codigos)
 			if err != nil {
 				logError(requestID+" - Error al obtener detalles de clientes", err)
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"message":    "Error al obtener detalles de clientes",
-					"error":      err.Error(),
-					"statusCode": http.StatusInternalServerError,
-					"success":    false,
-				})
+				respuesta.ErrorBD(c, "Error al obtener detalles de clientes")
 				return
 			}
 
 			logError(requestID+" - Búsqueda exitosa. Clientes encontrados: "+strconv.Itoa(len(clientes)), nil)
-			c.JSON(http.StatusOK, gin.H{
-				"message":    "Detalles de clientes encontrados",
-				"data":       clientes,
-				"count":      len(clientes),
-				"statusCode": http.StatusOK,
-				"success":    true,
-			})
+			respuesta.Exito(c, "Detalles de clientes encontrados", clientes, len(clientes))
 			return
 		}
 
@@ -363,23 +344,12 @@ func buscarClientes(db *sql.DB) gin.HandlerFunc {
 		clientesBasicos, err := obtenerClientesBasicos(db, requestID, codigos)
 		if err != nil {
 			logError(requestID+" - Error al obtener información básica de clientes", err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message":    "Error al obtener información básica de clientes",
-				"error":      err.Error(),
-				"statusCode": http.StatusInternalServerError,
-				"success":    false,
-			})
+			respuesta.ErrorBD(c, "Error al obtener información básica de clientes")
 			return
 		}
 
 		logError(requestID+" - Búsqueda exitosa. Clientes básicos encontrados: "+strconv.Itoa(len(clientesBasicos)), nil)
-		c.JSON(http.StatusOK, gin.H{
-			"message":    "Información básica de clientes encontrada",
-			"data":       clientesBasicos,
-			"count":      len(clientesBasicos),
-			"statusCode": http.StatusOK,
-			"success":    true,
-		})
+		respuesta.Exito(c, "Información básica de clientes encontrada", clientesBasicos, len(clientesBasicos))
 	}
 }
 
@@ -0,0 +1,182 @@
+package compras
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Antiguedad de saldos de las cuentas por pagar.
+
+	Se toman las cuentas no anuladas con saldo y se reparten
+	en tramos segun los dias vencidos a la fecha de corte.
+	El saldo es el actual (MontoAbonado no tiene historial),
+	la fecha de corte solo cambia los dias vencidos. Las
+	cuentas sin fecha de vencimiento van en porVencer.
+
+	Las notas de credito del proveedor no son deuda: su saldo
+	no entra en los tramos y se informa como creditoDisponible.
+	neto es el total menos ese credito.
+
+	Los montos no se convierten entre monedas, se agrupa
+	por proveedor y CodigoMoneda.
+*/
+
+type TramosAntiguedad struct {
+	PorVencer  float64 `json:"porVencer"`
+	De1a30     float64 `json:"de1a30"`
+	De31a60    float64 `json:"de31a60"`
+	De61a90    float64 `json:"de61a90"`
+	MasDe90    float64 `json:"masDe90"`
+	Total      float64 `json:"total"`
+	Documentos int     `json:"documentos"`
+
+	CreditoDisponible float64 `json:"creditoDisponible"`
+	Neto              float64 `json:"neto"`
+}
+
+type AntiguedadProveedor struct {
+	CodigoProveedor string `json:"codigoProveedor"`
+	CodigoMoneda    string `json:"codigoMoneda"`
+	TramosAntiguedad
+}
+
+type TotalAntiguedadMoneda struct {
+	CodigoMoneda string `json:"codigoMoneda"`
+	TramosAntiguedad
+}
+
+type ReporteAntiguedad struct {
+	FechaCorte  time.Time               `json:"fechaCorte"`
+	Totales     []TotalAntiguedadMoneda `json:"totales"`
+	Proveedores []AntiguedadProveedor   `json:"proveedores"`
+}
+
+func (t *TramosAntiguedad) acumular(o TramosAntiguedad) {
+	t.PorVencer += o.PorVencer
+	t.De1a30 += o.De1a30
+	t.De31a60 += o.De31a60
+	t.De61a90 += o.De61a90
+	t.MasDe90 += o.MasDe90
+	t.Total += o.Total
+	t.Documentos += o.Documentos
+	t.CreditoDisponible += o.CreditoDisponible
+	t.Neto += o.Neto
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Antiguedad de saldos por proveedor y moneda.
+Querys:
+
+fechaCorte: AAAA-MM-DD, por defecto hoy. Solo se incluyen
+
+	las cuentas con fecha hasta la fecha de corte
+
+codigoProveedor: codigo exacto del proveedor
+*/
+func antiguedadCxp(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		var v respuesta.Validador
+		corte := v.Fecha(c.Query("fechaCorte"), "fechaCorte")
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if corte == nil {
+			hoy := inicioDelDia(requestTime)
+			corte = &hoy
+		}
+		codigoProveedor := strings.TrimSpace(c.Query("codigoProveedor"))
+
+		condicion := ""
+		params := []interface{}{
+			sql.Named("corte", *corte),
+			sql.Named("finCorte", corte.AddDate(0, 0, 1)),
+			sql.Named("anulado", StatusCxpAnulado),
+			sql.Named("notaCredito", TipoCxpNotaCredito),
+			sql.Named("tolerancia", toleranciaSaldo),
+		}
+		if codigoProveedor != "" {
+			condicion = " AND CodigoProveedor = @codigoProveedor"
+			params = append(params, sql.Named("codigoProveedor", codigoProveedor))
+		}
+
+		query := `
+			SELECT CodigoProveedor, CodigoMoneda,
+				SUM(CASE WHEN Nota = 0 AND Dias <= 0 THEN Saldo ELSE 0 END),
+				SUM(CASE WHEN Nota = 0 AND Dias BETWEEN 1 AND 30 THEN Saldo ELSE 0 END),
+				SUM(CASE WHEN Nota = 0 AND Dias BETWEEN 31 AND 60 THEN Saldo ELSE 0 END),
+				SUM(CASE WHEN Nota = 0 AND Dias BETWEEN 61 AND 90 THEN Saldo ELSE 0 END),
+				SUM(CASE WHEN Nota = 0 AND Dias > 90 THEN Saldo ELSE 0 END),
+				SUM(CASE WHEN Nota = 0 THEN Saldo ELSE 0 END),
+				SUM(1 - Nota),
+				SUM(CASE WHEN Nota = 1 THEN Saldo ELSE 0 END)
+			FROM (
+				SELECT CodigoProveedor, CodigoMoneda, ` + saldoCxpSQL + ` AS Saldo,
+					ISNULL(DATEDIFF(day, FechaVencimiento, @corte), 0) AS Dias,
+					CASE WHEN TipoDeCxp = @notaCredito THEN 1 ELSE 0 END AS Nota
+				FROM dbo.cxP
+				WHERE ISNULL(Status, '') <> @anulado
+				  AND Fecha < @finCorte` + condicion + `
+			) cuentas
+			WHERE Saldo > @tolerancia
+			GROUP BY CodigoProveedor, CodigoMoneda
+			ORDER BY SUM(CASE WHEN Nota = 0 THEN Saldo ELSE -Saldo END) DESC
+		`
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar la antigüedad de saldos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		reporte := ReporteAntiguedad{
+			FechaCorte:  *corte,
+			Totales:     []TotalAntiguedadMoneda{},
+			Proveedores: []AntiguedadProveedor{},
+		}
+		totales := map[string]int{} // posicion en reporte.Totales
+		for rows.Next() {
+			var p AntiguedadProveedor
+			err := rows.Scan(&p.CodigoProveedor, &p.CodigoMoneda,
+				&p.PorVencer, &p.De1a30, &p.De31a60, &p.De61a90, &p.MasDe90, &p.Total, &p.Documentos, &p.CreditoDisponible)
+			if err != nil {
+				mensaje := "Error al leer la antigüedad de saldos"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			p.Neto = p.Total - p.CreditoDisponible
+			reporte.Proveedores = append(reporte.Proveedores, p)
+
+			i, ok := totales[p.CodigoMoneda]
+			if !ok {
+				i = len(reporte.Totales)
+				totales[p.CodigoMoneda] = i
+				reporte.Totales = append(reporte.Totales, TotalAntiguedadMoneda{CodigoMoneda: p.CodigoMoneda})
+			}
+			reporte.Totales[i].acumular(p.TramosAntiguedad)
+		}
+
+		if len(reporte.Proveedores) == 0 {
+			respuesta.SinResultados(c, "No hay cuentas por pagar con saldo a la fecha de corte")
+			return
+		}
+
+		respuesta.Exito(c, "Antigüedad de saldos al "+corte.Format("2006-01-02"), reporte, len(reporte.Proveedores))
+	}
+}
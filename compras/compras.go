@@ -0,0 +1,64 @@
+package compras
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/gin-gonic/gin"
+)
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Logger Interno para el registro de errores y problemas.
+	Solo se instancia en este modulo y generar el archivo
+	errores_compras.log
+*/
+
+// Logger para registrar errores en un archivo
+var errorLogger *log.Logger
+
+func initErrorLogger() {
+	logFile, err := os.OpenFile("errores_compras.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Println("Error al abrir archivo de log de compras:", err)
+		return
+	}
+	errorLogger = log.New(logFile, "", log.Ldate|log.Ltime)
+	log.Println("Logger de errores de compras inicializado correctamente")
+}
+
+func logError(mensaje string, err error) {
+	if errorLogger != nil {
+		errorLogger.Printf("[ERROR] %s: %v\n", mensaje, err)
+	} else {
+		log.Printf("[ERROR] %s: %v\n", mensaje, err)
+	}
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Cuentas por pagar (dbo.cxP) y pagos a proveedores
+	(dbo.Pago). Todo es de solo lectura.
+*/
+
+// Tiempo maximo que se guarda en cache una consulta de compras
+const ttlCacheCompras = 5 * time.Minute
+
+func CxpRoutes(api *gin.RouterGroup, db *sql.DB) {
+	initErrorLogger()
+	api.GET("/", cache.Respuestas(db, ttlCacheCompras, "cxP"), buscarCxp(db))
+	api.GET("/antiguedad", cache.Respuestas(db, ttlCacheCompras, "cxP"), antiguedadCxp(db))
+}
+
+func PagoRoutes(api *gin.RouterGroup, db *sql.DB) {
+	api.GET("/", cache.Respuestas(db, ttlCacheCompras, "Pago"), buscarPagos(db))
+}
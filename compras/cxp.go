@@ -0,0 +1,352 @@
+package compras
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Valores de Status en dbo.cxP.
+	Una cuenta por pagar anulada no tiene saldo pendiente
+	aunque MontoAbonado sea 0.
+*/
+
+const (
+	StatusCxpPorCancelar = "0"
+	StatusCxpCancelado   = "1"
+	StatusCxpAnulado     = "2"
+	StatusCxpAbonado     = "3"
+)
+
+var estadosCxp = map[string]string{
+	"POR_CANCELAR": StatusCxpPorCancelar,
+	"CANCELADO":    StatusCxpCancelado,
+	"ANULADO":      StatusCxpAnulado,
+	"ABONADO":      StatusCxpAbonado,
+	"0":            StatusCxpPorCancelar,
+	"1":            StatusCxpCancelado,
+	"2":            StatusCxpAnulado,
+	"3":            StatusCxpAbonado,
+}
+
+/*
+	Valores de TipoDeCxp en dbo.cxP, los mismos que
+	TipoDeDocumento en las facturas de venta.
+*/
+
+const (
+	TipoCxpFactura     = "0"
+	TipoCxpNotaCredito = "1"
+	TipoCxpNotaDebito  = "2"
+)
+
+/*
+Monto total y saldo de una cuenta por pagar en SQL. El total
+incluye los otros impuestos; el saldo es el total menos lo
+abonado hasta hoy. En las notas de credito (TipoCxpNotaCredito)
+el saldo es credito a favor, no deuda.
+*/
+const (
+	totalCxpSQL = "(ISNULL(MontoExento, 0) + ISNULL(MontoGravado, 0) + ISNULL(MontoIva, 0) + ISNULL(TotalOtrosImpuestos, 0))"
+	saldoCxpSQL = "(" + totalCxpSQL + " - ISNULL(MontoAbonado, 0))"
+)
+
+// Saldos menores a medio centimo se consideran pagados
+const toleranciaSaldo = 0.005
+
+type CuentaPorPagar struct {
+	ConsecutivoCompania int        `json:"consecutivoCompania"`
+	ConsecutivoCxp      int        `json:"consecutivoCxp"`
+	Numero              string     `json:"numero"`
+	NumeroControl       *string    `json:"numeroControl"`
+	TipoDeCxp           *string    `json:"tipoDeCxp"`
+	Status              *string    `json:"status"`
+	CodigoProveedor     string     `json:"codigoProveedor"`
+	Fecha               *time.Time `json:"fecha"`
+	FechaVencimiento    *time.Time `json:"fechaVencimiento"`
+	FechaCancelacion    *time.Time `json:"fechaCancelacion"`
+	FechaAnulacion      *time.Time `json:"fechaAnulacion"`
+	CodigoMoneda        string     `json:"codigoMoneda"`
+	CambioABolivares    *float64   `json:"cambioABolivares"`
+	MontoExento         *float64   `json:"montoExento"`
+	MontoGravado        *float64   `json:"montoGravado"`
+	MontoIva            *float64   `json:"montoIva"`
+	TotalOtrosImpuestos *float64   `json:"totalOtrosImpuestos"`
+	MontoAbonado        *float64   `json:"montoAbonado"`
+	CreditoFiscal       *string    `json:"creditoFiscal"`
+	Observaciones       *string    `json:"observaciones"`
+	Total               float64    `json:"total"`
+	Saldo               float64    `json:"saldo"`
+	DiasVencida         int        `json:"diasVencida"` // 0 si no esta vencida
+}
+
+const columnasCxp = `
+	ConsecutivoCompania, ConsecutivoCxp, Numero, NumeroControl, TipoDeCxp, Status, CodigoProveedor,
+	Fecha, FechaVencimiento, FechaCancelacion, FechaAnulacion, CodigoMoneda, CambioAbolivares,
+	MontoExento, MontoGravado, MontoIva, TotalOtrosImpuestos, MontoAbonado, CreditoFiscal, Observaciones,
+	` + totalCxpSQL + `, ` + saldoCxpSQL
+
+/*
+	Filtros de busqueda de cuentas por pagar ya validados.
+*/
+
+type filtrosCxp struct {
+	codigoProveedor  string
+	estado           string // Tal como se recibio (nombre o numero)
+	codigoEstado     string // Valor de Status
+	tipo             string
+	desde            *time.Time
+	hasta            *time.Time
+	vencimientoDesde *time.Time
+	vencimientoHasta *time.Time
+	soloPendientes   bool
+	soloVencidas     bool
+	page             int
+	pageSize         int
+}
+
+/*
+Lee y valida los querys de busqueda de cuentas por pagar:
+
+codigoProveedor: codigo exacto del proveedor
+status: POR_CANCELAR, CANCELADO, ANULADO, ABONADO o 0, 1, 2, 3
+tipoDeCxp: valor exacto de TipoDeCxp
+desde, hasta: rango de la fecha del documento
+vencimientoDesde, vencimientoHasta: rango de FechaVencimiento
+pendientes: si/true para traer solo las que tienen saldo
+vencidas: si/true para traer solo las vencidas con saldo
+page, pageSize: paginacion, por defecto 1 y 100
+*/
+func parsearFiltrosCxp(c *gin.Context) (filtrosCxp, []respuesta.ErrorParametro) {
+	var v respuesta.Validador
+	var f filtrosCxp
+
+	f.codigoProveedor = strings.TrimSpace(c.Query("codigoProveedor"))
+	f.tipo = strings.TrimSpace(c.Query("tipoDeCxp"))
+
+	estado := strings.ToUpper(c.Query("status"))
+	if codigo, ok := v.Opcion(estado, "status", estadosCxp,
+		"Status inválido. Use: 0 (POR_CANCELAR), 1 (CANCELADO), 2 (ANULADO), 3 (ABONADO) o sus nombres"); ok {
+		f.estado = estado
+		f.codigoEstado = codigo
+	}
+
+	f.desde, f.hasta = v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+	f.vencimientoDesde = v.Fecha(c.Query("vencimientoDesde"), "vencimientoDesde")
+	f.vencimientoHasta = v.Fecha(c.Query("vencimientoHasta"), "vencimientoHasta")
+	if f.vencimientoDesde != nil && f.vencimientoHasta != nil && f.vencimientoHasta.Before(*f.vencimientoDesde) {
+		v.Agregar("vencimientoHasta", "La fecha vencimientoHasta no puede ser anterior a vencimientoDesde")
+	}
+	if f.vencimientoHasta != nil {
+		siguiente := f.vencimientoHasta.AddDate(0, 0, 1)
+		f.vencimientoHasta = &siguiente
+	}
+
+	pendientes := strings.ToLower(c.Query("pendientes"))
+	f.soloPendientes = pendientes == "si" || pendientes == "true"
+	vencidas := strings.ToLower(c.Query("vencidas"))
+	f.soloVencidas = vencidas == "si" || vencidas == "true"
+
+	f.page = v.Positivo(c.Query("page"), "page", 1)
+	f.pageSize = v.Positivo(c.Query("pageSize"), "pageSize", 100)
+
+	return f, v.Errores()
+}
+
+// Construye el WHERE (sin paginacion) y sus parametros
+func (f filtrosCxp) condicion(hoy time.Time) (string, []interface{}) {
+	filterQuery := " WHERE 1=1"
+	params := []interface{}{}
+
+	if f.codigoProveedor != "" {
+		filterQuery += " AND CodigoProveedor = @codigoProveedor"
+		params = append(params, sql.Named("codigoProveedor", f.codigoProveedor))
+	}
+	if f.codigoEstado != "" {
+		filterQuery += " AND Status = @status"
+		params = append(params, sql.Named("status", f.codigoEstado))
+	}
+	if f.tipo != "" {
+		filterQuery += " AND TipoDeCxp = @tipo"
+		params = append(params, sql.Named("tipo", f.tipo))
+	}
+
+	// ----- Fecha del documento ----- //
+	if f.desde != nil {
+		filterQuery += " AND Fecha >= @desde"
+		params = append(params, sql.Named("desde", *f.desde))
+	}
+	if f.hasta != nil {
+		filterQuery += " AND Fecha < @hasta"
+		params = append(params, sql.Named("hasta", *f.hasta))
+	}
+
+	// ----- Fecha de vencimiento ----- //
+	if f.vencimientoDesde != nil {
+		filterQuery += " AND FechaVencimiento >= @vencimientoDesde"
+		params = append(params, sql.Named("vencimientoDesde", *f.vencimientoDesde))
+	}
+	if f.vencimientoHasta != nil {
+		filterQuery += " AND FechaVencimiento < @vencimientoHasta"
+		params = append(params, sql.Named("vencimientoHasta", *f.vencimientoHasta))
+	}
+
+	// ----- Saldo pendiente ----- //
+	if f.soloPendientes || f.soloVencidas {
+		filterQuery += " AND ISNULL(Status, '') <> @anulado AND " + saldoCxpSQL + " > @tolerancia"
+		params = append(params,
+			sql.Named("anulado", StatusCxpAnulado),
+			sql.Named("tolerancia", toleranciaSaldo),
+		)
+	}
+	if f.soloVencidas {
+		filterQuery += " AND FechaVencimiento < @hoy"
+		params = append(params, sql.Named("hoy", hoy))
+	}
+
+	return filterQuery, params
+}
+
+// Filtros aplicados, para informarlos en la respuesta
+func (f filtrosCxp) aplicados() map[string]interface{} {
+	filtros := map[string]interface{}{}
+	if f.codigoProveedor != "" {
+		filtros["codigoProveedor"] = f.codigoProveedor
+	}
+	if f.estado != "" {
+		filtros["status"] = f.estado
+	}
+	if f.tipo != "" {
+		filtros["tipoDeCxp"] = f.tipo
+	}
+	if f.desde != nil {
+		filtros["desde"] = f.desde.Format("2006-01-02")
+	}
+	if f.hasta != nil {
+		filtros["hasta"] = f.hasta.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	if f.vencimientoDesde != nil {
+		filtros["vencimientoDesde"] = f.vencimientoDesde.Format("2006-01-02")
+	}
+	if f.vencimientoHasta != nil {
+		filtros["vencimientoHasta"] = f.vencimientoHasta.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	if f.soloPendientes {
+		filtros["pendientes"] = true
+	}
+	if f.soloVencidas {
+		filtros["vencidas"] = true
+	}
+	return filtros
+}
+
+// Fecha de hoy sin hora
+func inicioDelDia(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Dias desde el vencimiento hasta la fecha de corte, 0 si no vencio
+func diasVencida(vencimiento *time.Time, corte time.Time) int {
+	if vencimiento == nil {
+		return 0
+	}
+	dias := int(corte.Sub(inicioDelDia(*vencimiento)).Hours() / 24)
+	if dias < 0 {
+		return 0
+	}
+	return dias
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Cuentas por pagar (ver parsearFiltrosCxp), ordenadas por
+fecha de vencimiento.
+*/
+func buscarCxp(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		hoy := inicioDelDia(requestTime)
+
+		filtros, errores := parsearFiltrosCxp(c)
+		if len(errores) > 0 {
+			logError(requestID+" - Parámetros de búsqueda de cuentas por pagar inválidos", nil)
+			respuesta.ErrorValidacion(c, errores)
+			return
+		}
+
+		filterQuery, params := filtros.condicion(hoy)
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.cxP"+filterQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de cuentas por pagar"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron cuentas por pagar con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT ` + columnasCxp + `
+			FROM dbo.cxP` + filterQuery + `
+			ORDER BY FechaVencimiento, Fecha, ConsecutivoCxp
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (filtros.page-1)*filtros.pageSize),
+			sql.Named("pageSize", filtros.pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar las cuentas por pagar"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		cuentas := []CuentaPorPagar{}
+		for rows.Next() {
+			var cx CuentaPorPagar
+			err := rows.Scan(
+				&cx.ConsecutivoCompania, &cx.ConsecutivoCxp, &cx.Numero, &cx.NumeroControl, &cx.TipoDeCxp, &cx.Status, &cx.CodigoProveedor,
+				&cx.Fecha, &cx.FechaVencimiento, &cx.FechaCancelacion, &cx.FechaAnulacion, &cx.CodigoMoneda, &cx.CambioABolivares,
+				&cx.MontoExento, &cx.MontoGravado, &cx.MontoIva, &cx.TotalOtrosImpuestos, &cx.MontoAbonado, &cx.CreditoFiscal, &cx.Observaciones,
+				&cx.Total, &cx.Saldo,
+			)
+			if err != nil {
+				mensaje := "Error al leer datos de cuentas por pagar"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			if cx.Status != nil && *cx.Status == StatusCxpAnulado {
+				cx.Saldo = 0
+			}
+			if cx.Saldo > toleranciaSaldo {
+				cx.DiasVencida = diasVencida(cx.FechaVencimiento, hoy)
+			}
+			cuentas = append(cuentas, cx)
+		}
+
+		logError(requestID+" - Cuentas por pagar encontradas: "+strconv.Itoa(total), nil)
+
+		respuesta.Pagina(c, "Cuentas por pagar encontradas", cuentas, len(cuentas),
+			respuesta.NuevaPaginacion(total, filtros.page, filtros.pageSize), filtros.aplicados())
+	}
+}
@@ -0,0 +1,158 @@
+package compras
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Pagos a proveedores (dbo.Pago). Cada pago es un
+	comprobante que cancela una o varias cuentas por pagar;
+	MontoCheque es lo que sale del banco despues de las
+	retenciones.
+*/
+
+type Pago struct {
+	ConsecutivoCompania  int        `json:"consecutivoCompania"`
+	NumeroComprobante    int        `json:"numeroComprobante"`
+	NumeroCheque         string     `json:"numeroCheque"`
+	StatusOrdenDePago    string     `json:"statusOrdenDePago"`
+	Fecha                time.Time  `json:"fecha"`
+	FechaAnulacion       *time.Time `json:"fechaAnulacion"`
+	CodigoProveedor      *string    `json:"codigoProveedor"`
+	Beneficiario         *string    `json:"beneficiario"`
+	DescripcionPago      *string    `json:"descripcionPago"`
+	FormaDePago          *string    `json:"formaDePago"`
+	CodigoCuentaBancaria string     `json:"codigoCuentaBancaria"`
+	CodigoMoneda         string     `json:"codigoMoneda"`
+	CambioABolivares     *float64   `json:"cambioABolivares"`
+	TotalDocumentos      *float64   `json:"totalDocumentos"`
+	TotalRetenido        *float64   `json:"totalRetenido"`
+	TotalRetenidoIva     *float64   `json:"totalRetenidoIva"`
+	TotalOtros           *float64   `json:"totalOtros"`
+	MontoCheque          *float64   `json:"montoCheque"`
+}
+
+const columnasPago = `
+	ConsecutivoCompania, NumeroComprobante, NumeroCheque, StatusOrdenDePago, Fecha, FechaAnulacion,
+	CodigoProveedor, Beneficiario, DescripcionPago, FormaDePago, CodigoCuentaBancaria, CodigoMoneda,
+	CambioaBolivares, TotalDocumentos, TotalRetenido, TotalRetenidoIva, TotalOtros, MontoCheque`
+
+/*
+Pagos a proveedores.
+Querys:
+
+codigoProveedor: codigo exacto del proveedor
+status: valor exacto de StatusOrdenDePago
+formaDePago: valor exacto de FormaDePago
+codigoCuentaBancaria: cuenta de la que salio el pago
+desde, hasta: rango de la fecha del pago
+page, pageSize: paginacion, por defecto 1 y 100
+*/
+func buscarPagos(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		desde, hasta := v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize := v.Positivo(c.Query("pageSize"), "pageSize", 100)
+		if !v.Valido() {
+			logError(requestID+" - Parámetros de búsqueda de pagos inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filterQuery := " WHERE 1=1"
+		params := []interface{}{}
+		aplicados := map[string]interface{}{}
+
+		// Filtros exactos: query -> columna
+		for _, filtro := range []struct{ query, columna string }{
+			{"codigoProveedor", "CodigoProveedor"},
+			{"status", "StatusOrdenDePago"},
+			{"formaDePago", "FormaDePago"},
+			{"codigoCuentaBancaria", "CodigoCuentaBancaria"},
+		} {
+			if valor := strings.TrimSpace(c.Query(filtro.query)); valor != "" {
+				filterQuery += " AND " + filtro.columna + " = @" + filtro.query
+				params = append(params, sql.Named(filtro.query, valor))
+				aplicados[filtro.query] = valor
+			}
+		}
+		if desde != nil {
+			filterQuery += " AND Fecha >= @desde"
+			params = append(params, sql.Named("desde", *desde))
+			aplicados["desde"] = desde.Format("2006-01-02")
+		}
+		if hasta != nil {
+			filterQuery += " AND Fecha < @hasta"
+			params = append(params, sql.Named("hasta", *hasta))
+			aplicados["hasta"] = hasta.AddDate(0, 0, -1).Format("2006-01-02")
+		}
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.Pago"+filterQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de pagos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron pagos con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT ` + columnasPago + `
+			FROM dbo.Pago` + filterQuery + `
+			ORDER BY Fecha DESC, NumeroComprobante DESC
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar los pagos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		pagos := []Pago{}
+		for rows.Next() {
+			var p Pago
+			err := rows.Scan(
+				&p.ConsecutivoCompania, &p.NumeroComprobante, &p.NumeroCheque, &p.StatusOrdenDePago, &p.Fecha, &p.FechaAnulacion,
+				&p.CodigoProveedor, &p.Beneficiario, &p.DescripcionPago, &p.FormaDePago, &p.CodigoCuentaBancaria, &p.CodigoMoneda,
+				&p.CambioABolivares, &p.TotalDocumentos, &p.TotalRetenido, &p.TotalRetenidoIva, &p.TotalOtros, &p.MontoCheque,
+			)
+			if err != nil {
+				mensaje := "Error al leer datos de pagos"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			pagos = append(pagos, p)
+		}
+
+		logError(requestID+" - Pagos encontrados: "+strconv.Itoa(total), nil)
+
+		respuesta.Pagina(c, "Pagos encontrados", pagos, len(pagos),
+			respuesta.NuevaPaginacion(total, page, pageSize), aplicados)
+	}
+}
@@ -0,0 +1,125 @@
+package docs
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Documentacion de la API.
+
+	La especificacion OpenAPI 3 se mantiene a mano en
+	openapi.json y se embebe en el binario. Se sirve en
+	/openapi.json junto con un explorador Redoc en /docs.
+
+	Redoc no se embebe: /docs lo carga desde la CDN de Redoc
+	con una version fija, asi que el explorador necesita
+	acceso a internet en el navegador. /openapi.json no.
+
+	Cada vez que se agrega una ruta en Gin se debe agregar
+	tambien en openapi.json, de lo contrario falla
+	docs_test.go y la API avisa al arrancar (ver
+	VerificarRutas).
+*/
+
+//go:embed openapi.json
+var especificacion []byte
+
+// Version de Redoc que se carga desde la CDN
+const VersionRedoc = "2.1.5"
+
+const paginaExplorador = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>API Galac BD</title>
+    <meta charset="utf-8"/>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+  </head>
+  <body>
+    <redoc spec-url="/openapi.json"></redoc>
+    <script src="https://cdn.redoc.ly/redoc/v` + VersionRedoc + `/bundles/redoc.standalone.js"></script>
+  </body>
+</html>`
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+func DocsRoutes(router *gin.Engine) {
+	router.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", especificacion)
+	})
+
+	router.GET("/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(paginaExplorador))
+	})
+}
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+/*
+Convierte una ruta de Gin al formato de OpenAPI
+Ejemplo: /suscripciones/:codigo/facturas => /suscripciones/{codigo}/facturas
+*/
+func rutaOpenAPI(ruta string) string {
+	partes := strings.Split(ruta, "/")
+	for i, parte := range partes {
+		if strings.HasPrefix(parte, ":") || strings.HasPrefix(parte, "*") {
+			partes[i] = "{" + parte[1:] + "}"
+		}
+	}
+	return strings.Join(partes, "/")
+}
+
+/*
+Devuelve las rutas registradas en Gin que no estan
+documentadas en openapi.json, con el formato "GET /ruta".
+*/
+func RutasSinDocumentar(rutas gin.RoutesInfo) ([]string, error) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(especificacion, &spec); err != nil {
+		return nil, err
+	}
+
+	var faltantes []string
+	for _, ruta := range rutas {
+		operaciones, ok := spec.Paths[rutaOpenAPI(ruta.Path)]
+		if ok {
+			_, ok = operaciones[strings.ToLower(ruta.Method)]
+		}
+		if !ok {
+			faltantes = append(faltantes, ruta.Method+" "+ruta.Path)
+		}
+	}
+	sort.Strings(faltantes)
+
+	return faltantes, nil
+}
+
+/*
+Verifica al arrancar que todas las rutas de Gin esten en
+openapi.json. Solo registra una advertencia: la que impide
+que la documentacion quede desactualizada es docs_test.go.
+*/
+func VerificarRutas(router *gin.Engine) {
+	faltantes, err := RutasSinDocumentar(router.Routes())
+	if err != nil {
+		log.Println("[ADVERTENCIA] Error al leer openapi.json:", err.Error())
+		return
+	}
+	if len(faltantes) > 0 {
+		log.Println("[ADVERTENCIA] Rutas sin documentar en openapi.json: " + strings.Join(faltantes, ", "))
+		return
+	}
+	log.Println("Todas las rutas estan documentadas en openapi.json")
+}
@@ -0,0 +1,31 @@
+package docs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/desarrolladoresnet/api_galac_bd/docs"
+	"github.com/desarrolladoresnet/api_galac_bd/rutas"
+	"github.com/gin-gonic/gin"
+)
+
+// Todas las rutas registradas en Gin deben estar en openapi.json
+func TestRutasDocumentadas(t *testing.T) {
+	// Los loggers de los modulos crean sus archivos en el directorio actual
+	t.Chdir(t.TempDir())
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	rutas.Registrar(router, nil)
+	if len(router.Routes()) == 0 {
+		t.Fatal("No se registro ninguna ruta")
+	}
+
+	faltantes, err := docs.RutasSinDocumentar(router.Routes())
+	if err != nil {
+		t.Fatalf("Error al leer openapi.json: %v", err)
+	}
+	if len(faltantes) > 0 {
+		t.Errorf("Rutas sin documentar en openapi.json:\n%s", strings.Join(faltantes, "\n"))
+	}
+}
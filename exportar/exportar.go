@@ -0,0 +1,256 @@
+package exportar
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Exportacion de reportes a CSV y XLSX.
+
+	El XLSX se arma directamente con archive/zip (un libro
+	con una sola hoja), para no agregar dependencias solo
+	para escribir tablas simples.
+
+	Las celdas aceptan string, int, float64, time.Time,
+	sus punteros y nil. Las fechas se escriben como texto
+	con formato AAAA-MM-DD.
+*/
+
+const (
+	FormatoJSON = "json"
+	FormatoCSV  = "csv"
+	FormatoXLSX = "xlsx"
+)
+
+// Tabla a exportar
+type Tabla struct {
+	Columnas []string
+	Filas    [][]interface{}
+}
+
+func (t *Tabla) Agregar(fila ...interface{}) {
+	t.Filas = append(t.Filas, fila)
+}
+
+/*
+Lee el query "formato" (json, csv o xlsx).
+Retorna false si el formato no es valido.
+*/
+func Formato(c *gin.Context) (string, bool) {
+	formato := strings.ToLower(c.DefaultQuery("formato", FormatoJSON))
+	switch formato {
+	case FormatoJSON, FormatoCSV, FormatoXLSX:
+		return formato, true
+	}
+	return formato, false
+}
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+// Convierte el valor de una celda a texto (o nil si esta vacia)
+func valorCelda(valor interface{}) (string, bool, bool) {
+	switch v := valor.(type) {
+	case nil:
+		return "", false, false
+	case string:
+		return v, false, true
+	case *string:
+		if v == nil {
+			return "", false, false
+		}
+		return *v, false, true
+	case int:
+		return strconv.Itoa(v), true, true
+	case *int:
+		if v == nil {
+			return "", false, false
+		}
+		return strconv.Itoa(*v), true, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true, true
+	case *float64:
+		if v == nil {
+			return "", false, false
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64), true, true
+	case time.Time:
+		return v.Format("2006-01-02"), false, true
+	case *time.Time:
+		if v == nil {
+			return "", false, false
+		}
+		return v.Format("2006-01-02"), false, true
+	}
+	return fmt.Sprint(valor), false, true
+}
+
+// Envia la tabla como archivo CSV (UTF-8 con BOM para que Excel lea los acentos)
+func CSV(c *gin.Context, nombreArchivo string, t Tabla) error {
+	var buf bytes.Buffer
+	buf.WriteString("\uFEFF")
+
+	w := csv.NewWriter(&buf)
+	if err := w.Write(t.Columnas); err != nil {
+		return err
+	}
+	for _, fila := range t.Filas {
+		registro := make([]string, len(fila))
+		for i, valor := range fila {
+			registro[i], _, _ = valorCelda(valor)
+		}
+		if err := w.Write(registro); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+nombreArchivo+`.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	return nil
+}
+
+// Envia la tabla como archivo XLSX con una sola hoja
+func XLSX(c *gin.Context, nombreArchivo string, hoja string, t Tabla) error {
+	contenido, err := libroXLSX(hoja, t)
+	if err != nil {
+		return err
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+nombreArchivo+`.xlsx"`)
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", contenido)
+	return nil
+}
+
+// Envia la tabla en el formato indicado (csv o xlsx)
+func Enviar(c *gin.Context, formato string, nombreArchivo string, hoja string, t Tabla) error {
+	if formato == FormatoXLSX {
+		return XLSX(c, nombreArchivo, hoja, t)
+	}
+	return CSV(c, nombreArchivo, t)
+}
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+// Nombre de la columna en Excel: 0 => A, 25 => Z, 26 => AA
+func nombreColumna(i int) string {
+	nombre := ""
+	for i >= 0 {
+		nombre = string(rune('A'+i%26)) + nombre
+		i = i/26 - 1
+	}
+	return nombre
+}
+
+func escaparXML(texto string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(texto))
+	return buf.String()
+}
+
+func escribirCelda(buf *bytes.Buffer, ref string, valor interface{}, estilo int) {
+	texto, numerico, ok := valorCelda(valor)
+	if !ok {
+		return
+	}
+	if numerico {
+		fmt.Fprintf(buf, `<c r="%s" s="%d"><v>%s</v></c>`, ref, estilo, texto)
+		return
+	}
+	fmt.Fprintf(buf, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, estilo, escaparXML(texto))
+}
+
+func hojaXML(t Tabla) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	// Encabezado en negrita (estilo 1)
+	buf.WriteString(`<row r="1">`)
+	for i, columna := range t.Columnas {
+		escribirCelda(&buf, nombreColumna(i)+"1", columna, 1)
+	}
+	buf.WriteString(`</row>`)
+
+	for f, fila := range t.Filas {
+		numero := strconv.Itoa(f + 2)
+		buf.WriteString(`<row r="` + numero + `">`)
+		for i, valor := range fila {
+			escribirCelda(&buf, nombreColumna(i)+numero, valor, 0)
+		}
+		buf.WriteString(`</row>`)
+	}
+
+	buf.WriteString(`</sheetData></worksheet>`)
+	return buf.Bytes()
+}
+
+func libroXLSX(hoja string, t Tabla) ([]byte, error) {
+	// Excel limita el nombre de la hoja a 31 caracteres, no bytes (Año, Crédito)
+	if r := []rune(hoja); len(r) > 31 {
+		hoja = string(r[:31])
+	}
+
+	archivos := []struct {
+		nombre    string
+		contenido string
+	}{
+		{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + escaparXML(hoja) + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+			`</Relationships>`},
+		{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+			`</styleSheet>`},
+		{"xl/worksheets/sheet1.xml", string(hojaXML(t))},
+	}
+
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for _, archivo := range archivos {
+		w, err := z.Create(archivo.nombre)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(archivo.contenido)); err != nil {
+			return nil, err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,256 @@
+package facturas
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	Campos definibles de las facturas (dbo.camposDefFactura).
+
+	Galac permite guardar hasta 12 textos libres por factura
+	(CampoDefinible1..12). Lo que significa cada uno depende
+	de la compania, por eso los nombres se configuran en un
+	archivo JSON con el ConsecutivoCompania como clave:
+
+	{"1": {"ordenOdoo": 1, "direccionEntrega": 2}}
+
+	Sin archivo las facturas no traen camposDefinidos.
+*/
+
+// Compania => nombre => numero de CampoDefinible
+var camposDefinidos = map[int]map[string]int{}
+
+const maxCampoDefinible = 12
+
+// Nombres validos para usar como clave JSON y en los querys
+var nombreCampoValido = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+/*
+Lee los nombres de los campos definibles desde el archivo.
+Si el archivo no existe no se configura ningun campo; si
+existe pero no es valido se retorna el error.
+*/
+func CargarCamposDefinidos(ruta string) error {
+	contenido, err := os.ReadFile(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("Sin campos definibles de facturas configurados (" + ruta + ")")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var archivo map[string]map[string]int
+	if err := json.Unmarshal(contenido, &archivo); err != nil {
+		return err
+	}
+
+	config := map[int]map[string]int{}
+	for clave, campos := range archivo {
+		compania, err := strconv.Atoi(clave)
+		if err != nil {
+			return fmt.Errorf("la compañía %q debe ser el ConsecutivoCompania", clave)
+		}
+		usados := map[int]string{}
+		for nombre, numero := range campos {
+			if !nombreCampoValido.MatchString(nombre) {
+				return fmt.Errorf("compañía %d: nombre de campo inválido %q", compania, nombre)
+			}
+			if numero < 1 || numero > maxCampoDefinible {
+				return fmt.Errorf("compañía %d: el campo %s debe ser un número entre 1 y %d", compania, nombre, maxCampoDefinible)
+			}
+			if otro, ok := usados[numero]; ok {
+				return fmt.Errorf("compañía %d: CampoDefinible%d está asignado a %s y a %s", compania, numero, otro, nombre)
+			}
+			usados[numero] = nombre
+		}
+		config[compania] = campos
+	}
+
+	camposDefinidos = config
+	log.Println("Campos definibles de facturas cargados para " + strconv.Itoa(len(config)) + " compañías")
+	return nil
+}
+
+// Columna de camposDefFactura del campo numero n
+func columnaCampo(n int) string {
+	return "CampoDefinible" + strconv.Itoa(n)
+}
+
+// Indica si el nombre esta configurado en alguna compania
+func campoConfigurado(nombre string) bool {
+	for _, campos := range camposDefinidos {
+		if _, ok := campos[nombre]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Condicion SQL para filtrar facturas (sin alias) por campos
+definidos. El mismo nombre puede ser un CampoDefinible
+distinto en cada compania, por eso se arma una alternativa
+por compania. Los nombres ya deben estar validados.
+*/
+func condicionCamposDefinidos(filtros map[string]string) (string, []interface{}) {
+	nombres := make([]string, 0, len(filtros))
+	for nombre := range filtros {
+		nombres = append(nombres, nombre)
+	}
+	sort.Strings(nombres)
+
+	companias := make([]int, 0, len(camposDefinidos))
+	for compania := range camposDefinidos {
+		companias = append(companias, compania)
+	}
+	sort.Ints(companias)
+
+	params := []interface{}{}
+	alternativas := []string{}
+	for _, compania := range companias {
+		condiciones := []string{"cd.ConsecutivoCompania = " + strconv.Itoa(compania)}
+		for i, nombre := range nombres {
+			numero, ok := camposDefinidos[compania][nombre]
+			if !ok {
+				condiciones = nil
+				break
+			}
+			condiciones = append(condiciones, "cd."+columnaCampo(numero)+" = @campoDefinido"+strconv.Itoa(i))
+		}
+		if condiciones != nil {
+			alternativas = append(alternativas, "("+strings.Join(condiciones, " AND ")+")")
+		}
+	}
+	for i, nombre := range nombres {
+		params = append(params, sql.Named("campoDefinido"+strconv.Itoa(i), filtros[nombre]))
+	}
+
+	// Ninguna compania tiene todos los campos pedidos
+	if len(alternativas) == 0 {
+		return " AND 1=0", params
+	}
+
+	return ` AND EXISTS (
+		SELECT 1 FROM dbo.camposDefFactura cd
+		WHERE cd.ConsecutivoCompania = factura.ConsecutivoCompania
+		  AND cd.NumeroFactura = factura.Numero
+		  AND cd.TipoDeDocumento = factura.TipoDeDocumento
+		  AND (` + strings.Join(alternativas, " OR ") + `))`, params
+}
+
+/*
+Maximo de numeros de factura por consulta a camposDefFactura.
+SQL Server acepta 2100 parametros por consulta y la pagina de
+facturas no tiene limite, por eso se consulta por lotes.
+*/
+const loteCamposDefinidos = 500
+
+// Identifica una factura dentro de camposDefFactura
+type claveFactura struct {
+	compania int
+	numero   string
+	tipo     string
+}
+
+/*
+Agrega camposDefinidos a las facturas de las companias
+que tienen nombres configurados. Los campos sin valor se
+envian en null.
+*/
+func agregarCamposDefinidos(db *sql.DB, facturas []Factura) error {
+	if len(camposDefinidos) == 0 || len(facturas) == 0 {
+		return nil
+	}
+
+	// Numeros de factura por compania, sin repetir
+	numeros := map[int][]string{}
+	vistos := map[int]map[string]bool{}
+	posicion := map[claveFactura]int{}
+	for i := range facturas {
+		compania := facturas[i].ConsecutivoCompania
+		campos, ok := camposDefinidos[compania]
+		if !ok {
+			continue
+		}
+		facturas[i].CamposDefinidos = map[string]*string{}
+		for nombre := range campos {
+			facturas[i].CamposDefinidos[nombre] = nil
+		}
+		posicion[claveFactura{compania, facturas[i].Numero, facturas[i].TipoDeDocumento}] = i
+
+		if vistos[compania] == nil {
+			vistos[compania] = map[string]bool{}
+		}
+		if !vistos[compania][facturas[i].Numero] {
+			vistos[compania][facturas[i].Numero] = true
+			numeros[compania] = append(numeros[compania], facturas[i].Numero)
+		}
+	}
+
+	for compania, lista := range numeros {
+		for inicio := 0; inicio < len(lista); inicio += loteCamposDefinidos {
+			fin := min(inicio+loteCamposDefinidos, len(lista))
+			if err := leerCamposDefinidos(db, compania, lista[inicio:fin], facturas, posicion); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Lee los campos de un lote de facturas de la compania
+func leerCamposDefinidos(db *sql.DB, compania int, numeros []string, facturas []Factura, posicion map[claveFactura]int) error {
+	nombres := make([]string, len(numeros))
+	params := []interface{}{sql.Named("compania", compania)}
+	for i, numero := range numeros {
+		nombres[i] = "@f" + strconv.Itoa(i)
+		params = append(params, sql.Named("f"+strconv.Itoa(i), numero))
+	}
+
+	columnas := make([]string, maxCampoDefinible)
+	for n := 1; n <= maxCampoDefinible; n++ {
+		columnas[n-1] = columnaCampo(n)
+	}
+
+	rows, err := db.Query(`
+		SELECT ConsecutivoCompania, NumeroFactura, TipoDeDocumento, `+strings.Join(columnas, ", ")+`
+		FROM dbo.camposDefFactura
+		WHERE ConsecutivoCompania = @compania
+		  AND NumeroFactura IN (`+strings.Join(nombres, ", ")+`)
+	`, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clave claveFactura
+		valores := make([]*string, maxCampoDefinible)
+		destinos := []interface{}{&clave.compania, &clave.numero, &clave.tipo}
+		for i := range valores {
+			destinos = append(destinos, &valores[i])
+		}
+		if err := rows.Scan(destinos...); err != nil {
+			return err
+		}
+
+		i, ok := posicion[clave]
+		if !ok {
+			continue
+		}
+		for nombre, numero := range camposDefinidos[clave.compania] {
+			facturas[i].CamposDefinidos[nombre] = valores[numero-1]
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,80 @@
+package facturas
+
+import (
+	"database/sql"
+
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+)
+
+/*
+	Cobros de las facturas con cobro directo
+	(GeneraCobroDirecto). Cada renglon de
+	dbo.renglonCobroDeFactura es una forma de pago: efectivo,
+	tarjeta por punto de venta, transferencia, etc.
+*/
+
+type CobroFactura struct {
+	ConsecutivoRenglon        int      `json:"consecutivoRenglon"`
+	CodigoFormaDelCobro       *string  `json:"codigoFormaDelCobro"`
+	NumeroDelDocumento        *string  `json:"numeroDelDocumento"`
+	CodigoBanco               *int     `json:"codigoBanco"`
+	CodigoPuntoDeVenta        *int     `json:"codigoPuntoDeVenta"`
+	NumeroDocumentoAprobacion *string  `json:"numeroDocumentoAprobacion"`
+	CodigoMoneda              *string  `json:"codigoMoneda"`
+	Monto                     *float64 `json:"monto"`
+	CambioAMonedaLocal        *float64 `json:"cambioAMonedaLocal"`
+	MontoMonedaLocal          *float64 `json:"montoMonedaLocal"` // Monto por CambioAMonedaLocal
+	InfoAdicional             *string  `json:"infoAdicional"`
+
+	// MontoMonedaLocal convertido en el detalle de la factura (query moneda)
+	Conversion *monedas.Resultado `json:"conversion,omitempty"`
+}
+
+/*
+Monto del cobro en moneda local. Si el cambio no viene o
+es cero el monto ya esta en moneda local.
+*/
+func (r CobroFactura) montoMonedaLocal() *float64 {
+	if r.Monto == nil {
+		return nil
+	}
+	monto := *r.Monto
+	if r.CambioAMonedaLocal != nil && *r.CambioAMonedaLocal != 0 {
+		monto *= *r.CambioAMonedaLocal
+	}
+	return &monto
+}
+
+// Formas de pago registradas para la factura
+func cobrosDeFactura(db *sql.DB, factura Factura) ([]CobroFactura, error) {
+	rows, err := db.Query(`
+		SELECT ConsecutivoRenglon, CodigoFormaDelCobro, NumeroDelDocumento, CodigoBanco, CodigoPuntoDeVenta,
+		       NumeroDocumentoAprobacion, CodigoMoneda, Monto, CambioAMonedaLocal, InfoAdicional
+		FROM dbo.renglonCobroDeFactura
+		WHERE ConsecutivoCompania = @compania
+		  AND NumeroFactura = @numero
+		  AND TipoDeDocumento = @tipoDocumento
+		ORDER BY ConsecutivoRenglon
+	`,
+		sql.Named("compania", factura.ConsecutivoCompania),
+		sql.Named("numero", factura.Numero),
+		sql.Named("tipoDocumento", factura.TipoDeDocumento),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cobros := []CobroFactura{}
+	for rows.Next() {
+		var r CobroFactura
+		if err := rows.Scan(&r.ConsecutivoRenglon, &r.CodigoFormaDelCobro, &r.NumeroDelDocumento, &r.CodigoBanco,
+			&r.CodigoPuntoDeVenta, &r.NumeroDocumentoAprobacion, &r.CodigoMoneda, &r.Monto,
+			&r.CambioAMonedaLocal, &r.InfoAdicional); err != nil {
+			return nil, err
+		}
+		r.MontoMonedaLocal = r.montoMonedaLocal()
+		cobros = append(cobros, r)
+	}
+	return cobros, rows.Err()
+}
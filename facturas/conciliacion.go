@@ -0,0 +1,366 @@
+package facturas
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/odoo"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Conciliacion mensual de suscripciones de Odoo contra Galac.
+
+	Cada mes se debe verificar que todas las suscripciones
+	activas de Odoo tengan su factura en Galac. Se recibe la
+	lista de codigos SUB (JSON o archivo CSV) y se buscan en
+	dbo.factura por el codigo en Observaciones y por el codigo
+	de cliente (NSUB75885). Solo cuentan las facturas, no las
+	notas de credito o debito de la suscripcion.
+
+	Aunque la ruta es POST no se escribe nada en Galac, el
+	POST solo se usa para poder recibir la lista o el archivo.
+*/
+
+// Cantidad maxima de suscripciones por conciliacion
+const maxSuscripcionesConciliacion = 20000
+
+// Tamaño maximo del cuerpo (JSON o archivo), 2 MB sobran para el maximo de suscripciones
+const maxBytesConciliacion = 2 << 20
+
+// Datos minimos de una factura para la conciliacion
+type FacturaConciliada struct {
+	Numero            string    `json:"numero"`
+	Fecha             time.Time `json:"fecha"`
+	CodigoCliente     *string   `json:"codigoCliente"`
+	StatusFactura     *string   `json:"statusFactura"`
+	Cancelada         string    `json:"cancelada"`
+	MotivoDeAnulacion *string   `json:"motivoDeAnulacion"`
+	TotalFactura      *float64  `json:"totalFactura"`
+	MesFacturado      int       `json:"mesFacturado"`
+	AnioFacturado     int       `json:"anioFacturado"`
+}
+
+type SuscripcionConciliada struct {
+	Suscripcion string              `json:"suscripcion"`
+	Facturas    []FacturaConciliada `json:"facturas"`
+}
+
+type ResultadoConciliacion struct {
+	Mes                int                     `json:"mes"`
+	Anio               int                     `json:"anio"`
+	TotalSuscripciones int                     `json:"totalSuscripciones"`
+	Facturadas         []SuscripcionConciliada `json:"facturadas"`
+	Duplicadas         []SuscripcionConciliada `json:"duplicadas"`
+	Anuladas           []SuscripcionConciliada `json:"anuladas"`
+	Faltantes          []string                `json:"faltantes"`
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Lee los codigos de suscripcion del cuerpo de la peticion.
+Se acepta:
+  - JSON: {"suscripciones": ["SUB75885", ...]}
+  - multipart/form-data con un archivo CSV en el campo "archivo",
+    con el codigo en la primera columna (el encabezado se ignora)
+
+Retorna los codigos normalizados sin repetir y los invalidos.
+El cuerpo se corta en maxBytesConciliacion.
+*/
+func leerSuscripciones(c *gin.Context) ([]string, []string, error) {
+	var crudos []string
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytesConciliacion)
+
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		archivo, err := c.FormFile("archivo")
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := archivo.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+
+		crudos, err = leerCSV(f)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var cuerpo struct {
+			Suscripciones []string `json:"suscripciones"`
+		}
+		if err := c.ShouldBindJSON(&cuerpo); err != nil {
+			return nil, nil, err
+		}
+		crudos = cuerpo.Suscripciones
+	}
+
+	var codigos, invalidos []string
+	vistos := map[string]bool{}
+	for _, crudo := range crudos {
+		codigo, ok := odoo.NormalizarSuscripcion(crudo)
+		if !ok {
+			if strings.TrimSpace(crudo) != "" {
+				invalidos = append(invalidos, crudo)
+			}
+			continue
+		}
+		if !vistos[codigo] {
+			vistos[codigo] = true
+			codigos = append(codigos, codigo)
+		}
+	}
+
+	return codigos, invalidos, nil
+}
+
+/*
+Lee la primera columna de un CSV separado por coma o
+punto y coma. Si la primera fila no es un codigo SUB se
+toma como encabezado y se descarta.
+*/
+func leerCSV(r io.Reader) ([]string, error) {
+	contenido, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lector := csv.NewReader(strings.NewReader(string(contenido)))
+	lector.FieldsPerRecord = -1
+	lector.TrimLeadingSpace = true
+	primeraLinea, _, _ := strings.Cut(string(contenido), "\n")
+	if strings.Contains(primeraLinea, ";") && !strings.Contains(primeraLinea, ",") {
+		lector.Comma = ';'
+	}
+
+	registros, err := lector.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	valores := make([]string, 0, len(registros))
+	for i, registro := range registros {
+		if len(registro) == 0 {
+			continue
+		}
+		// La primera fila puede ser el encabezado
+		if _, ok := odoo.NormalizarSuscripcion(registro[0]); i == 0 && !ok {
+			continue
+		}
+		valores = append(valores, registro[0])
+	}
+	return valores, nil
+}
+
+/*
+Periodo que factura una factura de suscripcion.
+Se usa el mes de Observaciones si existe, de lo contrario
+el mes de la fecha. Si Observaciones no trae el año se toma
+el de la fecha, ajustado para facturas de diciembre emitidas
+en enero (y al reves).
+*/
+func periodoFacturado(fecha time.Time, obs odoo.Observaciones) (int, int) {
+	if obs.MesFacturado == nil {
+		return int(fecha.Month()), fecha.Year()
+	}
+
+	mes := *obs.MesFacturado
+	if obs.AnioFacturado != nil {
+		return mes, *obs.AnioFacturado
+	}
+
+	anio := fecha.Year()
+	diferencia := mes - int(fecha.Month())
+	if diferencia > 6 {
+		anio--
+	} else if diferencia < -6 {
+		anio++
+	}
+	return mes, anio
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Concilia una lista de suscripciones contra las facturas
+de un mes. Querys obligatorios: mes y anio.
+
+Resultado por suscripcion:
+  - facturadas: una sola factura emitida en el periodo
+  - duplicadas: mas de una factura emitida en el periodo
+  - anuladas: solo tiene facturas canceladas/anuladas
+  - faltantes: no tiene ninguna factura en el periodo
+
+Los borradores y notas de credito no cuentan como facturas.
+*/
+func conciliarSuscripciones(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando conciliación de suscripciones", nil)
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		periodo, hayPeriodo := v.MesAnio(c.Query("mes"), c.Query("anio"))
+		mes, anio := periodo.Mes, periodo.Anio
+
+		codigos, invalidos, err := leerSuscripciones(c)
+		var muyGrande *http.MaxBytesError
+		if errors.As(err, &muyGrande) {
+			logError(requestID+" - Lista de suscripciones demasiado grande", err)
+			v.Agregar("suscripciones", "El cuerpo no puede superar "+strconv.Itoa(maxBytesConciliacion>>20)+" MB")
+		} else if err != nil {
+			logError(requestID+" - Error al leer la lista de suscripciones", err)
+			v.Agregar("suscripciones", "Envíe un JSON {\"suscripciones\": [...]} o un archivo CSV en el campo archivo")
+		}
+		for _, invalido := range invalidos {
+			v.Agregar("suscripciones", "Código de suscripción inválido: "+invalido)
+		}
+		if err == nil && len(codigos) == 0 && len(invalidos) == 0 {
+			v.Agregar("suscripciones", "La lista de suscripciones está vacía")
+		}
+		if len(codigos) > maxSuscripcionesConciliacion {
+			v.Agregar("suscripciones", "Se permiten como máximo "+strconv.Itoa(maxSuscripcionesConciliacion)+" suscripciones")
+		}
+
+		if !v.Valido() || !hayPeriodo {
+			logError(requestID+" - Parámetros de conciliación inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		// ----- Busqueda de facturas ----- //
+		// Se incluye un mes antes y uno despues porque la factura
+		// de un mes puede emitirse a finales del mes anterior
+		desde := periodo.Desde.AddDate(0, -1, 0)
+		hasta := periodo.Hasta.AddDate(0, 1, 0)
+
+		query := `
+			SELECT Numero, Fecha, CodigoCliente, Observaciones, StatusFactura,
+			       Cancelada, MotivoDeAnulacion, TotalFactura
+			FROM dbo.factura
+			WHERE Fecha >= @desde AND Fecha < @hasta
+			  AND (Observaciones LIKE '%SUB%' OR CodigoCliente LIKE 'NSUB%')
+			  AND StatusFactura = @emitida
+			  AND TipoDeDocumento = @tipoFactura
+		`
+
+		rows, err := db.Query(query,
+			sql.Named("desde", desde),
+			sql.Named("hasta", hasta),
+			sql.Named("emitida", StatusEmitida),
+			sql.Named("tipoFactura", TipoDocumentoFactura),
+		)
+		if err != nil {
+			mensaje := "Error al consultar las facturas del periodo"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		solicitadas := map[string]bool{}
+		for _, codigo := range codigos {
+			solicitadas[codigo] = true
+		}
+
+		porSuscripcion := map[string][]FacturaConciliada{}
+		for rows.Next() {
+			var f FacturaConciliada
+			var observaciones *string
+			err := rows.Scan(&f.Numero, &f.Fecha, &f.CodigoCliente, &observaciones, &f.StatusFactura,
+				&f.Cancelada, &f.MotivoDeAnulacion, &f.TotalFactura)
+			if err != nil {
+				mensaje := "Error al leer datos de facturas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+
+			var obs odoo.Observaciones
+			if observaciones != nil {
+				obs = odoo.ParsearObservaciones(*observaciones)
+			}
+			f.MesFacturado, f.AnioFacturado = periodoFacturado(f.Fecha, obs)
+			if f.MesFacturado != mes || f.AnioFacturado != anio {
+				continue
+			}
+
+			// Codigos de la factura: los de Observaciones y el del cliente
+			codigosFactura := map[string]bool{}
+			for _, codigo := range obs.Suscripciones {
+				codigosFactura[codigo] = true
+			}
+			if f.CodigoCliente != nil {
+				if codigo, ok := odoo.NormalizarSuscripcion(*f.CodigoCliente); ok {
+					codigosFactura[codigo] = true
+				}
+			}
+
+			for codigo := range codigosFactura {
+				if solicitadas[codigo] {
+					porSuscripcion[codigo] = append(porSuscripcion[codigo], f)
+				}
+			}
+		}
+
+		// ----- Clasificacion ----- //
+		resultado := ResultadoConciliacion{
+			Mes:                mes,
+			Anio:               anio,
+			TotalSuscripciones: len(codigos),
+			Facturadas:         []SuscripcionConciliada{},
+			Duplicadas:         []SuscripcionConciliada{},
+			Anuladas:           []SuscripcionConciliada{},
+			Faltantes:          []string{},
+		}
+
+		sort.Strings(codigos)
+		for _, codigo := range codigos {
+			facturas := porSuscripcion[codigo]
+			if len(facturas) == 0 {
+				resultado.Faltantes = append(resultado.Faltantes, codigo)
+				continue
+			}
+
+			vigentes := 0
+			for _, f := range facturas {
+				if !EsAnulada(f.Cancelada, f.MotivoDeAnulacion) {
+					vigentes++
+				}
+			}
+
+			conciliada := SuscripcionConciliada{Suscripcion: codigo, Facturas: facturas}
+			switch {
+			case vigentes == 0:
+				resultado.Anuladas = append(resultado.Anuladas, conciliada)
+			case vigentes > 1:
+				resultado.Duplicadas = append(resultado.Duplicadas, conciliada)
+			default:
+				resultado.Facturadas = append(resultado.Facturadas, conciliada)
+			}
+		}
+
+		logError(requestID+" - Conciliación completada. Suscripciones: "+strconv.Itoa(len(codigos))+
+			", facturadas: "+strconv.Itoa(len(resultado.Facturadas))+
+			", duplicadas: "+strconv.Itoa(len(resultado.Duplicadas))+
+			", anuladas: "+strconv.Itoa(len(resultado.Anuladas))+
+			", faltantes: "+strconv.Itoa(len(resultado.Faltantes)), nil)
+
+		respuesta.Exito(c, "Conciliación de suscripciones completada", resultado, len(codigos))
+	}
+}
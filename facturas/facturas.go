@@ -3,12 +3,15 @@ package facturas
 import (
 	"database/sql"
 	"log"
-	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/odoo"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
 	"github.com/gin-gonic/gin"
 )
 
@@ -47,29 +50,26 @@ func logError(mensaje string, err error) {
 ////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////
 
+// Tiempo maximo que se guarda en cache una busqueda de facturas
+const ttlCacheFacturas = 5 * time.Minute
+
+// Tablas que lee el detalle: la factura, sus notas, cobros, otros cargos y campos definibles
+var tablasDetalleFactura = []string{"factura", "renglonCobroDeFactura", "renglonDetalleDeOtrosCargosFactura",
+	"otrosCargosDeFactura", "camposDefFactura"}
+
 func Facturas(api *gin.RouterGroup, db *sql.DB) {
 	initErrorLogger()
-	api.GET("/", buscarFacturas(db))
+	api.GET("/", cache.Respuestas(db, ttlCacheFacturas, "factura", "camposDefFactura"), buscarFacturas(db))
+	api.GET("/resumen", cache.Respuestas(db, ttlCacheFacturas, "factura"), resumenFacturas(db))
+	api.GET("/notas", cache.Respuestas(db, ttlCacheFacturas, "factura"), buscarNotas(db))
+	api.GET("/otros-cargos", cache.Respuestas(db, ttlCacheFacturas, "otrosCargosDeFactura"), catalogoOtrosCargos(db))
+	api.GET("/:numero", cache.Respuestas(db, ttlCacheFacturas, tablasDetalleFactura...), detalleFactura(db))
 }
 
 ////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////
 
-/*
-La funcion permite la busqueda de las facturas en
-SqlServer, recibe los siguientes parametros de busqueda
-mediante Querys:
-
-mes: debe ser numerico entre 1 y  12
-anio (año): año de busqueda de la factura
-codigoCliente: alfanumerico
-page: debe ser numerico o se setea en 1
-pageZise: debe ser numerico o se setea en 1000, no se
-
-	recomienda valores muy altos ya que tiende
-	a generar fallas en las API
-*/
 var meses = map[string]string{
 	"ENERO":      "1",
 	"FEBRERO":    "2",
@@ -85,141 +85,277 @@ var meses = map[string]string{
 	"DICIEMBRE":  "12",
 }
 
+// Valores de StatusFactura en dbo.factura
+const (
+	StatusEmitida     = "0"
+	StatusNotaCredito = "1"
+	StatusBorrador    = "2"
+)
+
+/*
+Un documento esta anulado si esta Cancelada o tiene motivo
+de anulacion. EsAnulada es la regla en Go y CondicionAnulada
+la misma en SQL; alias es el de dbo.factura con el punto.
+*/
+func EsAnulada(cancelada string, motivo *string) bool {
+	return cancelada == "S" || (motivo != nil && strings.TrimSpace(*motivo) != "")
+}
+
+func CondicionAnulada(alias string) string {
+	return "(ISNULL(" + alias + "Cancelada, 'N') = 'S' OR LTRIM(RTRIM(ISNULL(" + alias + "MotivoDeAnulacion, ''))) <> '')"
+}
+
 var estadosFactura = map[string]string{
-	"EMITIDA":      "0",
-	"BORRADOR":     "2",
-	"NOTA_CREDITO": "1",
-	"0":            "0",
-	"2":            "2",
-	"1":            "1",
+	"EMITIDA":      StatusEmitida,
+	"BORRADOR":     StatusBorrador,
+	"NOTA_CREDITO": StatusNotaCredito,
+	"0":            StatusEmitida,
+	"2":            StatusBorrador,
+	"1":            StatusNotaCredito,
 }
 
-func buscarFacturas(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		mesStr := c.Query("mes")
-		anioStr := c.Query("anio")
-		codigoCliente := c.Query("codigoCliente") // <-- NUEVO
-		pageStr := c.DefaultQuery("page", "1")
-		pageSizeStr := c.DefaultQuery("pageSize", "1000")
-		odooQuery := c.Query("odoo")
-		mesNombre := strings.ToUpper(c.Query("mesNombre"))         // Ejemplo: "ABRIL"
-		estadoFactura := strings.ToUpper(c.Query("estadoFactura")) // 0 = Emitida, 2 = Borrador, 1 = Nota de Credito en status Factura
-		numeroControl := strings.ToLower(c.Query("numeroControl")) // Convertir a minúsculas para comparación
+/*
+	Valores de TipoDeDocumento en dbo.factura.
+	Las notas de credito y debito se guardan en la misma
+	tabla que las facturas y se distinguen por este campo.
+*/
 
-		requestTime := time.Now()
-		requestID := requestTime.Format("20060102150405")
-		logError(requestID+" - Iniciando consulta de facturas", nil)
+const (
+	TipoDocumentoFactura     = "0"
+	TipoDocumentoNotaCredito = "1"
+	TipoDocumentoNotaDebito  = "2"
+)
 
-		// Verificar si solo se quieren números de control
-		soloNumerosControl := numeroControl == "si" || numeroControl == "true"
+/*
+	Filtros de busqueda de facturas ya validados.
+	Se obtienen de los querys con parsearFiltros y se
+	convierten en SQL con condicion.
+*/
 
-		// ------- Seteo de los parametros de Busqueda ----- //
+type filtrosFactura struct {
+	mes                int
+	anio               int
+	codigoCliente      string
+	odoo               string
+	suscripcion        string // Codigo SUB exacto
+	mesNombre          string
+	estadoFactura      string // Tal como se recibio (nombre o numero)
+	codigoEstado       string // Valor de StatusFactura
+	page               int
+	pageSize           int
+	soloNumerosControl bool
+	conversion         *monedas.Conversion // nil si no se pidio moneda
+	campos             map[string]string   // Campos definidos: nombre => valor exacto
+}
+
+/*
+Lee y valida los querys de busqueda de facturas:
+
+mes: debe ser numerico entre 1 y  12
+anio (año): año de busqueda de la factura
+codigoCliente: alfanumerico
+odoo: texto a buscar en Observaciones
+suscripcion: codigo SUB exacto de Odoo (ejemplo: SUB75885)
+mesNombre: ENERO, FEBRERO, ..., DICIEMBRE
+estadoFactura: EMITIDA, BORRADOR, NOTA_CREDITO o 0, 2, 1
+numeroControl: si/true para traer solo los numeros de control
+moneda: USD o VED para agregar los montos convertidos
+fechaCambio: AAAA-MM-DD, convierte con la tasa de esa fecha
+campo[nombre]: valor exacto de un campo definido (ver campos_definidos.go)
+page: debe ser numerico, por defecto 1
+pageZise: debe ser numerico, por defecto 1000, no se
+
+	recomienda valores muy altos ya que tiende
+	a generar fallas en las API
 
-		// Convertir page y pageSize a int
-		page, err := strconv.Atoi(pageStr)
-		if err != nil || page < 1 {
-			page = 1
+Retorna todos los parametros invalidos, no solo el primero.
+*/
+func parsearFiltros(c *gin.Context) (filtrosFactura, []respuesta.ErrorParametro) {
+	var v respuesta.Validador
+	var f filtrosFactura
+
+	f.mes, _ = v.Entero(c.Query("mes"), "mes", 1, 12)
+	f.anio, _ = v.Entero(c.Query("anio"), "anio", 1900, 2100)
+	f.codigoCliente = c.Query("codigoCliente")
+	f.odoo = c.Query("odoo")
+
+	if suscripcion := c.Query("suscripcion"); suscripcion != "" {
+		codigo, ok := odoo.NormalizarSuscripcion(suscripcion)
+		if !ok {
+			v.Agregar("suscripcion", "Código de suscripción inválido, use el formato SUB75885")
 		}
+		f.suscripcion = codigo
+	}
 
-		pageSize, err := strconv.Atoi(pageSizeStr)
-		if err != nil || pageSize < 1 {
-			pageSize = 1000
+	mesNombre := strings.ToUpper(c.Query("mesNombre")) // Ejemplo: "ABRIL"
+	if _, ok := v.Opcion(mesNombre, "mesNombre", meses,
+		"Nombre de mes inválido. Use: ENERO, FEBRERO, ..., DICIEMBRE"); ok {
+		f.mesNombre = mesNombre
+	}
+
+	// 0 = Emitida, 2 = Borrador, 1 = Nota de Credito en status Factura
+	estadoFactura := strings.ToUpper(c.Query("estadoFactura"))
+	if codigo, ok := v.Opcion(estadoFactura, "estadoFactura", estadosFactura,
+		"Estado de factura inválido. Use: 0 (EMITIDA), 2 (BORRADOR), 1 (NOTA_CREDITO) o sus nombres"); ok {
+		f.estadoFactura = estadoFactura
+		f.codigoEstado = codigo
+	}
+
+	numeroControl := strings.ToLower(c.Query("numeroControl"))
+	f.soloNumerosControl = numeroControl == "si" || numeroControl == "true"
+
+	f.conversion = monedas.LeerConversion(c, &v)
+
+	for nombre, valor := range c.QueryMap("campo") {
+		if !campoConfigurado(nombre) {
+			v.Agregar("campo["+nombre+"]", "Campo definido no configurado")
+			continue
 		}
+		if f.campos == nil {
+			f.campos = map[string]string{}
+		}
+		f.campos[nombre] = valor
+	}
+
+	f.page = v.Positivo(c.Query("page"), "page", 1)
+	f.pageSize = v.Positivo(c.Query("pageSize"), "pageSize", 1000)
 
-		offset := (page - 1) * pageSize
+	return f, v.Errores()
+}
 
-		baseQuery := `
-            FROM dbo.factura
-            WHERE 1=1
-        `
+// Construye el WHERE (sin paginacion) y sus parametros
+func (f filtrosFactura) condicion() (string, []interface{}) {
+	filterQuery := " WHERE 1=1"
+	params := []interface{}{}
 
-		var filterQuery string
-		params := []interface{}{}
+	// ------ Busqueda por Estado de Factura ------ //
+	if f.codigoEstado != "" {
+		filterQuery += " AND StatusFactura = @estadoFactura"
+		params = append(params, sql.Named("estadoFactura", f.codigoEstado))
+	}
 
-		var mes, anio int
-		var hayFiltroFecha bool
+	// ------ Busqueda por Nombre de Mes en Observaciones ------ //
+	if f.mesNombre != "" {
+		filterQuery += " AND Observaciones LIKE @mesObs"
+		params = append(params, sql.Named("mesObs", "%"+f.mesNombre+"%"))
 
-		// ------ Busqueda por Estado de Factura ------ //
-		if estadoFactura != "" {
-			// Verificar si el estado es válido (número o nombre)
-			codigoEstado, ok := estadosFactura[estadoFactura]
-			if !ok {
-				mensaje := "Estado de factura inválido. Use: 0 (EMITIDA), 2 (BORRADOR), 1 (NOTA_CREDITO) o sus nombres"
-				logError(requestID+" - "+mensaje, nil)
-				estadoFactura = ""
-			}
+		// Si quieres buscar exactamente el patrón "MES-Suscripcion:"
+		// filterQuery += " AND Observaciones LIKE @mesObs"
+		// params = append(params, sql.Named("mesObs", "%"+mesNombre+"-Suscripcion:%"))
+	}
 
-			// Agregar filtro para buscar por estado
-			filterQuery += " AND StatusFactura = @estadoFactura"
-			params = append(params, sql.Named("estadoFactura", codigoEstado))
-		}
+	// ------ Busqueda por Año y Mes ------ //
+	if f.mes != 0 {
+		filterQuery += " AND MONTH(Fecha) = @mes"
+		params = append(params, sql.Named("mes", f.mes))
+	}
+	if f.anio != 0 {
+		filterQuery += " AND YEAR(Fecha) = @anio"
+		params = append(params, sql.Named("anio", f.anio))
+	}
 
-		// ------ Busqueda por Nombre de Mes en Observaciones ------ //
-		if mesNombre != "" {
-			// Verificar si el nombre del mes es válido
-			if _, ok := meses[mesNombre]; !ok {
-				mensaje := "Nombre de mes inválido. Use: ENERO, FEBRERO, ..., DICIEMBRE"
-				logError(requestID+" - "+mensaje, nil)
-				mesNombre = "" // Si el mes no es valido, se deja vacio el campo
-			}
+	// ----- Busca por campo observacion ------ //
+	// Jembi lo quiere porque aqui colocan el Codigo SUB de Odoo
+	if f.odoo != "" {
+		filterQuery += " AND Observaciones LIKE @odoo"
+		params = append(params, sql.Named("odoo", "%"+f.odoo+"%"))
+	}
 
-			// Agregar filtro para buscar el mes en observaciones
-			filterQuery += " AND Observaciones LIKE @mesObs"
-			params = append(params, sql.Named("mesObs", "%"+mesNombre+"%"))
+	// Busqueda exacta del codigo SUB, SUB7588 no coincide con SUB75885
+	if f.suscripcion != "" {
+		filterQuery += " AND " + condicionSuscripcion
+		params = append(params, sql.Named("suscripcion", odoo.PatronLikeSuscripcion(f.suscripcion)))
+	}
 
-			// Si quieres buscar exactamente el patrón "MES-Suscripcion:"
-			// filterQuery += " AND Observaciones LIKE @mesObs"
-			// params = append(params, sql.Named("mesObs", "%"+mesNombre+"-Suscripcion:%"))
-		}
+	// Filtro por CodigoCliente
+	if f.codigoCliente != "" {
+		filterQuery += " AND CodigoCliente = @codigoCliente"
+		params = append(params, sql.Named("codigoCliente", f.codigoCliente))
+	}
 
-		// ------ Busqueda por Año y Mes ------ //
-		if mesStr != "" {
-			mes, err = strconv.Atoi(mesStr)
-			if err != nil || mes < 1 || mes > 12 {
-				mensaje := "Mes inválido, debe ser un número entre 1 y 12"
-				logError(requestID+" - "+mensaje, err)
-				c.JSON(http.StatusBadRequest, gin.H{"error": mensaje})
-				return
-			}
-			hayFiltroFecha = true
-		}
+	// Filtro por campos definidos de camposDefFactura
+	if len(f.campos) > 0 {
+		condicion, paramsCampos := condicionCamposDefinidos(f.campos)
+		filterQuery += condicion
+		params = append(params, paramsCampos...)
+	}
 
-		if anioStr != "" {
-			anio, err = strconv.Atoi(anioStr)
-			if err != nil || anio < 1900 || anio > 2100 {
-				mensaje := "Año inválido, debe ser un número entre 1900 y 2100"
-				logError(requestID+" - "+mensaje, err)
-				c.JSON(http.StatusBadRequest, gin.H{"error": mensaje})
-				return
-			}
-			hayFiltroFecha = true
-		}
+	return filterQuery, params
+}
 
-		if hayFiltroFecha {
-			if mesStr != "" && anioStr != "" {
-				filterQuery += " AND MONTH(Fecha) = @mes AND YEAR(Fecha) = @anio"
-				params = append(params, sql.Named("mes", mes), sql.Named("anio", anio))
-			} else if mesStr != "" {
-				filterQuery += " AND MONTH(Fecha) = @mes"
-				params = append(params, sql.Named("mes", mes))
-			} else if anioStr != "" {
-				filterQuery += " AND YEAR(Fecha) = @anio"
-				params = append(params, sql.Named("anio", anio))
-			}
-		}
+/*
+Condicion para buscar un codigo SUB exacto dentro de
+Observaciones. Se rodea el texto con espacios para que el
+codigo pueda estar al inicio o al final; el patron sale de
+odoo.PatronLikeSuscripcion.
+*/
+const condicionSuscripcion = "(' ' + ISNULL(Observaciones, '') + ' ') LIKE @suscripcion"
 
-		// ----- Busca por campo observacion ------ //
-		// Jembi lo quiere porque aqui colocan el Codigo SUB de Odoo
-		if odooQuery != "" {
-			filterQuery += " AND Observaciones LIKE @odoo"
-			params = append(params, sql.Named("odoo", "%"+odooQuery+"%"))
+// Filtros aplicados, para informarlos en la respuesta
+func (f filtrosFactura) aplicados() map[string]interface{} {
+	filtros := map[string]interface{}{}
+	if f.estadoFactura != "" {
+		filtros["estadoFactura"] = f.estadoFactura
+	}
+	if f.mesNombre != "" {
+		filtros["mesNombre"] = f.mesNombre
+	}
+	if f.mes != 0 {
+		filtros["mes"] = f.mes
+	}
+	if f.anio != 0 {
+		filtros["anio"] = f.anio
+	}
+	if f.odoo != "" {
+		filtros["odoo"] = f.odoo
+	}
+	if f.suscripcion != "" {
+		filtros["suscripcion"] = f.suscripcion
+	}
+	if f.codigoCliente != "" {
+		filtros["codigoCliente"] = f.codigoCliente
+	}
+	if len(f.campos) > 0 {
+		filtros["campo"] = f.campos
+	}
+	if f.conversion != nil {
+		filtros["moneda"] = f.conversion.Moneda
+		if f.conversion.FechaCambio != nil {
+			filtros["fechaCambio"] = f.conversion.FechaCambio.Format("2006-01-02")
 		}
+	}
+	return filtros
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
 
-		// NUEVO: filtro por CodigoCliente si viene en la query
-		if codigoCliente != "" {
-			filterQuery += " AND CodigoCliente = @codigoCliente"
-			params = append(params, sql.Named("codigoCliente", codigoCliente))
+/*
+La funcion permite la busqueda de las facturas en
+SqlServer, los parametros de busqueda se reciben
+mediante Querys (ver parsearFiltros).
+*/
+func buscarFacturas(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando consulta de facturas", nil)
+
+		// ------- Seteo de los parametros de Busqueda ----- //
+		filtros, errores := parsearFiltros(c)
+		if len(errores) > 0 {
+			logError(requestID+" - Parámetros de búsqueda inválidos", nil)
+			respuesta.ErrorValidacion(c, errores)
+			return
+		}
+		if !prepararConversion(c, db, filtros.conversion, requestID) {
+			return
 		}
+
+		offset := (filtros.page - 1) * filtros.pageSize
+		filterQuery, params := filtros.condicion()
+		baseQuery := " FROM dbo.factura"
 		// ------- Seteo de los parametros de Busqueda FIN ----- //
 
 		// ------- Ejecucion de las Busquedas ----- //
@@ -227,11 +363,11 @@ func buscarFacturas(db *sql.DB) gin.HandlerFunc {
 		// Obtener el TOTAL DE REGISTROS //
 		countQuery := "SELECT COUNT(*) " + baseQuery + filterQuery
 		var total int
-		err = db.QueryRow(countQuery, params...).Scan(&total)
+		err := db.QueryRow(countQuery, params...).Scan(&total)
 		if err != nil {
 			mensaje := "Error al obtener cantidad total de facturas"
 			logError(requestID+" - "+mensaje, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": mensaje + ": " + err.Error()})
+			respuesta.ErrorBD(c, mensaje)
 			return
 		}
 
@@ -239,7 +375,7 @@ func buscarFacturas(db *sql.DB) gin.HandlerFunc {
 
 		// CONSULTA PRINCIPAL - Modificada según si solo queremos números de control
 		var query string
-		if soloNumerosControl {
+		if filtros.soloNumerosControl {
 			// Solo seleccionar NumeroControl
 			query = `
                 SELECT DISTINCT NumeroControl
@@ -252,52 +388,31 @@ func buscarFacturas(db *sql.DB) gin.HandlerFunc {
 		} else {
 			// Consulta completa original
 			query = `
-                SELECT
-                    ConsecutivoCompania, Numero, Fecha, CodigoCliente, CodigoVendedor, Observaciones, TotalMontoExento,
-                    TotalBaseImponible, TotalRenglones, TotalIVA, TotalFactura, PorcentajeDescuento, CodigoNota1,
-                    CodigoNota2, Moneda, NivelDePrecio, ReservarMercancia, FechaDeRetiro, CodigoAlmacen, StatusFactura,
-                    TipoDeDocumento, InsertadaManualmente, FacturaHistorica, Cancelada, UsarDireccionFiscal,
-                    NoDirDespachoAimprimir, CambioABolivares, MontoDelAbono, FechaDeVencimiento, CondicionesDePago,
-                    FormaDeLaInicial, PorcentajeDeLaInicial, NumeroDeCuotas, MontoDeLasCuotas, MontoUltimaCuota,
-                    Talonario, FormaDePago, NumDiasDeVencimiento1aCuota, EditarMontoCuota, NumeroControl,
-                    TipoDeTransaccion, NumeroFacturaAfectada, NumeroPlanillaExportacion, TipoDeVenta, UsaMaquinaFiscal,
-                    CodigoMaquinaRegistradora, NumeroDesde, NumeroHasta, NumeroControlHasta, MontoIvaRetenido,
-                    FechaAplicacionRetIVA, NumeroComprobanteRetIVA, FechaComprobanteRetIVA, SeRetuvoIVA,
-                    FacturaConPreciosSinIva, VueltoDelCobroDirecto, ConsecutivoCaja, GeneraCobroDirecto,
-                    FechaDeFacturaAfectada, FechaDeEntrega, PorcentajeDescuento1, PorcentajeDescuento2,
-                    MontoDescuento1, MontoDescuento2, CodigoLote, Devolucion, PorcentajeAlicuota1, PorcentajeAlicuota2,
-                    PorcentajeAlicuota3, MontoIVAAlicuota1, MontoIVAAlicuota2, MontoIVAAlicuota3, MontoGravableAlicuota1,
-                    MontoGravableAlicuota2, MontoGravableAlicuota3, RealizoCierreZ, NumeroComprobanteFiscal,
-                    SerialMaquinaFiscal, AplicarPromocion, RealizoCierreX, HoraModificacion, FormaDeCobro,
-                    OtraFormaDeCobro, NoCotizacionDeOrigen, NoContrato, ConsecutivoVehiculo, ConsecutivoAlmacen,
-                    NumeroResumenDiario, NoControlDespachoDeOrigen, ImprimeFiscal, EsDiferida, EsOriginalmenteDiferida,
-                    SeContabilizoIvaDiferido, AplicaDecretoIvaEspecial, EsGeneradaPorPuntoDeVenta, CambioMonedaCXC,
-                    CambioMostrarTotalEnDivisas, CodigoMonedaDeCobro, GeneradaPorNotaEntrega, EmitidaEnFacturaNumero,
-                    CodigoMoneda, NombreOperador, FechaUltimaModificacion, NumeroParaResumen, NroDiasMantenerCambioAMonedaLocal,
-                    FechaLimiteCambioAMonedaLocal, GeneradoPor, BaseImponibleIGTF, IGTFML, IGTFME, AlicuotaIGTF,
-                    MotivoDeAnulacion, ProveedorImprentaDigital, ConsecutivoVendedor, ImprentaDigitalGUID
+                SELECT ` + columnasFactura + `
              ` + baseQuery + filterQuery + `
                 ORDER BY Fecha DESC
                 OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
             `
 		}
 
-		params = append(params, sql.Named("offset", offset), sql.Named("pageSize", pageSize))
+		params = append(params, sql.Named("offset", offset), sql.Named("pageSize", filtros.pageSize))
 
 		rows, err := db.Query(query, params...)
 		if err != nil {
 			mensaje := "Error al ejecutar consulta de facturas"
 			logError(requestID+" - "+mensaje, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": mensaje + ": " + err.Error()})
+			respuesta.ErrorBD(c, mensaje)
 			return
 		}
 		defer rows.Close()
 		// ------- Ejecucion de las Busquedas FIN ----- //
 
+		paginacion := respuesta.NuevaPaginacion(total, filtros.page, filtros.pageSize)
+
 		// ------- Formateo de los resultados ----- //
-		if soloNumerosControl {
+		if filtros.soloNumerosControl {
 			// Solo números de control
-			var numerosControl []string
+			numerosControl := []string{}
 
 			for rows.Next() {
 				var numeroCtrl string
@@ -305,156 +420,164 @@ func buscarFacturas(db *sql.DB) gin.HandlerFunc {
 				if err != nil {
 					mensaje := "Error al leer números de control"
 					logError(requestID+" - "+mensaje, err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": mensaje + ": " + err.Error()})
+					respuesta.ErrorBD(c, mensaje)
 					return
 				}
 				numerosControl = append(numerosControl, numeroCtrl)
 			}
 
-			// Respuesta simplificada para números de control
-			totalPages := (total + pageSize - 1) / pageSize
-			respuesta := gin.H{
-				"count":       len(numerosControl),
-				"total":       total,
-				"page":        page,
-				"pageSize":    pageSize,
-				"totalPages":  totalPages,
-				"isFirstPage": page == 1,
-				"isLastPage":  page == totalPages,
-				"hasNextPage": page < totalPages,
-				"hasPrevPage": page > 1,
-				"data":        numerosControl, // Array de strings en lugar de objetos
-			}
-
-			// Agregar filtros aplicados
-			if estadoFactura != "" || mesNombre != "" || hayFiltroFecha || odooQuery != "" || codigoCliente != "" {
-				respuesta["filtros"] = map[string]interface{}{}
-				if estadoFactura != "" {
-					respuesta["filtros"].(map[string]interface{})["estadoFactura"] = estadoFactura
-				}
-				if mesNombre != "" {
-					respuesta["filtros"].(map[string]interface{})["mesNombre"] = mesNombre
-				}
-				if hayFiltroFecha {
-					respuesta["filtros"].(map[string]interface{})["mes"] = mes
-					respuesta["filtros"].(map[string]interface{})["anio"] = anio
-				}
-				if odooQuery != "" {
-					respuesta["filtros"].(map[string]interface{})["odoo"] = odooQuery
-				}
-				if codigoCliente != "" {
-					respuesta["filtros"].(map[string]interface{})["codigoCliente"] = codigoCliente
-				}
-			}
-
-			logError(requestID+" - Consulta completada (solo números de control). Página: "+strconv.Itoa(page)+", Números devueltos: "+strconv.Itoa(len(numerosControl))+" / "+strconv.Itoa(total), nil)
-			c.JSON(http.StatusOK, respuesta)
+			logError(requestID+" - Consulta completada (solo números de control). Página: "+strconv.Itoa(filtros.page)+", Números devueltos: "+strconv.Itoa(len(numerosControl))+" / "+strconv.Itoa(total), nil)
+			respuesta.Pagina(c, "Números de control encontrados", numerosControl, len(numerosControl), paginacion, filtros.aplicados())
 			return
 		}
 
 		// Lógica original para facturas completas
-		var facturas []Factura
+		facturas := []Factura{}
 
 		for rows.Next() {
-			var factura Factura
-			err := rows.Scan(
-				// todos los campos igual que antes
-				&factura.ConsecutivoCompania, &factura.Numero, &factura.Fecha, &factura.CodigoCliente, &factura.CodigoVendedor,
-				&factura.Observaciones, &factura.TotalMontoExento, &factura.TotalBaseImponible, &factura.TotalRenglones,
-				&factura.TotalIVA, &factura.TotalFactura, &factura.PorcentajeDescuento, &factura.CodigoNota1, &factura.CodigoNota2,
-				&factura.Moneda, &factura.NivelDePrecio, &factura.ReservarMercancia, &factura.FechaDeRetiro, &factura.CodigoAlmacen,
-				&factura.StatusFactura, &factura.TipoDeDocumento, &factura.InsertadaManualmente, &factura.FacturaHistorica,
-				&factura.Cancelada, &factura.UsarDireccionFiscal, &factura.NoDirDespachoAimprimir, &factura.CambioABolivares,
-				&factura.MontoDelAbono, &factura.FechaDeVencimiento, &factura.CondicionesDePago, &factura.FormaDeLaInicial,
-				&factura.PorcentajeDeLaInicial, &factura.NumeroDeCuotas, &factura.MontoDeLasCuotas, &factura.MontoUltimaCuota,
-				&factura.Talonario, &factura.FormaDePago, &factura.NumDiasDeVencimiento1aCuota, &factura.EditarMontoCuota,
-				&factura.NumeroControl, &factura.TipoDeTransaccion, &factura.NumeroFacturaAfectada, &factura.NumeroPlanillaExportacion,
-				&factura.TipoDeVenta, &factura.UsaMaquinaFiscal, &factura.CodigoMaquinaRegistradora, &factura.NumeroDesde,
-				&factura.NumeroHasta, &factura.NumeroControlHasta, &factura.MontoIvaRetenido, &factura.FechaAplicacionRetIVA,
-				&factura.NumeroComprobanteRetIVA, &factura.FechaComprobanteRetIVA, &factura.SeRetuvoIVA,
-				&factura.FacturaConPreciosSinIva, &factura.VueltoDelCobroDirecto, &factura.ConsecutivoCaja,
-				&factura.GeneraCobroDirecto, &factura.FechaDeFacturaAfectada, &factura.FechaDeEntrega,
-				&factura.PorcentajeDescuento1, &factura.PorcentajeDescuento2, &factura.MontoDescuento1,
-				&factura.MontoDescuento2, &factura.CodigoLote, &factura.Devolucion, &factura.PorcentajeAlicuota1,
-				&factura.PorcentajeAlicuota2, &factura.PorcentajeAlicuota3, &factura.MontoIVAAlicuota1, &factura.MontoIVAAlicuota2,
-				&factura.MontoIVAAlicuota3, &factura.MontoGravableAlicuota1, &factura.MontoGravableAlicuota2,
-				&factura.MontoGravableAlicuota3, &factura.RealizoCierreZ, &factura.NumeroComprobanteFiscal,
-				&factura.SerialMaquinaFiscal, &factura.AplicarPromocion, &factura.RealizoCierreX, &factura.HoraModificacion,
-				&factura.FormaDeCobro, &factura.OtraFormaDeCobro, &factura.NoCotizacionDeOrigen, &factura.NoContrato,
-				&factura.ConsecutivoVehiculo, &factura.ConsecutivoAlmacen, &factura.NumeroResumenDiario,
-				&factura.NoControlDespachoDeOrigen, &factura.ImprimeFiscal, &factura.EsDiferida, &factura.EsOriginalmenteDiferida,
-				&factura.SeContabilizoIvaDiferido, &factura.AplicaDecretoIvaEspecial, &factura.EsGeneradaPorPuntoDeVenta,
-				&factura.CambioMonedaCXC, &factura.CambioMostrarTotalEnDivisas, &factura.CodigoMonedaDeCobro,
-				&factura.GeneradaPorNotaEntrega, &factura.EmitidaEnFacturaNumero, &factura.CodigoMoneda, &factura.NombreOperador,
-				&factura.FechaUltimaModificacion, &factura.NumeroParaResumen, &factura.NroDiasMantenerCambioAMonedaLocal,
-				&factura.FechaLimiteCambioAMonedaLocal, &factura.GeneradoPor, &factura.BaseImponibleIGTF, &factura.IGTFML,
-				&factura.IGTFME, &factura.AlicuotaIGTF, &factura.MotivoDeAnulacion, &factura.ProveedorImprentaDigital,
-				&factura.ConsecutivoVendedor, &factura.ImprentaDigitalGUID,
-			)
+			factura, err := escanearFactura(rows)
 			if err != nil {
 				mensaje := "Error al leer datos de facturas"
 				logError(requestID+" - "+mensaje, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": mensaje + ": " + err.Error()})
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			if err := factura.convertir(filtros.conversion); err != nil {
+				mensaje := "Error al convertir los montos de las facturas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
 				return
 			}
 			facturas = append(facturas, factura)
 		}
-		// ------- Formateo de los resultados FIN ----- //
+		rows.Close()
 
-		// ------- Seteo de la respuesta ----- //
-		totalPages := (total + pageSize - 1) / pageSize
-
-		respuesta := gin.H{
-			"count":       len(facturas),
-			"total":       total,
-			"page":        page,
-			"pageSize":    pageSize,
-			"totalPages":  totalPages,
-			"isFirstPage": page == 1,
-			"isLastPage":  page == totalPages,
-			"hasNextPage": page < totalPages,
-			"hasPrevPage": page > 1,
-			"data":        facturas,
-		}
-		if estadoFactura != "" {
-			if respuesta["filtros"] == nil {
-				respuesta["filtros"] = map[string]interface{}{}
-			}
-			respuesta["filtros"].(map[string]interface{})["estadoFactura"] = estadoFactura
+		if err := agregarCamposDefinidos(db, facturas); err != nil {
+			mensaje := "Error al consultar los campos definidos de las facturas"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
 		}
+		// ------- Formateo de los resultados FIN ----- //
 
-		if mesNombre != "" {
-			if respuesta["filtros"] == nil {
-				respuesta["filtros"] = map[string]interface{}{}
-			}
-			respuesta["filtros"].(map[string]interface{})["mesNombre"] = mesNombre
-		}
+		logError(requestID+" - Consulta completada. Página: "+strconv.Itoa(filtros.page)+", Registros devueltos: "+strconv.Itoa(len(facturas))+" / "+strconv.Itoa(total), nil)
 
-		if hayFiltroFecha {
-			respuesta["filtros"] = map[string]interface{}{
-				"mes":  mes,
-				"anio": anio,
-			}
-		}
+		respuesta.Pagina(c, "Facturas encontradas", facturas, len(facturas), paginacion, filtros.aplicados())
+	}
+}
 
-		if odooQuery != "" {
-			if respuesta["filtros"] == nil {
-				respuesta["filtros"] = map[string]interface{}{}
-			}
-			respuesta["filtros"].(map[string]interface{})["odoo"] = odooQuery
-		}
+/*
+Columnas de dbo.factura en el orden en que las lee
+escanearFactura. Se comparte entre todas las consultas
+que devuelven facturas completas.
+*/
+const columnasFactura = `
+                    ConsecutivoCompania, Numero, Fecha, CodigoCliente, CodigoVendedor, Observaciones, TotalMontoExento,
+                    TotalBaseImponible, TotalRenglones, TotalIVA, TotalFactura, PorcentajeDescuento, CodigoNota1,
+                    CodigoNota2, Moneda, NivelDePrecio, ReservarMercancia, FechaDeRetiro, CodigoAlmacen, StatusFactura,
+                    TipoDeDocumento, InsertadaManualmente, FacturaHistorica, Cancelada, UsarDireccionFiscal,
+                    NoDirDespachoAimprimir, CambioABolivares, MontoDelAbono, FechaDeVencimiento, CondicionesDePago,
+                    FormaDeLaInicial, PorcentajeDeLaInicial, NumeroDeCuotas, MontoDeLasCuotas, MontoUltimaCuota,
+                    Talonario, FormaDePago, NumDiasDeVencimiento1aCuota, EditarMontoCuota, NumeroControl,
+                    TipoDeTransaccion, NumeroFacturaAfectada, NumeroPlanillaExportacion, TipoDeVenta, UsaMaquinaFiscal,
+                    CodigoMaquinaRegistradora, NumeroDesde, NumeroHasta, NumeroControlHasta, MontoIvaRetenido,
+                    FechaAplicacionRetIVA, NumeroComprobanteRetIVA, FechaComprobanteRetIVA, SeRetuvoIVA,
+                    FacturaConPreciosSinIva, VueltoDelCobroDirecto, ConsecutivoCaja, GeneraCobroDirecto,
+                    FechaDeFacturaAfectada, FechaDeEntrega, PorcentajeDescuento1, PorcentajeDescuento2,
+                    MontoDescuento1, MontoDescuento2, CodigoLote, Devolucion, PorcentajeAlicuota1, PorcentajeAlicuota2,
+                    PorcentajeAlicuota3, MontoIVAAlicuota1, MontoIVAAlicuota2, MontoIVAAlicuota3, MontoGravableAlicuota1,
+                    MontoGravableAlicuota2, MontoGravableAlicuota3, RealizoCierreZ, NumeroComprobanteFiscal,
+                    SerialMaquinaFiscal, AplicarPromocion, RealizoCierreX, HoraModificacion, FormaDeCobro,
+                    OtraFormaDeCobro, NoCotizacionDeOrigen, NoContrato, ConsecutivoVehiculo, ConsecutivoAlmacen,
+                    NumeroResumenDiario, NoControlDespachoDeOrigen, ImprimeFiscal, EsDiferida, EsOriginalmenteDiferida,
+                    SeContabilizoIvaDiferido, AplicaDecretoIvaEspecial, EsGeneradaPorPuntoDeVenta, CambioMonedaCXC,
+                    CambioMostrarTotalEnDivisas, CodigoMonedaDeCobro, GeneradaPorNotaEntrega, EmitidaEnFacturaNumero,
+                    CodigoMoneda, NombreOperador, FechaUltimaModificacion, NumeroParaResumen, NroDiasMantenerCambioAMonedaLocal,
+                    FechaLimiteCambioAMonedaLocal, GeneradoPor, BaseImponibleIGTF, IGTFML, IGTFME, AlicuotaIGTF,
+                    MotivoDeAnulacion, ProveedorImprentaDigital, ConsecutivoVendedor, ImprentaDigitalGUID
+`
 
-		if codigoCliente != "" {
-			if respuesta["filtros"] == nil {
-				respuesta["filtros"] = map[string]interface{}{}
-			}
-			respuesta["filtros"].(map[string]interface{})["codigoCliente"] = codigoCliente
-		}
+/*
+Busca la tasa de fechaCambio si se pidio conversion.
+Si falla responde al cliente y retorna false.
+*/
+func prepararConversion(c *gin.Context, db *sql.DB, cv *monedas.Conversion, requestID string) bool {
+	if cv == nil {
+		return true
+	}
+	ok, err := cv.Preparar(db)
+	if err != nil {
+		mensaje := "Error al consultar la tasa de cambio"
+		logError(requestID+" - "+mensaje, err)
+		respuesta.ErrorBD(c, mensaje)
+		return false
+	}
+	if !ok {
+		respuesta.ErrorValidacion(c, []respuesta.ErrorParametro{
+			{Parametro: "fechaCambio", Mensaje: monedas.MensajeSinTasa},
+		})
+		return false
+	}
+	return true
+}
+
+// Interfaz comun de *sql.Row y *sql.Rows
+type escaner interface {
+	Scan(dest ...interface{}) error
+}
 
-		logError(requestID+" - Consulta completada. Página: "+strconv.Itoa(page)+", Registros devueltos: "+strconv.Itoa(len(facturas))+" / "+strconv.Itoa(total), nil)
+/*
+Lee una fila con las columnasFactura y completa los
+datos que se extraen de Observaciones.
+*/
+func escanearFactura(rows escaner) (Factura, error) {
+	var factura Factura
+	err := rows.Scan(
+		&factura.ConsecutivoCompania, &factura.Numero, &factura.Fecha, &factura.CodigoCliente, &factura.CodigoVendedor,
+		&factura.Observaciones, &factura.TotalMontoExento, &factura.TotalBaseImponible, &factura.TotalRenglones,
+		&factura.TotalIVA, &factura.TotalFactura, &factura.PorcentajeDescuento, &factura.CodigoNota1, &factura.CodigoNota2,
+		&factura.Moneda, &factura.NivelDePrecio, &factura.ReservarMercancia, &factura.FechaDeRetiro, &factura.CodigoAlmacen,
+		&factura.StatusFactura, &factura.TipoDeDocumento, &factura.InsertadaManualmente, &factura.FacturaHistorica,
+		&factura.Cancelada, &factura.UsarDireccionFiscal, &factura.NoDirDespachoAimprimir, &factura.CambioABolivares,
+		&factura.MontoDelAbono, &factura.FechaDeVencimiento, &factura.CondicionesDePago, &factura.FormaDeLaInicial,
+		&factura.PorcentajeDeLaInicial, &factura.NumeroDeCuotas, &factura.MontoDeLasCuotas, &factura.MontoUltimaCuota,
+		&factura.Talonario, &factura.FormaDePago, &factura.NumDiasDeVencimiento1aCuota, &factura.EditarMontoCuota,
+		&factura.NumeroControl, &factura.TipoDeTransaccion, &factura.NumeroFacturaAfectada, &factura.NumeroPlanillaExportacion,
+		&factura.TipoDeVenta, &factura.UsaMaquinaFiscal, &factura.CodigoMaquinaRegistradora, &factura.NumeroDesde,
+		&factura.NumeroHasta, &factura.NumeroControlHasta, &factura.MontoIvaRetenido, &factura.FechaAplicacionRetIVA,
+		&factura.NumeroComprobanteRetIVA, &factura.FechaComprobanteRetIVA, &factura.SeRetuvoIVA,
+		&factura.FacturaConPreciosSinIva, &factura.VueltoDelCobroDirecto, &factura.ConsecutivoCaja,
+		&factura.GeneraCobroDirecto, &factura.FechaDeFacturaAfectada, &factura.FechaDeEntrega,
+		&factura.PorcentajeDescuento1, &factura.PorcentajeDescuento2, &factura.MontoDescuento1,
+		&factura.MontoDescuento2, &factura.CodigoLote, &factura.Devolucion, &factura.PorcentajeAlicuota1,
+		&factura.PorcentajeAlicuota2, &factura.PorcentajeAlicuota3, &factura.MontoIVAAlicuota1, &factura.MontoIVAAlicuota2,
+		&factura.MontoIVAAlicuota3, &factura.MontoGravableAlicuota1, &factura.MontoGravableAlicuota2,
+		&factura.MontoGravableAlicuota3, &factura.RealizoCierreZ, &factura.NumeroComprobanteFiscal,
+		&factura.SerialMaquinaFiscal, &factura.AplicarPromocion, &factura.RealizoCierreX, &factura.HoraModificacion,
+		&factura.FormaDeCobro, &factura.OtraFormaDeCobro, &factura.NoCotizacionDeOrigen, &factura.NoContrato,
+		&factura.ConsecutivoVehiculo, &factura.ConsecutivoAlmacen, &factura.NumeroResumenDiario,
+		&factura.NoControlDespachoDeOrigen, &factura.ImprimeFiscal, &factura.EsDiferida, &factura.EsOriginalmenteDiferida,
+		&factura.SeContabilizoIvaDiferido, &factura.AplicaDecretoIvaEspecial, &factura.EsGeneradaPorPuntoDeVenta,
+		&factura.CambioMonedaCXC, &factura.CambioMostrarTotalEnDivisas, &factura.CodigoMonedaDeCobro,
+		&factura.GeneradaPorNotaEntrega, &factura.EmitidaEnFacturaNumero, &factura.CodigoMoneda, &factura.NombreOperador,
+		&factura.FechaUltimaModificacion, &factura.NumeroParaResumen, &factura.NroDiasMantenerCambioAMonedaLocal,
+		&factura.FechaLimiteCambioAMonedaLocal, &factura.GeneradoPor, &factura.BaseImponibleIGTF, &factura.IGTFML,
+		&factura.IGTFME, &factura.AlicuotaIGTF, &factura.MotivoDeAnulacion, &factura.ProveedorImprentaDigital,
+		&factura.ConsecutivoVendedor, &factura.ImprentaDigitalGUID,
+	)
+	if err != nil {
+		return factura, err
+	}
 
-		c.JSON(http.StatusOK, respuesta)
+	if factura.Observaciones != nil {
+		obs := odoo.ParsearObservaciones(*factura.Observaciones)
+		factura.Suscripcion = obs.Suscripcion
+		factura.MesFacturado = obs.MesFacturado
+		factura.AnioFacturado = obs.AnioFacturado
+		factura.OtrosObservaciones = obs.Otros
 	}
+
+	return factura, nil
 }
 
 /////////////////////////////////////////////////////
@@ -586,4 +709,62 @@ type Factura struct {
 	ProveedorImprentaDigital          string     `gorm:"column:ProveedorImprentaDigital"`
 	ConsecutivoVendedor               int        `gorm:"column:ConsecutivoVendedor"`
 	ImprentaDigitalGUID               *string    `gorm:"column:ImprentaDigitalGUID"`
+
+	// Datos extraidos de Observaciones, no son columnas de la BD
+	Suscripcion        *string  `json:"suscripcion" gorm:"-"`
+	MesFacturado       *int     `json:"mesFacturado" gorm:"-"`
+	AnioFacturado      *int     `json:"anioFacturado,omitempty" gorm:"-"`
+	OtrosObservaciones []string `json:"otrosObservaciones,omitempty" gorm:"-"`
+
+	// Montos convertidos con el query moneda (ver monedas.Conversion)
+	Conversion *monedas.Resultado `json:"conversion,omitempty" gorm:"-"`
+
+	// Campos de camposDefFactura con su nombre configurado
+	CamposDefinidos map[string]*string `json:"camposDefinidos,omitempty" gorm:"-"`
+}
+
+// Datos de moneda de la factura para convertir sus montos
+func (f Factura) documento() monedas.Documento {
+	return monedas.Documento{
+		Moneda:           f.CodigoMoneda,
+		Fecha:            f.Fecha,
+		CambioABolivares: f.CambioABolivares,
+		MonedaCobro:      f.CodigoMonedaDeCobro,
+		CambioCXC:        f.CambioMonedaCXC,
+		CambioDivisas:    f.CambioMostrarTotalEnDivisas,
+	}
+}
+
+/*
+Agrega a la factura sus montos convertidos a la moneda
+solicitada. No hace nada si no se pidio conversion.
+*/
+func (f *Factura) convertir(cv *monedas.Conversion) error {
+	if cv == nil {
+		return nil
+	}
+	resultado, err := cv.Aplicar(f.documento(), map[string]*float64{
+		"TotalMontoExento":       f.TotalMontoExento,
+		"TotalBaseImponible":     f.TotalBaseImponible,
+		"TotalRenglones":         f.TotalRenglones,
+		"TotalIVA":               f.TotalIVA,
+		"TotalFactura":           f.TotalFactura,
+		"MontoDelAbono":          f.MontoDelAbono,
+		"MontoDeLasCuotas":       f.MontoDeLasCuotas,
+		"MontoUltimaCuota":       f.MontoUltimaCuota,
+		"MontoIvaRetenido":       f.MontoIvaRetenido,
+		"VueltoDelCobroDirecto":  f.VueltoDelCobroDirecto,
+		"MontoDescuento1":        f.MontoDescuento1,
+		"MontoDescuento2":        f.MontoDescuento2,
+		"MontoIVAAlicuota1":      f.MontoIVAAlicuota1,
+		"MontoIVAAlicuota2":      f.MontoIVAAlicuota2,
+		"MontoIVAAlicuota3":      f.MontoIVAAlicuota3,
+		"MontoGravableAlicuota1": f.MontoGravableAlicuota1,
+		"MontoGravableAlicuota2": f.MontoGravableAlicuota2,
+		"MontoGravableAlicuota3": f.MontoGravableAlicuota3,
+		"BaseImponibleIGTF":      f.BaseImponibleIGTF,
+		"IGTFML":                 f.IGTFML,
+	})
+	f.Conversion = resultado
+	return err
 }
@@ -0,0 +1,513 @@
+package facturas
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Notas de credito y debito con la factura que afectan.
+
+	Las notas se guardan en dbo.factura y se distinguen por
+	TipoDeDocumento. La factura afectada se identifica con
+	NumeroFacturaAfectada dentro de la misma compania.
+*/
+
+var tiposNota = map[string]string{
+	"CREDITO":      TipoDocumentoNotaCredito,
+	"NOTA_CREDITO": TipoDocumentoNotaCredito,
+	"DEBITO":       TipoDocumentoNotaDebito,
+	"NOTA_DEBITO":  TipoDocumentoNotaDebito,
+	"1":            TipoDocumentoNotaCredito,
+	"2":            TipoDocumentoNotaDebito,
+}
+
+var nombresTipoNota = map[string]string{
+	TipoDocumentoNotaCredito: "NOTA_CREDITO",
+	TipoDocumentoNotaDebito:  "NOTA_DEBITO",
+}
+
+// Datos de una nota de credito o debito
+type NotaAplicada struct {
+	Numero          string    `json:"numero"`
+	TipoDeDocumento string    `json:"tipoDeDocumento"`
+	Fecha           time.Time `json:"fecha"`
+	NumeroControl   *string   `json:"numeroControl"`
+	StatusFactura   *string   `json:"statusFactura"`
+	TotalIVA        *float64  `json:"totalIva"`
+	TotalFactura    *float64  `json:"totalFactura"`
+	Anulada         bool      `json:"anulada"`
+
+	// Montos convertidos en el detalle de la factura (query moneda)
+	Conversion *monedas.Resultado `json:"conversion,omitempty"`
+
+	doc monedas.Documento
+}
+
+// Datos minimos de la factura afectada por una nota
+type FacturaAfectada struct {
+	Numero        string    `json:"numero"`
+	Fecha         time.Time `json:"fecha"`
+	NumeroControl *string   `json:"numeroControl"`
+	CodigoCliente *string   `json:"codigoCliente"`
+	TotalFactura  *float64  `json:"totalFactura"`
+}
+
+type NotaConFactura struct {
+	NotaAplicada
+	CodigoCliente          *string          `json:"codigoCliente"`
+	NumeroFacturaAfectada  *string          `json:"numeroFacturaAfectada"`
+	FechaDeFacturaAfectada *time.Time       `json:"fechaDeFacturaAfectada"`
+	FacturaAfectada        *FacturaAfectada `json:"facturaAfectada"` // nil si no se encontro en Galac
+}
+
+/*
+Detalle de una factura: todos sus campos mas las notas
+aplicadas, el monto neto despues de los ajustes, las
+formas de pago del cobro directo y los otros cargos.
+*/
+type DetalleFactura struct {
+	Factura
+	Notas            []NotaAplicada `json:"notas"`
+	MontoNeto        *float64       `json:"montoNeto"`
+	Cobros           []CobroFactura `json:"cobros"`
+	OtrosCargos      []CargoFactura `json:"otrosCargos"`
+	TotalOtrosCargos float64        `json:"totalOtrosCargos"`
+
+	// Cargos con aplicacionDesconocida, fuera de totalOtrosCargos
+	CargosDesconocidos int `json:"cargosDesconocidos"`
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// Una nota anulada o en borrador no afecta el monto de la factura
+func (n NotaAplicada) vigente() bool {
+	return !n.Anulada && (n.StatusFactura == nil || *n.StatusFactura != StatusBorrador)
+}
+
+/*
+Monto neto de la factura: el total menos las notas de
+credito mas las notas de debito vigentes.
+*/
+func montoNeto(total *float64, notas []NotaAplicada) *float64 {
+	if total == nil {
+		return nil
+	}
+	neto := *total
+	for _, n := range notas {
+		if !n.vigente() || n.TotalFactura == nil {
+			continue
+		}
+		if n.TipoDeDocumento == nombresTipoNota[TipoDocumentoNotaCredito] {
+			neto -= *n.TotalFactura
+		} else {
+			neto += *n.TotalFactura
+		}
+	}
+	return &neto
+}
+
+// Columnas de una nota en el orden de NotaAplicada.campos
+var columnasNota = `
+	n.Numero, n.TipoDeDocumento, n.Fecha, n.NumeroControl, n.StatusFactura,
+	n.TotalIVA, n.TotalFactura, n.Cancelada, n.MotivoDeAnulacion,
+	` + monedas.ColumnasDocumento("n.") + `
+`
+
+func (n *NotaAplicada) campos(cancelada *string, motivo **string) []interface{} {
+	return append([]interface{}{
+		&n.Numero, &n.TipoDeDocumento, &n.Fecha, &n.NumeroControl, &n.StatusFactura,
+		&n.TotalIVA, &n.TotalFactura, cancelada, motivo,
+	}, n.doc.Destinos()...)
+}
+
+// Completa el nombre del tipo y la marca de anulada luego del Scan
+func (n *NotaAplicada) completar(cancelada string, motivo *string) {
+	if nombre, ok := nombresTipoNota[n.TipoDeDocumento]; ok {
+		n.TipoDeDocumento = nombre
+	}
+	n.Anulada = EsAnulada(cancelada, motivo)
+	n.doc.Fecha = n.Fecha
+}
+
+/*
+Agrega la conversion a la moneda solicitada de las notas,
+los cobros y los otros cargos, y suma al resultado de la
+factura los montos propios del detalle (montoNeto y
+totalOtrosCargos, con el mismo nombre que en la respuesta).
+Cada nota se convierte con su propia tasa, cada cobro con
+la de su moneda y los cargos con la de la factura.
+*/
+func (d *DetalleFactura) convertir(cv *monedas.Conversion) error {
+	if cv == nil {
+		return nil
+	}
+
+	notasConvertidas := make([]NotaAplicada, len(d.Notas))
+	netoCompleto := true
+	for i := range d.Notas {
+		n := &d.Notas[i]
+		resultado, err := cv.Aplicar(n.doc, map[string]*float64{
+			"totalIva":     n.TotalIVA,
+			"totalFactura": n.TotalFactura,
+		})
+		if err != nil {
+			return err
+		}
+		n.Conversion = resultado
+
+		notasConvertidas[i] = *n
+		if resultado.Montos != nil {
+			notasConvertidas[i].TotalFactura = resultado.Montos["totalFactura"]
+		} else if n.vigente() {
+			netoCompleto = false
+		}
+	}
+
+	for i := range d.Cobros {
+		r := &d.Cobros[i]
+		doc := d.documento()
+		if r.CodigoMoneda != nil {
+			doc.Moneda = *r.CodigoMoneda
+			doc.CambioABolivares = r.CambioAMonedaLocal
+		}
+		resultado, err := cv.Aplicar(doc, map[string]*float64{"montoMonedaLocal": r.MontoMonedaLocal})
+		if err != nil {
+			return err
+		}
+		r.Conversion = resultado
+	}
+
+	for i := range d.OtrosCargos {
+		r := &d.OtrosCargos[i]
+		resultado, err := cv.Aplicar(d.documento(), map[string]*float64{
+			"totalRenglon":  r.TotalRenglon,
+			"montoAplicado": r.MontoAplicado,
+		})
+		if err != nil {
+			return err
+		}
+		r.Conversion = resultado
+	}
+
+	// Montos del detalle con la tasa de la factura
+	if d.Conversion == nil || d.Conversion.Montos == nil {
+		return nil
+	}
+	totalCargos := d.TotalOtrosCargos
+	resultado, err := cv.Aplicar(d.documento(), map[string]*float64{"totalOtrosCargos": &totalCargos})
+	if err != nil {
+		return err
+	}
+	d.Conversion.Montos["totalOtrosCargos"] = resultado.Montos["totalOtrosCargos"]
+	d.Conversion.Montos["montoNeto"] = nil
+	if netoCompleto {
+		d.Conversion.Montos["montoNeto"] = montoNeto(d.Conversion.Montos["TotalFactura"], notasConvertidas)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Lista las notas de credito y debito con su factura afectada.
+Querys:
+
+tipo: CREDITO, DEBITO (o 1, 2). Por defecto ambas
+mes, anio: periodo de la nota
+codigoCliente: alfanumerico
+facturaAfectada: numero de la factura afectada
+page, pageSize: paginacion, por defecto 1 y 1000
+*/
+func buscarNotas(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando consulta de notas de crédito y débito", nil)
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		tipo := strings.ToUpper(c.Query("tipo"))
+		codigoTipo, hayTipo := v.Opcion(tipo, "tipo", tiposNota, "Tipo de nota inválido. Use: CREDITO, DEBITO (o 1, 2)")
+		mes, _ := v.Entero(c.Query("mes"), "mes", 1, 12)
+		anio, _ := v.Entero(c.Query("anio"), "anio", 1900, 2100)
+		codigoCliente := strings.TrimSpace(c.Query("codigoCliente"))
+		facturaAfectada := strings.TrimSpace(c.Query("facturaAfectada"))
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize := v.Positivo(c.Query("pageSize"), "pageSize", 1000)
+
+		if !v.Valido() {
+			logError(requestID+" - Parámetros de búsqueda de notas inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		// ----- Filtros ----- //
+		filtros := map[string]interface{}{}
+		condicion := " WHERE n.TipoDeDocumento IN (@notaCredito, @notaDebito)"
+		params := []interface{}{
+			sql.Named("notaCredito", TipoDocumentoNotaCredito),
+			sql.Named("notaDebito", TipoDocumentoNotaDebito),
+			sql.Named("tipoFactura", TipoDocumentoFactura),
+		}
+		if hayTipo {
+			condicion += " AND n.TipoDeDocumento = @tipo"
+			params = append(params, sql.Named("tipo", codigoTipo))
+			filtros["tipo"] = nombresTipoNota[codigoTipo]
+		}
+		if mes != 0 {
+			condicion += " AND MONTH(n.Fecha) = @mes"
+			params = append(params, sql.Named("mes", mes))
+			filtros["mes"] = mes
+		}
+		if anio != 0 {
+			condicion += " AND YEAR(n.Fecha) = @anio"
+			params = append(params, sql.Named("anio", anio))
+			filtros["anio"] = anio
+		}
+		if codigoCliente != "" {
+			condicion += " AND n.CodigoCliente = @codigoCliente"
+			params = append(params, sql.Named("codigoCliente", codigoCliente))
+			filtros["codigoCliente"] = codigoCliente
+		}
+		if facturaAfectada != "" {
+			condicion += " AND n.NumeroFacturaAfectada = @facturaAfectada"
+			params = append(params, sql.Named("facturaAfectada", facturaAfectada))
+			filtros["facturaAfectada"] = facturaAfectada
+		}
+
+		baseQuery := `
+			FROM dbo.factura n
+			LEFT JOIN dbo.factura f
+				ON f.ConsecutivoCompania = n.ConsecutivoCompania
+				AND f.Numero = n.NumeroFacturaAfectada
+				AND f.TipoDeDocumento = @tipoFactura
+		`
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.factura n"+condicion, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de notas"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron notas de crédito o débito con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT ` + columnasNota + `,
+				n.CodigoCliente, n.NumeroFacturaAfectada, n.FechaDeFacturaAfectada,
+				f.Numero, f.Fecha, f.NumeroControl, f.CodigoCliente, f.TotalFactura
+			` + baseQuery + condicion + `
+			ORDER BY n.Fecha DESC, n.Numero DESC
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar las notas de crédito y débito"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		notas := []NotaConFactura{}
+		for rows.Next() {
+			var n NotaConFactura
+			var cancelada string
+			var motivo *string
+			var afectadaNumero *string
+			var afectadaFecha *time.Time
+			var afectada FacturaAfectada
+
+			destino := append(n.campos(&cancelada, &motivo),
+				&n.CodigoCliente, &n.NumeroFacturaAfectada, &n.FechaDeFacturaAfectada,
+				&afectadaNumero, &afectadaFecha, &afectada.NumeroControl, &afectada.CodigoCliente, &afectada.TotalFactura,
+			)
+			if err := rows.Scan(destino...); err != nil {
+				mensaje := "Error al leer datos de notas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+
+			n.completar(cancelada, motivo)
+			if afectadaNumero != nil {
+				afectada.Numero = *afectadaNumero
+				afectada.Fecha = *afectadaFecha
+				n.FacturaAfectada = &afectada
+			}
+			notas = append(notas, n)
+		}
+
+		logError(requestID+" - Notas encontradas: "+strconv.Itoa(len(notas))+" / "+strconv.Itoa(total), nil)
+
+		respuesta.Pagina(c, "Notas de crédito y débito encontradas", notas, len(notas),
+			respuesta.NuevaPaginacion(total, page, pageSize), filtros)
+	}
+}
+
+/*
+Detalle de una factura por su numero, con las notas de
+credito y debito que la afectan, el monto neto, los cobros
+y los otros cargos.
+Acepta los querys compania, moneda y fechaCambio. Si el
+numero existe en varias companias y no se indica compania
+se responde 409.
+*/
+func detalleFactura(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		numero := strings.TrimSpace(c.Param("numero"))
+
+		var v respuesta.Validador
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		conversion := monedas.LeerConversion(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if !prepararConversion(c, db, conversion, requestID) {
+			return
+		}
+
+		filtro, params := respuesta.FiltroCompania("Numero = @numero AND TipoDeDocumento = @tipoFactura", "ConsecutivoCompania",
+			[]interface{}{sql.Named("numero", numero), sql.Named("tipoFactura", TipoDocumentoFactura)},
+			compania, conCompania)
+		facturas, err := respuesta.LeerUnico(db, `
+			SELECT TOP 2 `+columnasFactura+`
+			FROM dbo.factura
+			WHERE `+filtro+`
+			ORDER BY ConsecutivoCompania
+		`, params, func(rows *sql.Rows) (Factura, error) { return escanearFactura(rows) })
+		if err != nil {
+			mensaje := "Error al consultar la factura"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		if _, ok := respuesta.Unico(c, facturas, "la factura "+numero); !ok {
+			return
+		}
+
+		if err := agregarCamposDefinidos(db, facturas); err != nil {
+			mensaje := "Error al consultar los campos definidos de la factura"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		factura := facturas[0]
+
+		detalle := DetalleFactura{Factura: factura}
+
+		// ----- Notas aplicadas ----- //
+		notas, err := notasDeFactura(db, factura)
+		if err != nil {
+			mensaje := "Error al consultar las notas de la factura"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		detalle.Notas = notas
+		detalle.MontoNeto = montoNeto(factura.TotalFactura, notas)
+
+		// ----- Cobros ----- //
+		cobros, err := cobrosDeFactura(db, factura)
+		if err != nil {
+			mensaje := "Error al consultar los cobros de la factura"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		detalle.Cobros = cobros
+
+		// ----- Otros cargos ----- //
+		cargos, err := cargosDeFactura(db, factura)
+		if err != nil {
+			mensaje := "Error al consultar los otros cargos de la factura"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		detalle.OtrosCargos = cargos
+		detalle.TotalOtrosCargos, detalle.CargosDesconocidos = totalOtrosCargos(cargos)
+
+		// ----- Conversion de moneda ----- //
+		if err := detalle.Factura.convertir(conversion); err != nil {
+			mensaje := "Error al convertir los montos de la factura"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		if err := detalle.convertir(conversion); err != nil {
+			mensaje := "Error al convertir los montos del detalle de la factura"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		respuesta.Exito(c, "Detalle de la factura "+numero, detalle, 1)
+	}
+}
+
+/*
+Notas de credito y debito que afectan la factura.
+Numero y TipoDeDocumento identifican la factura dentro de
+la compania, por eso no se compara FechaDeFacturaAfectada.
+*/
+func notasDeFactura(db *sql.DB, factura Factura) ([]NotaAplicada, error) {
+	query := `
+		SELECT ` + columnasNota + `
+		FROM dbo.factura n
+		WHERE n.ConsecutivoCompania = @compania
+		  AND n.NumeroFacturaAfectada = @numero
+		  AND n.TipoDeDocumento IN (@notaCredito, @notaDebito)
+		ORDER BY n.Fecha, n.Numero
+	`
+	rows, err := db.Query(query,
+		sql.Named("compania", factura.ConsecutivoCompania),
+		sql.Named("numero", factura.Numero),
+		sql.Named("notaCredito", TipoDocumentoNotaCredito),
+		sql.Named("notaDebito", TipoDocumentoNotaDebito),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notas := []NotaAplicada{}
+	for rows.Next() {
+		var n NotaAplicada
+		var cancelada string
+		var motivo *string
+		if err := rows.Scan(n.campos(&cancelada, &motivo)...); err != nil {
+			return nil, err
+		}
+		n.completar(cancelada, motivo)
+		notas = append(notas, n)
+	}
+	return notas, rows.Err()
+}
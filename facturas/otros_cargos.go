@@ -0,0 +1,204 @@
+package facturas
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Otros cargos de las facturas: flete, seguro, servicio, etc.
+
+	dbo.otrosCargosDeFactura define cada cargo (monto fijo o
+	porcentaje sobre una base) y dbo.renglonDetalleDeOtrosCargosFactura
+	guarda los cargos aplicados a cada factura.
+
+	ComoAplicaAlTotalFactura indica si el cargo suma o resta
+	al total. Los codigos se tomaron de Galac ("0" suma,
+	"1" resta). Un valor desconocido no se asume: el cargo
+	queda con aplicacionDesconocida, sin montoAplicado y
+	fuera de totalOtrosCargos.
+*/
+
+var signoAplicacionCargo = map[string]float64{
+	"0":     1,
+	"SUMA":  1,
+	"1":     -1,
+	"RESTA": -1,
+}
+
+// Definicion de un cargo
+type OtroCargo struct {
+	ConsecutivoCompania      int        `json:"consecutivoCompania"`
+	Codigo                   string     `json:"codigo"`
+	Descripcion              string     `json:"descripcion"`
+	Status                   *string    `json:"status"`
+	SeCalculaEnBaseA         *string    `json:"seCalculaEnBaseA"`
+	Monto                    *float64   `json:"monto"`
+	BaseFormula              *string    `json:"baseFormula"`
+	PorcentajeSobreBase      *float64   `json:"porcentajeSobreBase"`
+	Sustraendo               *float64   `json:"sustraendo"`
+	ComoAplicaAlTotalFactura *string    `json:"comoAplicaAlTotalFactura"`
+	PorcentajeComision       *float64   `json:"porcentajeComision"`
+	ExcluirDeComision        bool       `json:"excluirDeComision"`
+	FechaUltimaModificacion  *time.Time `json:"fechaUltimaModificacion"`
+}
+
+/*
+Cargo aplicado a una factura, con la formula de la
+definicion. Los datos de la definicion son nil si el
+cargo ya no existe en el catalogo.
+*/
+type CargoFactura struct {
+	ConsecutivoRenglon       int      `json:"consecutivoRenglon"`
+	CodigoDeCargo            *string  `json:"codigoDeCargo"`
+	Descripcion              *string  `json:"descripcion"`
+	SeCalculaEnBaseA         *string  `json:"seCalculaEnBaseA"`
+	ComoAplicaAlTotalFactura *string  `json:"comoAplicaAlTotalFactura"`
+	TotalRenglon             *float64 `json:"totalRenglon"`
+	BaseFormula              *string  `json:"baseFormula"`
+	PorcentajeSobreBase      *float64 `json:"porcentajeSobreBase"`
+	Sustraendo               *float64 `json:"sustraendo"`
+	MontoDefinido            *float64 `json:"montoDefinido"`
+	MontoAplicado            *float64 `json:"montoAplicado"`         // TotalRenglon con el signo de ComoAplicaAlTotalFactura
+	AplicacionDesconocida    bool     `json:"aplicacionDesconocida"` // ComoAplicaAlTotalFactura sin signo conocido
+
+	// Montos convertidos en el detalle de la factura (query moneda)
+	Conversion *monedas.Resultado `json:"conversion,omitempty"`
+}
+
+// Signo con el que el cargo afecta el total de la factura, false si es desconocido
+func (r CargoFactura) signo() (float64, bool) {
+	if r.ComoAplicaAlTotalFactura == nil {
+		return 0, false
+	}
+	signo, ok := signoAplicacionCargo[strings.ToUpper(strings.TrimSpace(*r.ComoAplicaAlTotalFactura))]
+	return signo, ok
+}
+
+// Suma de los cargos con su signo, sin los de aplicacion desconocida
+func totalOtrosCargos(cargos []CargoFactura) (float64, int) {
+	total, desconocidos := 0.0, 0
+	for _, r := range cargos {
+		if r.AplicacionDesconocida {
+			desconocidos++
+			continue
+		}
+		if r.MontoAplicado != nil {
+			total += *r.MontoAplicado
+		}
+	}
+	return total, desconocidos
+}
+
+// Cargos aplicados a la factura con su formula
+func cargosDeFactura(db *sql.DB, factura Factura) ([]CargoFactura, error) {
+	rows, err := db.Query(`
+		SELECT r.ConsecutivoRenglon, r.CodigoDeCargo, r.Descripcion, r.SeCalculaEnBaseA, r.ComoAplicaAlTotalFactura,
+		       r.TotalRenglon, oc.BaseFormula, oc.PorcentajeSobreBase, oc.Sustraendo, oc.Monto
+		FROM dbo.renglonDetalleDeOtrosCargosFactura r
+		LEFT JOIN dbo.otrosCargosDeFactura oc
+		       ON oc.ConsecutivoCompania = r.ConsecutivoCompania AND oc.Codigo = r.CodigoDeCargo
+		WHERE r.ConsecutivoCompania = @compania
+		  AND r.NumeroFactura = @numero
+		  AND r.TipoDeDocumento = @tipoDocumento
+		ORDER BY r.ConsecutivoRenglon
+	`,
+		sql.Named("compania", factura.ConsecutivoCompania),
+		sql.Named("numero", factura.Numero),
+		sql.Named("tipoDocumento", factura.TipoDeDocumento),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cargos := []CargoFactura{}
+	for rows.Next() {
+		var r CargoFactura
+		if err := rows.Scan(&r.ConsecutivoRenglon, &r.CodigoDeCargo, &r.Descripcion, &r.SeCalculaEnBaseA,
+			&r.ComoAplicaAlTotalFactura, &r.TotalRenglon, &r.BaseFormula, &r.PorcentajeSobreBase,
+			&r.Sustraendo, &r.MontoDefinido); err != nil {
+			return nil, err
+		}
+		signo, ok := r.signo()
+		r.AplicacionDesconocida = !ok
+		if r.TotalRenglon != nil && ok {
+			monto := *r.TotalRenglon * signo
+			r.MontoAplicado = &monto
+		}
+		cargos = append(cargos, r)
+	}
+	return cargos, rows.Err()
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Catalogo de otros cargos.
+Querys:
+
+status: valor exacto de Status
+buscar: texto en Codigo o Descripcion
+*/
+func catalogoOtrosCargos(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		filterQuery := " WHERE 1=1"
+		params := []interface{}{}
+		if status := strings.TrimSpace(c.Query("status")); status != "" {
+			filterQuery += " AND Status = @status"
+			params = append(params, sql.Named("status", status))
+		}
+		if buscar := strings.TrimSpace(c.Query("buscar")); buscar != "" {
+			filterQuery += " AND (Codigo LIKE @buscar OR Descripcion LIKE @buscar)"
+			params = append(params, sql.Named("buscar", "%"+buscar+"%"))
+		}
+
+		rows, err := db.Query(`
+			SELECT ConsecutivoCompania, Codigo, Descripcion, Status, SeCalculaEnBasea, Monto, BaseFormula,
+			       PorcentajeSobreBase, Sustraendo, ComoAplicaAlTotalFactura, PorcentajeComision,
+			       ExcluirDeComision, FechaUltimaModificacion
+			FROM dbo.otrosCargosDeFactura`+filterQuery+`
+			ORDER BY ConsecutivoCompania, Codigo
+		`, params...)
+		if err != nil {
+			mensaje := "Error al consultar los otros cargos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		cargos := []OtroCargo{}
+		for rows.Next() {
+			var oc OtroCargo
+			var excluir string
+			if err := rows.Scan(&oc.ConsecutivoCompania, &oc.Codigo, &oc.Descripcion, &oc.Status, &oc.SeCalculaEnBaseA,
+				&oc.Monto, &oc.BaseFormula, &oc.PorcentajeSobreBase, &oc.Sustraendo, &oc.ComoAplicaAlTotalFactura,
+				&oc.PorcentajeComision, &excluir, &oc.FechaUltimaModificacion); err != nil {
+				mensaje := "Error al leer los otros cargos"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			oc.ExcluirDeComision = excluir == "S"
+			cargos = append(cargos, oc)
+		}
+
+		if len(cargos) == 0 {
+			respuesta.SinResultados(c, "No se encontraron otros cargos")
+			return
+		}
+
+		respuesta.Exito(c, "Otros cargos de factura", cargos, len(cargos))
+	}
+}
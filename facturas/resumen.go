@@ -0,0 +1,252 @@
+package facturas
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Totales de facturas calculados en SQL Server.
+
+	Los dashboards descargaban miles de facturas solo para
+	sumarlas. Este resumen acepta los mismos filtros que
+	buscarFacturas y agrupa por uno o varios criterios.
+
+	Por defecto (montos=neto) las notas de credito restan y,
+	si no se filtra por estadoFactura, los borradores no se
+	suman. Con montos=bruto se suma todo tal como esta en
+	Galac, sin cambiar signos ni excluir borradores.
+*/
+
+// Expresion SQL de cada criterio de agrupacion
+var criteriosResumen = map[string]string{
+	"dia":      "CONVERT(varchar(10), Fecha, 23)",
+	"semana":   "CONVERT(varchar(10), DATEADD(day, -((DATEPART(weekday, Fecha) + @@DATEFIRST - 2) % 7), CAST(Fecha AS date)), 23)",
+	"mes":      "CONVERT(varchar(7), Fecha, 23)",
+	"anio":     "CAST(YEAR(Fecha) AS varchar(4))",
+	"vendedor": "ISNULL(CodigoVendedor, '')",
+	"cliente":  "ISNULL(CodigoCliente, '')",
+	"moneda":   "ISNULL(CodigoMoneda, '')",
+	"estado":   "ISNULL(StatusFactura, '')",
+	"almacen":  "ISNULL(CodigoAlmacen, '')",
+}
+
+// Criterios aceptados, para los mensajes de error
+const criteriosValidos = "dia, semana, mes, anio, vendedor, cliente, moneda, estado, almacen"
+
+// Valores del query montos
+var opcionesMontos = map[string]string{"neto": "neto", "bruto": "bruto"}
+
+// Signo de cada documento en los montos netos
+const signoResumen = "(CASE WHEN TipoDeDocumento = @tipoNotaCredito THEN -1 ELSE 1 END)"
+
+type GrupoResumen struct {
+	Grupo              map[string]string `json:"grupo"`
+	Cantidad           int               `json:"cantidad"`
+	TotalFactura       float64           `json:"totalFactura"`
+	TotalIVA           float64           `json:"totalIva"`
+	TotalBaseImponible float64           `json:"totalBaseImponible"`
+	TotalMontoExento   float64           `json:"totalMontoExento"`
+
+	// Totales convertidos con el query moneda
+	Conversion *ResumenConvertido `json:"conversion,omitempty"`
+}
+
+/*
+Totales del grupo en la moneda solicitada, convertidos con
+la tasa de cada factura (o la de fechaCambio). Las facturas
+sin tasa no se suman y se cuentan en documentosSinTasa.
+*/
+type ResumenConvertido struct {
+	Moneda             string   `json:"moneda"`
+	TotalFactura       *float64 `json:"totalFactura"`
+	TotalIVA           *float64 `json:"totalIva"`
+	TotalBaseImponible *float64 `json:"totalBaseImponible"`
+	TotalMontoExento   *float64 `json:"totalMontoExento"`
+	DocumentosSinTasa  int      `json:"documentosSinTasa"`
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Lee el query agrupar (criterios separados por coma).
+Por defecto se agrupa por mes. Semana es la fecha del
+lunes de cada semana.
+*/
+func parsearAgrupacion(valor string, v *respuesta.Validador) []string {
+	criterios := []string{}
+	vistos := map[string]bool{}
+	for _, criterio := range strings.Split(strings.ToLower(valor), ",") {
+		criterio = strings.TrimSpace(criterio)
+		if criterio == "" || vistos[criterio] {
+			continue
+		}
+		if _, ok := criteriosResumen[criterio]; !ok {
+			v.Agregar("agrupar", "Criterio de agrupación inválido: "+criterio+". Use: "+criteriosValidos)
+			continue
+		}
+		vistos[criterio] = true
+		criterios = append(criterios, criterio)
+	}
+	if len(criterios) == 0 {
+		return []string{"mes"}
+	}
+	return criterios
+}
+
+/*
+Resumen de facturas agrupado.
+Querys: los mismos filtros de buscarFacturas (salvo
+numeroControl) mas:
+
+agrupar: dia, semana, mes, anio, vendedor, cliente, moneda,
+
+	estado o almacen, separados por coma (por defecto mes)
+
+montos: neto (por defecto, notas de credito restan y sin
+
+	estadoFactura no se suman borradores) o bruto (tal como
+	estan en Galac)
+
+page, pageSize: paginacion sobre los grupos
+moneda, fechaCambio: agrega los totales convertidos
+*/
+func resumenFacturas(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando resumen de facturas", nil)
+
+		// ----- Validacion de parametros ----- //
+		filtros, errores := parsearFiltros(c)
+		var v respuesta.Validador
+		criterios := parsearAgrupacion(c.Query("agrupar"), &v)
+		montos, _ := v.Opcion(strings.ToLower(c.Query("montos")), "montos", opcionesMontos,
+			"Montos inválidos. Use: neto o bruto")
+		if montos == "" {
+			montos = "neto"
+		}
+		errores = append(errores, v.Errores()...)
+		if len(errores) > 0 {
+			logError(requestID+" - Parámetros de resumen inválidos", nil)
+			respuesta.ErrorValidacion(c, errores)
+			return
+		}
+		if !prepararConversion(c, db, filtros.conversion, requestID) {
+			return
+		}
+
+		filterQuery, params := filtros.condicion()
+		if montos == "neto" && filtros.codigoEstado == "" {
+			filterQuery += " AND StatusFactura <> @borrador"
+			params = append(params, sql.Named("borrador", StatusBorrador))
+		}
+		expresiones := make([]string, len(criterios))
+		for i, criterio := range criterios {
+			expresiones[i] = criteriosResumen[criterio]
+		}
+		agrupacion := strings.Join(expresiones, ", ")
+
+		// ----- Conteo de grupos ----- //
+		var total int
+		countQuery := "SELECT COUNT(*) FROM (SELECT 1 AS g FROM dbo.factura" + filterQuery + " GROUP BY " + agrupacion + ") grupos"
+		if err := db.QueryRow(countQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al contar los grupos del resumen"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron facturas con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		signo := ""
+		if montos == "neto" {
+			signo = " * " + signoResumen
+			params = append(params, sql.Named("tipoNotaCredito", TipoDocumentoNotaCredito))
+		}
+
+		convertidos := ""
+		if filtros.conversion != nil {
+			factor, paramsFactor := filtros.conversion.ExpresionFactor("")
+			convertidos = `,
+				SUM(TotalFactura * ` + factor + signo + `),
+				SUM(TotalIVA * ` + factor + signo + `),
+				SUM(TotalBaseImponible * ` + factor + signo + `),
+				SUM(TotalMontoExento * ` + factor + signo + `),
+				SUM(CASE WHEN ` + factor + ` IS NULL THEN 1 ELSE 0 END)`
+			params = append(params, paramsFactor...)
+		}
+
+		query := `
+			SELECT ` + agrupacion + `,
+				COUNT(*),
+				ISNULL(SUM(TotalFactura` + signo + `), 0),
+				ISNULL(SUM(TotalIVA` + signo + `), 0),
+				ISNULL(SUM(TotalBaseImponible` + signo + `), 0),
+				ISNULL(SUM(TotalMontoExento` + signo + `), 0)` + convertidos + `
+			FROM dbo.factura` + filterQuery + `
+			GROUP BY ` + agrupacion + `
+			ORDER BY ` + agrupacion + `
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (filtros.page-1)*filtros.pageSize),
+			sql.Named("pageSize", filtros.pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar el resumen de facturas"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		grupos := []GrupoResumen{}
+		for rows.Next() {
+			g := GrupoResumen{Grupo: map[string]string{}}
+			valores := make([]string, len(criterios))
+			destino := make([]interface{}, 0, len(criterios)+5)
+			for i := range valores {
+				destino = append(destino, &valores[i])
+			}
+			destino = append(destino, &g.Cantidad, &g.TotalFactura, &g.TotalIVA, &g.TotalBaseImponible, &g.TotalMontoExento)
+			if filtros.conversion != nil {
+				g.Conversion = &ResumenConvertido{Moneda: filtros.conversion.Moneda}
+				destino = append(destino, &g.Conversion.TotalFactura, &g.Conversion.TotalIVA,
+					&g.Conversion.TotalBaseImponible, &g.Conversion.TotalMontoExento, &g.Conversion.DocumentosSinTasa)
+			}
+
+			if err := rows.Scan(destino...); err != nil {
+				mensaje := "Error al leer el resumen de facturas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			for i, criterio := range criterios {
+				g.Grupo[criterio] = valores[i]
+			}
+			grupos = append(grupos, g)
+		}
+
+		logError(requestID+" - Resumen de facturas por "+strings.Join(criterios, ",")+": "+strconv.Itoa(total)+" grupos", nil)
+
+		aplicados := filtros.aplicados()
+		aplicados["agrupar"] = strings.Join(criterios, ",")
+		aplicados["montos"] = montos
+		respuesta.Pagina(c, "Resumen de facturas", grupos, len(grupos),
+			respuesta.NuevaPaginacion(total, filtros.page, filtros.pageSize), aplicados)
+	}
+}
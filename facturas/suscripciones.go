@@ -0,0 +1,102 @@
+package facturas
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/odoo"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Rutas de consulta por suscripcion de Odoo.
+	El codigo SUB se obtiene de las Observaciones de
+	cada factura (ver odoo.ParsearObservaciones).
+*/
+
+func Suscripciones(api *gin.RouterGroup, db *sql.DB) {
+	api.GET("/:codigo/facturas", cache.Respuestas(db, ttlCacheFacturas, "factura"), historialSuscripcion(db))
+	api.POST("/conciliacion", conciliarSuscripciones(db))
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Historial de facturacion de una suscripcion.
+Retorna todas las facturas cuyo Observaciones contiene
+el codigo SUB exacto, de la mas antigua a la mas reciente.
+Acepta los querys moneda y fechaCambio.
+*/
+func historialSuscripcion(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		codigo, ok := odoo.NormalizarSuscripcion(c.Param("codigo"))
+		if !ok {
+			logError(requestID+" - Código de suscripción inválido: "+c.Param("codigo"), nil)
+			respuesta.ErrorValidacion(c, []respuesta.ErrorParametro{
+				{Parametro: "codigo", Mensaje: "Código de suscripción inválido, use el formato SUB75885"},
+			})
+			return
+		}
+
+		var v respuesta.Validador
+		conversion := monedas.LeerConversion(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if !prepararConversion(c, db, conversion, requestID) {
+			return
+		}
+
+		query := `
+			SELECT ` + columnasFactura + `
+			FROM dbo.factura
+			WHERE ` + condicionSuscripcion + `
+			ORDER BY Fecha ASC
+		`
+
+		rows, err := db.Query(query, sql.Named("suscripcion", odoo.PatronLikeSuscripcion(codigo)))
+		if err != nil {
+			mensaje := "Error al consultar las facturas de la suscripción"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		facturas := []Factura{}
+		for rows.Next() {
+			factura, err := escanearFactura(rows)
+			if err != nil {
+				mensaje := "Error al leer datos de facturas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			if err := factura.convertir(conversion); err != nil {
+				mensaje := "Error al convertir los montos de las facturas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			facturas = append(facturas, factura)
+		}
+
+		if len(facturas) == 0 {
+			respuesta.SinResultados(c, "No se encontraron facturas de la suscripción "+codigo)
+			return
+		}
+
+		logError(requestID+" - Historial de "+codigo+": "+strconv.Itoa(len(facturas))+" facturas", nil)
+		respuesta.Exito(c, "Facturas de la suscripción "+codigo, facturas, len(facturas))
+	}
+}
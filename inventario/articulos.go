@@ -0,0 +1,347 @@
+package inventario
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Logger Interno para el registro de errores y problemas.
+	Solo se instancia en este modulo y generar el archivo
+	errores_inventario.log
+*/
+
+// Logger para registrar errores en un archivo
+var errorLogger *log.Logger
+
+func initErrorLogger() {
+	logFile, err := os.OpenFile("errores_inventario.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Println("Error al abrir archivo de log de inventario:", err)
+		return
+	}
+	errorLogger = log.New(logFile, "", log.Ldate|log.Ltime)
+	log.Println("Logger de errores de inventario inicializado correctamente")
+}
+
+func logError(mensaje string, err error) {
+	if errorLogger != nil {
+		errorLogger.Printf("[ERROR] %s: %v\n", mensaje, err)
+	} else {
+		log.Printf("[ERROR] %s: %v\n", mensaje, err)
+	}
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// Tiempo maximo que se guarda en cache una consulta de inventario
+const ttlCacheInventario = 5 * time.Minute
+
+// Tablas que lee la consulta de existencias
+var tablasExistencias = []string{"ArticuloInventario", "ExistenciaPorAlmacen", "ExistenciaPorGrupo"}
+
+func ArticuloRoutes(api *gin.RouterGroup, db *sql.DB) {
+	initErrorLogger()
+	api.GET("/", cache.Respuestas(db, ttlCacheInventario, "ArticuloInventario"), buscarArticulos(db))
+	api.GET("/:codigo/existencias", cache.Respuestas(db, ttlCacheInventario, tablasExistencias...), existenciasArticulo(db))
+}
+
+func ExistenciaRoutes(api *gin.RouterGroup, db *sql.DB) {
+	api.POST("/consulta", consultaExistencias(db))
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Articulo del catalogo de inventario de Galac.
+
+	Version es la de la fila (fldTimeStamp convertido a
+	numero). Cambia cada vez que Galac modifica el articulo y
+	se usa con el query cambiosDesde para sincronizar solo
+	los articulos modificados.
+
+	La sincronizacion se pagina por version y no con page:
+	cada respuesta trae siguienteCambiosDesde, que es el
+	cambiosDesde de la peticion siguiente. Con OFFSET, un
+	articulo modificado durante la sincronizacion se movia
+	al final y corria las paginas.
+*/
+
+type Articulo struct {
+	ConsecutivoCompania int        `json:"consecutivoCompania"`
+	Codigo              string     `json:"codigo"`
+	Descripcion         string     `json:"descripcion"`
+	LineaDeProducto     *string    `json:"lineaDeProducto"`
+	StatusdelArticulo   *string    `json:"statusDelArticulo"`
+	AlicuotaIva         *string    `json:"alicuotaIva"`
+	PrecioSinIva        *float64   `json:"precioSinIva"`
+	PrecioConIva        *float64   `json:"precioConIva"`
+	PrecioSinIva2       *float64   `json:"precioSinIva2"`
+	PrecioConIva2       *float64   `json:"precioConIva2"`
+	PrecioSinIva3       *float64   `json:"precioSinIva3"`
+	PrecioConIva3       *float64   `json:"precioConIva3"`
+	PrecioSinIva4       *float64   `json:"precioSinIva4"`
+	PrecioConIva4       *float64   `json:"precioConIva4"`
+	CostoUnitario       *float64   `json:"costoUnitario"`
+	Existencia          *float64   `json:"existencia"`
+	Categoria           *string    `json:"categoria"`
+	Marca               *string    `json:"marca"`
+	FechaModificacion   *time.Time `json:"fechaUltimaModificacion"`
+	Version             int64      `json:"version"`
+}
+
+type CambiosArticulos struct {
+	Articulos             []Articulo `json:"articulos"`
+	SiguienteCambiosDesde int64      `json:"siguienteCambiosDesde"`
+	Pendientes            int        `json:"pendientes"` // modificados despues de esta pagina
+}
+
+const columnasArticulo = `
+	ConsecutivoCompania, Codigo, Descripcion, LineaDeProducto, StatusdelArticulo, AlicuotaIva,
+	PrecioSinIva, PrecioConIva, PrecioSinIva2, PrecioConIva2,
+	PrecioSinIva3, PrecioConIva3, PrecioSinIva4, PrecioConIva4,
+	CostoUnitario, Existencia, Categoria, Marca, FechaUltimaModificacion,
+	CONVERT(BIGINT, fldTimeStamp)`
+
+func escanearArticulo(rows *sql.Rows) (Articulo, error) {
+	var a Articulo
+	err := rows.Scan(
+		&a.ConsecutivoCompania, &a.Codigo, &a.Descripcion, &a.LineaDeProducto, &a.StatusdelArticulo, &a.AlicuotaIva,
+		&a.PrecioSinIva, &a.PrecioConIva, &a.PrecioSinIva2, &a.PrecioConIva2,
+		&a.PrecioSinIva3, &a.PrecioConIva3, &a.PrecioSinIva4, &a.PrecioConIva4,
+		&a.CostoUnitario, &a.Existencia, &a.Categoria, &a.Marca, &a.FechaModificacion,
+		&a.Version,
+	)
+	return a, err
+}
+
+/*
+	Filtros de busqueda de articulos ya validados.
+*/
+
+type filtrosArticulo struct {
+	buscar       []string // palabras a buscar en codigo y descripcion
+	categoria    string
+	marca        string
+	linea        string
+	status       string
+	cambiosDesde *int64
+	page         int
+	pageSize     int
+}
+
+/*
+Lee y valida los querys de busqueda de articulos:
+
+buscar: texto a buscar en Codigo y Descripcion, cada
+
+	palabra debe aparecer en alguno de los dos
+
+categoria, marca, lineaDeProducto, status: filtros exactos
+cambiosDesde: version de la ultima sincronizacion, trae
+
+	solo los articulos modificados despues. No admite page
+
+page, pageSize: paginacion, por defecto 1 y 100
+*/
+func parsearFiltrosArticulo(c *gin.Context) (filtrosArticulo, []respuesta.ErrorParametro) {
+	var v respuesta.Validador
+	var f filtrosArticulo
+
+	f.buscar = strings.Fields(c.Query("buscar"))
+	f.categoria = strings.TrimSpace(c.Query("categoria"))
+	f.marca = strings.TrimSpace(c.Query("marca"))
+	f.linea = strings.TrimSpace(c.Query("lineaDeProducto"))
+	f.status = strings.TrimSpace(c.Query("status"))
+
+	if valor := strings.TrimSpace(c.Query("cambiosDesde")); valor != "" {
+		version, err := strconv.ParseInt(valor, 10, 64)
+		if err != nil || version < 0 {
+			v.Agregar("cambiosDesde", "Debe ser la version (numero entero) de la ultima sincronizacion")
+		} else {
+			f.cambiosDesde = &version
+		}
+	}
+
+	if f.cambiosDesde != nil && c.Query("page") != "" {
+		v.Agregar("page", "Con cambiosDesde no se usa page, envíe el siguienteCambiosDesde de la respuesta anterior")
+	}
+	f.page = v.Positivo(c.Query("page"), "page", 1)
+	f.pageSize = v.Positivo(c.Query("pageSize"), "pageSize", 100)
+
+	return f, v.Errores()
+}
+
+// Construye el WHERE (sin paginacion) y sus parametros
+func (f filtrosArticulo) condicion() (string, []interface{}) {
+	filterQuery := " WHERE 1=1"
+	params := []interface{}{}
+
+	// ----- Busqueda de texto ----- //
+	for i, palabra := range f.buscar {
+		nombre := "buscar" + strconv.Itoa(i)
+		filterQuery += " AND (Codigo LIKE @" + nombre + " OR Descripcion LIKE @" + nombre + ")"
+		params = append(params, sql.Named(nombre, "%"+palabra+"%"))
+	}
+
+	if f.categoria != "" {
+		filterQuery += " AND Categoria = @categoria"
+		params = append(params, sql.Named("categoria", f.categoria))
+	}
+	if f.marca != "" {
+		filterQuery += " AND Marca = @marca"
+		params = append(params, sql.Named("marca", f.marca))
+	}
+	if f.linea != "" {
+		filterQuery += " AND LineaDeProducto = @linea"
+		params = append(params, sql.Named("linea", f.linea))
+	}
+	if f.status != "" {
+		filterQuery += " AND StatusdelArticulo = @status"
+		params = append(params, sql.Named("status", f.status))
+	}
+
+	// ----- Cambios desde la ultima sincronizacion ----- //
+	if f.cambiosDesde != nil {
+		filterQuery += " AND CONVERT(BIGINT, fldTimeStamp) > @cambiosDesde"
+		params = append(params, sql.Named("cambiosDesde", *f.cambiosDesde))
+	}
+
+	return filterQuery, params
+}
+
+// Filtros aplicados, para informarlos en la respuesta
+func (f filtrosArticulo) aplicados() map[string]interface{} {
+	filtros := map[string]interface{}{}
+	if len(f.buscar) > 0 {
+		filtros["buscar"] = strings.Join(f.buscar, " ")
+	}
+	if f.categoria != "" {
+		filtros["categoria"] = f.categoria
+	}
+	if f.marca != "" {
+		filtros["marca"] = f.marca
+	}
+	if f.linea != "" {
+		filtros["lineaDeProducto"] = f.linea
+	}
+	if f.status != "" {
+		filtros["status"] = f.status
+	}
+	if f.cambiosDesde != nil {
+		filtros["cambiosDesde"] = *f.cambiosDesde
+	}
+	return filtros
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Catalogo de articulos de inventario (ver parsearFiltrosArticulo).
+
+Sin cambiosDesde se ordena por codigo y se pagina con page.
+Con cambiosDesde se ordena por version y se traen los
+pageSize siguientes; la respuesta es CambiosArticulos.
+*/
+func buscarArticulos(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		filtros, errores := parsearFiltrosArticulo(c)
+		if len(errores) > 0 {
+			logError(requestID+" - Parámetros de búsqueda de artículos inválidos", nil)
+			respuesta.ErrorValidacion(c, errores)
+			return
+		}
+
+		filterQuery, params := filtros.condicion()
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.ArticuloInventario"+filterQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de artículos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron artículos con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT ` + columnasArticulo + `
+			FROM dbo.ArticuloInventario` + filterQuery + `
+			ORDER BY Codigo, ConsecutivoCompania
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params, sql.Named("pageSize", filtros.pageSize))
+		if filtros.cambiosDesde != nil {
+			query = `
+				SELECT TOP (@pageSize) ` + columnasArticulo + `
+				FROM dbo.ArticuloInventario` + filterQuery + `
+				ORDER BY fldTimeStamp
+			`
+		} else {
+			params = append(params, sql.Named("offset", (filtros.page-1)*filtros.pageSize))
+		}
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar los artículos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		articulos := []Articulo{}
+		for rows.Next() {
+			articulo, err := escanearArticulo(rows)
+			if err != nil {
+				mensaje := "Error al leer datos de artículos"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			articulos = append(articulos, articulo)
+		}
+
+		if filtros.cambiosDesde != nil {
+			cambios := CambiosArticulos{
+				Articulos:             articulos,
+				SiguienteCambiosDesde: *filtros.cambiosDesde,
+				Pendientes:            total - len(articulos),
+			}
+			if len(articulos) > 0 {
+				cambios.SiguienteCambiosDesde = articulos[len(articulos)-1].Version
+			}
+			respuesta.Exito(c, "Artículos modificados", cambios, len(articulos))
+			return
+		}
+
+		respuesta.Pagina(c, "Artículos encontrados", articulos, len(articulos),
+			respuesta.NuevaPaginacion(total, filtros.page, filtros.pageSize), filtros.aplicados())
+	}
+}
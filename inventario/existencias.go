@@ -0,0 +1,378 @@
+package inventario
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Existencias por almacen y por grupo (talla, color, serial).
+
+	ArticuloInventario.Existencia es el total del articulo.
+	El detalle por almacen esta en ExistenciaPorAlmacen y el
+	de talla/color/serial/rollo en ExistenciaPorGrupo, que es
+	donde Galac guarda la cantidad reservada (CantReservada).
+
+	La cantidad minima es del articulo, no del almacen, asi
+	que el indicador bajoMinimo de cada almacen compara su
+	cantidad con la misma CantidadMinima del articulo.
+*/
+
+// Cantidad maxima de articulos por consulta masiva
+const maxArticulosConsulta = 1000
+
+type ExistenciaAlmacen struct {
+	CodigoAlmacen string  `json:"codigoAlmacen"`
+	Ubicacion     *string `json:"ubicacion"`
+	Cantidad      float64 `json:"cantidad"`
+	BajoMinimo    bool    `json:"bajoMinimo"`
+}
+
+type ExistenciaGrupo struct {
+	CodigoGrupo   string     `json:"codigoGrupo"`
+	CodigoTalla   string     `json:"codigoTalla"`
+	CodigoColor   string     `json:"codigoColor"`
+	Serial        string     `json:"serial"`
+	Rollo         string     `json:"rollo"`
+	CodigoLote    *string    `json:"codigoLote"`
+	Ubicacion     *string    `json:"ubicacion"`
+	Fecha         *time.Time `json:"fecha"`
+	Existencia    float64    `json:"existencia"`
+	CantReservada float64    `json:"cantReservada"`
+	Disponible    float64    `json:"disponible"`
+}
+
+type ExistenciasArticulo struct {
+	ConsecutivoCompania int     `json:"consecutivoCompania"`
+	CodigoArticulo      string  `json:"codigoArticulo"`
+	Descripcion         string  `json:"descripcion"`
+	Existencia          float64 `json:"existencia"`
+	CantReservada       float64 `json:"cantReservada"`
+	Disponible          float64 `json:"disponible"`
+	CantidadMinima      float64 `json:"cantidadMinima"`
+	BajoMinimo          bool    `json:"bajoMinimo"`
+
+	Almacenes []ExistenciaAlmacen `json:"almacenes"`
+	Grupos    []ExistenciaGrupo   `json:"grupos"`
+}
+
+type ConsultaExistencias struct {
+	Articulos     []ExistenciasArticulo `json:"articulos"`
+	NoEncontrados []string              `json:"noEncontrados"`
+	// Codigos que existen en varias companias, sin compania indicada
+	Ambiguos []string `json:"ambiguos"`
+}
+
+// Hay cantidad minima definida y la cantidad esta por debajo
+func bajoMinimo(cantidad, minimo float64) bool {
+	return minimo > 0 && cantidad < minimo
+}
+
+// Lee un monto que puede venir NULL como 0
+func valor(monto *float64) float64 {
+	if monto == nil {
+		return 0
+	}
+	return *monto
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// Parametros @c0, @c1, ... para un IN con los codigos
+func parametrosCodigos(codigos []string) (string, []interface{}) {
+	nombres := make([]string, len(codigos))
+	params := make([]interface{}, len(codigos))
+	for i, codigo := range codigos {
+		nombre := "c" + strconv.Itoa(i)
+		nombres[i] = "@" + nombre
+		params[i] = sql.Named(nombre, codigo)
+	}
+	return strings.Join(nombres, ", "), params
+}
+
+/*
+Existencias de los articulos indicados, en el orden de los
+codigos. almacen es opcional y limita los almacenes. Sin
+compania, los codigos que existen en varias companias no se
+consultan y se retornan en Ambiguos; los que no existen van
+en NoEncontrados.
+*/
+func consultarExistencias(db *sql.DB, codigos []string, almacen string, compania int, conCompania bool) (ConsultaExistencias, error) {
+	var consulta ConsultaExistencias
+	lista, params := parametrosCodigos(codigos)
+
+	// ----- Articulos ----- //
+	condicion, paramsArticulo := respuesta.FiltroCompania("Codigo IN ("+lista+")", "ConsecutivoCompania",
+		append([]interface{}{}, params...), compania, conCompania)
+	rows, err := db.Query(`
+		SELECT ConsecutivoCompania, Codigo, Descripcion, Existencia, CantArtReservado, CantidadMinima
+		FROM dbo.ArticuloInventario
+		WHERE `+condicion+`
+		ORDER BY ConsecutivoCompania
+	`, paramsArticulo...)
+	if err != nil {
+		return consulta, err
+	}
+	porCodigo := map[string]*ExistenciasArticulo{}
+	ambiguos := map[string]bool{}
+	for rows.Next() {
+		var a ExistenciasArticulo
+		var existencia, reservada, minimo *float64
+		if err := rows.Scan(&a.ConsecutivoCompania, &a.CodigoArticulo, &a.Descripcion, &existencia, &reservada, &minimo); err != nil {
+			rows.Close()
+			return consulta, err
+		}
+		if _, ok := porCodigo[a.CodigoArticulo]; ok {
+			ambiguos[a.CodigoArticulo] = true
+			continue
+		}
+		a.Existencia = valor(existencia)
+		a.CantReservada = valor(reservada)
+		a.Disponible = a.Existencia - a.CantReservada
+		a.CantidadMinima = valor(minimo)
+		a.BajoMinimo = bajoMinimo(a.Existencia, a.CantidadMinima)
+		a.Almacenes = []ExistenciaAlmacen{}
+		a.Grupos = []ExistenciaGrupo{}
+		porCodigo[a.CodigoArticulo] = &a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return consulta, err
+	}
+	for codigo := range ambiguos {
+		delete(porCodigo, codigo)
+	}
+
+	// ----- Por almacen ----- //
+	queryAlmacen := `
+		SELECT ConsecutivoCompania, CodigoArticulo, CodigoAlmacen, Ubicacion, Cantidad
+		FROM dbo.ExistenciaPorAlmacen
+		WHERE CodigoArticulo IN (` + lista + `)`
+	paramsAlmacen := params
+	if almacen != "" {
+		queryAlmacen += " AND CodigoAlmacen = @almacen"
+		paramsAlmacen = append(append([]interface{}{}, params...), sql.Named("almacen", almacen))
+	}
+	rows, err = db.Query(queryAlmacen+" ORDER BY CodigoArticulo, CodigoAlmacen", paramsAlmacen...)
+	if err != nil {
+		return consulta, err
+	}
+	for rows.Next() {
+		var compania int
+		var codigo string
+		var e ExistenciaAlmacen
+		var cantidad *float64
+		if err := rows.Scan(&compania, &codigo, &e.CodigoAlmacen, &e.Ubicacion, &cantidad); err != nil {
+			rows.Close()
+			return consulta, err
+		}
+		a, ok := porCodigo[codigo]
+		if !ok || a.ConsecutivoCompania != compania {
+			continue
+		}
+		e.Cantidad = valor(cantidad)
+		e.BajoMinimo = bajoMinimo(e.Cantidad, a.CantidadMinima)
+		a.Almacenes = append(a.Almacenes, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return consulta, err
+	}
+
+	// ----- Por grupo (talla, color, serial) ----- //
+	rows, err = db.Query(`
+		SELECT ConsecutivoCompania, CodigoArticulo, CodigoGrupo, CodigoTalla, CodigoColor, Serial, Rollo,
+		       CodigoLote, Ubicacion, Fecha, Existencia, CantReservada
+		FROM dbo.ExistenciaPorGrupo
+		WHERE CodigoArticulo IN (`+lista+`)
+		ORDER BY CodigoArticulo, CodigoGrupo, CodigoTalla, CodigoColor, Serial, Rollo
+	`, params...)
+	if err != nil {
+		return consulta, err
+	}
+	for rows.Next() {
+		var compania int
+		var codigo string
+		var g ExistenciaGrupo
+		var existencia, reservada *float64
+		if err := rows.Scan(&compania, &codigo, &g.CodigoGrupo, &g.CodigoTalla, &g.CodigoColor, &g.Serial, &g.Rollo,
+			&g.CodigoLote, &g.Ubicacion, &g.Fecha, &existencia, &reservada); err != nil {
+			rows.Close()
+			return consulta, err
+		}
+		a, ok := porCodigo[codigo]
+		if !ok || a.ConsecutivoCompania != compania {
+			continue
+		}
+		g.Existencia = valor(existencia)
+		g.CantReservada = valor(reservada)
+		g.Disponible = g.Existencia - g.CantReservada
+		a.Grupos = append(a.Grupos, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return consulta, err
+	}
+
+	consulta.Articulos = []ExistenciasArticulo{}
+	consulta.NoEncontrados = []string{}
+	consulta.Ambiguos = []string{}
+	for _, codigo := range codigos {
+		if a, ok := porCodigo[codigo]; ok {
+			consulta.Articulos = append(consulta.Articulos, *a)
+		} else if ambiguos[codigo] {
+			consulta.Ambiguos = append(consulta.Ambiguos, codigo)
+		} else {
+			consulta.NoEncontrados = append(consulta.NoEncontrados, codigo)
+		}
+	}
+	return consulta, nil
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Existencias de un articulo por almacen y por grupo.
+Querys:
+
+almacen: codigo de almacen, opcional
+compania: ConsecutivoCompania; si el codigo existe en varias
+companias y no se indica se responde 409
+*/
+func existenciasArticulo(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		codigo := strings.TrimSpace(c.Param("codigo"))
+		almacen := strings.TrimSpace(c.Query("almacen"))
+
+		var v respuesta.Validador
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		consulta, err := consultarExistencias(db, []string{codigo}, almacen, compania, conCompania)
+		if err != nil {
+			mensaje := "Error al consultar las existencias del artículo"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if len(consulta.Ambiguos) > 0 {
+			respuesta.Error(c, http.StatusConflict, respuesta.Ambiguo,
+				"El artículo "+codigo+" existe en varias compañías, indique compania")
+			return
+		}
+		if len(consulta.Articulos) == 0 {
+			respuesta.Error(c, http.StatusNotFound, respuesta.NoEncontrado, "No existe el artículo "+codigo)
+			return
+		}
+
+		respuesta.Exito(c, "Existencias del artículo "+codigo, consulta.Articulos[0], 1)
+	}
+}
+
+/*
+Consulta masiva de existencias. Es POST solo para recibir
+la lista de codigos, no se escribe nada en Galac.
+
+	{"codigos": ["A001", ...], "almacen": "01", "compania": 1, "soloBajoMinimo": false}
+
+soloBajoMinimo deja solo los articulos bajo el minimo; con
+almacen se evalua la cantidad de ese almacen. compania es
+opcional; sin ella los codigos que existen en varias
+companias se retornan en ambiguos.
+*/
+func consultaExistencias(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion del cuerpo ----- //
+		var cuerpo struct {
+			Codigos        []string `json:"codigos"`
+			Almacen        string   `json:"almacen"`
+			Compania       *int     `json:"compania"`
+			SoloBajoMinimo bool     `json:"soloBajoMinimo"`
+		}
+		var v respuesta.Validador
+		if err := c.ShouldBindJSON(&cuerpo); err != nil {
+			logError(requestID+" - Error al leer la consulta de existencias", err)
+			v.Agregar("codigos", "Envíe un JSON {\"codigos\": [...]}")
+		}
+
+		codigos := []string{}
+		vistos := map[string]bool{}
+		for _, codigo := range cuerpo.Codigos {
+			codigo = strings.TrimSpace(codigo)
+			if codigo != "" && !vistos[codigo] {
+				vistos[codigo] = true
+				codigos = append(codigos, codigo)
+			}
+		}
+		if v.Valido() && len(codigos) == 0 {
+			v.Agregar("codigos", "La lista de códigos está vacía")
+		}
+		if len(codigos) > maxArticulosConsulta {
+			v.Agregar("codigos", "Se permiten como máximo "+strconv.Itoa(maxArticulosConsulta)+" códigos")
+		}
+		compania, conCompania := 0, cuerpo.Compania != nil
+		if conCompania {
+			compania = *cuerpo.Compania
+			if compania < 0 {
+				v.Agregar("compania", "Debe ser un número mayor o igual a 0")
+			}
+		}
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		almacen := strings.TrimSpace(cuerpo.Almacen)
+		consulta, err := consultarExistencias(db, codigos, almacen, compania, conCompania)
+		if err != nil {
+			mensaje := "Error al consultar las existencias"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if cuerpo.SoloBajoMinimo {
+			filtrados := []ExistenciasArticulo{}
+			for _, a := range consulta.Articulos {
+				bajo := a.BajoMinimo
+				if almacen != "" {
+					// Sin fila en el almacen la cantidad es 0
+					bajo = len(a.Almacenes) == 0 && bajoMinimo(0, a.CantidadMinima)
+					for _, e := range a.Almacenes {
+						bajo = bajo || e.BajoMinimo
+					}
+				}
+				if bajo {
+					filtrados = append(filtrados, a)
+				}
+			}
+			consulta.Articulos = filtrados
+		}
+
+		logError(requestID+" - Consulta de existencias: "+strconv.Itoa(len(codigos))+" códigos, "+
+			strconv.Itoa(len(consulta.NoEncontrados))+" no encontrados, "+strconv.Itoa(len(consulta.Ambiguos))+" ambiguos", nil)
+
+		respuesta.Exito(c, "Existencias encontradas", consulta, len(consulta.Articulos))
+	}
+}
@@ -4,10 +4,17 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
-	clientes "github.com/desarrolladoresnet/api_galac_bd/cliente"
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/docs"
 	"github.com/desarrolladoresnet/api_galac_bd/facturas"
+	"github.com/desarrolladoresnet/api_galac_bd/reportes"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/desarrolladoresnet/api_galac_bd/rutas"
+	"github.com/desarrolladoresnet/api_galac_bd/tesoreria"
+	"github.com/desarrolladoresnet/api_galac_bd/ventas"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	_ "github.com/microsoft/go-mssqldb"
@@ -48,30 +55,46 @@ func main() {
 	}
 	fmt.Println("¡Conexión establecida correctamente!")
 
+	// Cache de respuestas para las consultas repetidas
+	cache.Inicializar(500)
+
+	// Nombres de los campos definibles de las facturas (opcional)
+	if err := facturas.CargarCamposDefinidos("campos_definidos.json"); err != nil {
+		log.Fatal("Error en campos_definidos.json:", err.Error())
+	}
+
+	// Codigos de Galac que el esquema no documenta (opcional)
+	if err := reportes.CargarContribuyenteEspecial("codigos_galac.json"); err != nil {
+		log.Fatal("Error en codigos_galac.json:", err.Error())
+	}
+	if err := ventas.CargarCodigosContrato("codigos_galac.json"); err != nil {
+		log.Fatal("Error en codigos_galac.json:", err.Error())
+	}
+	if err := tesoreria.CargarTiposConcepto("codigos_galac.json"); err != nil {
+		log.Fatal("Error en codigos_galac.json:", err.Error())
+	}
+
 	// Inicializar Gin
 	router := gin.Default()
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // ✅ solo tu app de Vite
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key", "If-None-Match"},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "X-Cache"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	// Ruta básica
-	router.GET("/ping", func(c *gin.Context) {
-		c.String(200, "Hello World")
+	// Respuesta para rutas inexistentes con el formato comun
+	router.NoRoute(func(c *gin.Context) {
+		respuesta.Error(c, http.StatusNotFound, respuesta.NoEncontrado, "Ruta no encontrada")
 	})
 
-	// Rutas para obtencion de facturas
-	api_facturas := router.Group("facturas")
-	facturas.Facturas(api_facturas, db)
+	// Rutas de la API y de la documentacion
+	rutas.Registrar(router, db)
+	docs.VerificarRutas(router)
 
-	// Rutas para obtencion de clientes
-	api_clientes := router.Group("clientes")
-	clientes.ClienteRoutes(api_clientes, db)
 	// Iniciar servidor
 	router.Run(":5000")
 }
@@ -0,0 +1,124 @@
+package monedas
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+/*
+	Historial de tasas de cambio usadas por Galac.
+
+	La BD no tiene una tabla de tasas, Galac guarda la tasa
+	en cada documento. El historial se obtiene de dbo.factura:
+
+	  - facturas en la moneda: CambioABolivares
+	  - cuentas por cobrar en la moneda: CambioMonedaCXC
+	  - facturas en bolivares (solo para el dolar):
+	    CambioMostrarTotalEnDivisas
+
+	Las tasas se expresan en bolivares por unidad de la moneda
+	y se toma la mayor tasa usada en cada dia. Los valores
+	menores o iguales a 1 se descartan porque Galac usa 1
+	cuando la factura no tiene tasa.
+*/
+
+const (
+	MonedaLocal = "VED"
+	MonedaDolar = "USD"
+)
+
+// Tasa de cambio de un dia
+type Cambio struct {
+	Fecha time.Time `json:"fecha"`
+	Tasa  float64   `json:"tasa"`
+}
+
+// Tasas por dia de las facturas que cumplen la condicion
+func consultaTasas(condicion string) string {
+	return `
+		SELECT Dia, MAX(Tasa) AS Tasa
+		FROM (
+			SELECT CAST(Fecha AS date) AS Dia, CambioABolivares AS Tasa
+			FROM dbo.factura
+			WHERE CodigoMoneda = @codigo AND CambioABolivares > 1` + condicion + `
+			UNION ALL
+			SELECT CAST(Fecha AS date), CambioMonedaCXC
+			FROM dbo.factura
+			WHERE CodigoMonedaDeCobro = @codigo AND CambioMonedaCXC > 1` + condicion + `
+			UNION ALL
+			SELECT CAST(Fecha AS date), CambioMostrarTotalEnDivisas
+			FROM dbo.factura
+			WHERE @codigo = @dolar AND CodigoMoneda = @local AND CambioMostrarTotalEnDivisas > 1` + condicion + `
+		) tasas
+		GROUP BY Dia
+	`
+}
+
+func leerCambios(db *sql.DB, query string, params []interface{}) ([]Cambio, error) {
+	params = append(params,
+		sql.Named("dolar", MonedaDolar),
+		sql.Named("local", MonedaLocal),
+	)
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cambios := []Cambio{}
+	for rows.Next() {
+		var c Cambio
+		if err := rows.Scan(&c.Fecha, &c.Tasa); err != nil {
+			return nil, err
+		}
+		cambios = append(cambios, c)
+	}
+	return cambios, rows.Err()
+}
+
+/*
+Tasas de cambio de la moneda por dia, de la mas antigua a
+la mas reciente. desde y hasta son opcionales; hasta no se
+incluye (igual que en respuesta.Validador.RangoFechas).
+*/
+func HistorialCambios(db *sql.DB, codigo string, desde, hasta *time.Time) ([]Cambio, error) {
+	condicion := ""
+	params := []interface{}{sql.Named("codigo", codigo)}
+	if desde != nil {
+		condicion += " AND Fecha >= @desde"
+		params = append(params, sql.Named("desde", *desde))
+	}
+	if hasta != nil {
+		condicion += " AND Fecha < @hasta"
+		params = append(params, sql.Named("hasta", *hasta))
+	}
+	return leerCambios(db, consultaTasas(condicion)+" ORDER BY Dia", params)
+}
+
+/*
+Tasa aplicable en una fecha: la del ultimo dia con tasa
+igual o anterior a la fecha. Retorna nil si no hay ninguna.
+*/
+func CambioEnFecha(db *sql.DB, codigo string, fecha time.Time) (*Cambio, error) {
+	query := "SELECT TOP 1 * FROM (" + consultaTasas(" AND Fecha < @hasta") + ") dias ORDER BY Dia DESC"
+	cambios, err := leerCambios(db, query, []interface{}{
+		sql.Named("codigo", codigo),
+		sql.Named("hasta", fecha.AddDate(0, 0, 1)),
+	})
+	if err != nil || len(cambios) == 0 {
+		return nil, err
+	}
+	return &cambios[0], nil
+}
+
+// Busca en un historial ordenado la tasa aplicable en la fecha
+func buscarCambio(cambios []Cambio, fecha time.Time) *Cambio {
+	i := sort.Search(len(cambios), func(i int) bool {
+		return cambios[i].Fecha.After(fecha)
+	})
+	if i == 0 {
+		return nil
+	}
+	return &cambios[i-1]
+}
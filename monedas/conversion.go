@@ -0,0 +1,276 @@
+package monedas
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Conversion de montos entre bolivares y dolares.
+
+	Se activa con el query moneda=USD|VED. Galac guarda todos
+	los montos de las facturas en bolivares, sin importar la
+	moneda de la factura (CodigoMoneda); las tasas del
+	documento solo indican a cuantos bolivares equivale el
+	dolar. Por eso VED no cambia los montos y USD los divide
+	entre la tasa del dolar del documento, que se busca en
+	este orden:
+
+	  1. fechaCambio=AAAA-MM-DD: la tasa del dolar aplicable en
+	     esa fecha para todos los documentos (ver CambioEnFecha)
+	  2. CambioABolivares, si la factura es en dolares
+	  3. CambioMonedaCXC, si la cuenta por cobrar es en dolares
+	     (CodigoMonedaDeCobro)
+	  4. CambioMostrarTotalEnDivisas
+	  5. la tasa del dia del documento segun el historial
+
+	Galac guarda 1 cuando el documento no tiene tasa, por eso
+	solo se usan tasas mayores a 1. Los montos originales no
+	se modifican, la conversion se agrega aparte.
+
+	Los estados de cuenta de clientes quedan fuera: la API no
+	tiene ese endpoint, y cuando se agregue debe convertir
+	cada documento con Aplicar igual que /facturas.
+*/
+
+var monedasConversion = map[string]string{
+	"USD": MonedaDolar,
+	"VED": MonedaLocal,
+	"VES": MonedaLocal,
+	"BS":  MonedaLocal,
+}
+
+// Origen de la tasa usada en la conversion
+const (
+	OrigenDocumento      = "DOCUMENTO"       // tasa guardada en el documento
+	OrigenFechaDocumento = "FECHA_DOCUMENTO" // historial, en la fecha del documento
+	OrigenFechaCambio    = "FECHA_CAMBIO"    // historial, en la fecha solicitada
+	OrigenSinTasa        = "SIN_TASA"        // no se encontro tasa, no se convirtio
+)
+
+// Mensaje para el cliente cuando no hay tasa en fechaCambio
+const MensajeSinTasa = "No hay tasa del dólar registrada hasta la fecha indicada"
+
+// Parametros de conversion de una peticion
+type Conversion struct {
+	Moneda      string
+	FechaCambio *time.Time
+
+	db        *sql.DB
+	cambio    *Cambio  // tasa del dolar en FechaCambio
+	historial []Cambio // se carga solo si algun documento no tiene tasa
+	cargado   bool
+}
+
+// Datos de moneda de un documento de Galac
+type Documento struct {
+	Moneda           string
+	Fecha            time.Time
+	CambioABolivares *float64
+	MonedaCobro      *string // CodigoMonedaDeCobro de las facturas
+	CambioCXC        float64 // CambioMonedaCXC de las facturas
+	CambioDivisas    float64 // CambioMostrarTotalEnDivisas de las facturas
+}
+
+/*
+Columnas de dbo.factura con los datos de moneda, en el orden
+de Documento.Destinos. El prefijo es el alias de la tabla
+("f.") o vacio.
+*/
+func ColumnasDocumento(prefijo string) string {
+	return prefijo + "CodigoMoneda, " + prefijo + "CambioABolivares, " + prefijo + "CodigoMonedaDeCobro, " +
+		prefijo + "CambioMonedaCXC, " + prefijo + "CambioMostrarTotalEnDivisas"
+}
+
+// Destinos para Scan de las columnas de ColumnasDocumento
+func (doc *Documento) Destinos() []interface{} {
+	return []interface{}{&doc.Moneda, &doc.CambioABolivares, &doc.MonedaCobro, &doc.CambioCXC, &doc.CambioDivisas}
+}
+
+// Conversion aplicada a un documento
+type Resultado struct {
+	Moneda     string              `json:"moneda"`
+	Tasa       *float64            `json:"tasa"` // bolivares por dolar
+	FechaTasa  *time.Time          `json:"fechaTasa,omitempty"`
+	OrigenTasa string              `json:"origenTasa"`
+	Montos     map[string]*float64 `json:"montos"`
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Lee los querys moneda (USD o VED) y fechaCambio.
+Retorna nil si no se pidio conversion.
+*/
+func LeerConversion(c *gin.Context, v *respuesta.Validador) *Conversion {
+	moneda, ok := v.Opcion(strings.ToUpper(c.Query("moneda")), "moneda", monedasConversion,
+		"Moneda inválida. Use: USD o VED")
+	fecha := v.Fecha(c.Query("fechaCambio"), "fechaCambio")
+
+	if !ok {
+		if fecha != nil {
+			v.Agregar("fechaCambio", "fechaCambio requiere el query moneda")
+		}
+		return nil
+	}
+	return &Conversion{Moneda: moneda, FechaCambio: fecha}
+}
+
+/*
+Busca la tasa de fechaCambio (si se pidio). Retorna false
+si no hay ninguna tasa registrada hasta esa fecha.
+*/
+func (cv *Conversion) Preparar(db *sql.DB) (bool, error) {
+	cv.db = db
+	if cv.FechaCambio == nil {
+		return true, nil
+	}
+	cambio, err := CambioEnFecha(db, MonedaDolar, *cv.FechaCambio)
+	if err != nil {
+		return false, err
+	}
+	cv.cambio = cambio
+	return cambio != nil, nil
+}
+
+// Tasa del dolar en la fecha segun el historial completo
+func (cv *Conversion) cambioHistorial(fecha time.Time) (*Cambio, error) {
+	if !cv.cargado {
+		historial, err := HistorialCambios(cv.db, MonedaDolar, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		cv.historial = historial
+		cv.cargado = true
+	}
+	return buscarCambio(cv.historial, fecha), nil
+}
+
+// Tasa del dolar guardada en el documento, 0 si no tiene
+func (doc Documento) tasaGuardada() float64 {
+	if doc.Moneda == MonedaDolar && doc.CambioABolivares != nil && *doc.CambioABolivares > 1 {
+		return *doc.CambioABolivares
+	}
+	if doc.MonedaCobro != nil && *doc.MonedaCobro == MonedaDolar && doc.CambioCXC > 1 {
+		return doc.CambioCXC
+	}
+	if doc.CambioDivisas > 1 {
+		return doc.CambioDivisas
+	}
+	return 0
+}
+
+// Tasa del dolar a usar para el documento y su origen
+func (cv *Conversion) tasaDolar(doc Documento) (*Cambio, string, error) {
+	if cv.cambio != nil {
+		return cv.cambio, OrigenFechaCambio, nil
+	}
+	if tasa := doc.tasaGuardada(); tasa > 0 {
+		return &Cambio{Tasa: tasa}, OrigenDocumento, nil
+	}
+
+	cambio, err := cv.cambioHistorial(doc.Fecha)
+	if err != nil || cambio == nil {
+		return nil, OrigenSinTasa, err
+	}
+	return cambio, OrigenFechaDocumento, nil
+}
+
+/*
+Factor para llevar un monto del documento (en bolivares)
+a la moneda solicitada, con la tasa del dolar usada.
+*/
+func (cv *Conversion) factor(doc Documento) (float64, *Cambio, string, error) {
+	if cv.Moneda == MonedaLocal {
+		return 1, nil, OrigenDocumento, nil
+	}
+
+	cambio, origen, err := cv.tasaDolar(doc)
+	if err != nil || cambio == nil {
+		return 0, nil, origen, err
+	}
+	return 1 / cambio.Tasa, cambio, origen, nil
+}
+
+// Redondea a centimos
+func redondear(monto float64) float64 {
+	return math.Round(monto*100) / 100
+}
+
+/*
+Convierte los montos de un documento. Las claves del mapa
+se copian en el resultado; los montos nil quedan en nil.
+*/
+func (cv *Conversion) Aplicar(doc Documento, montos map[string]*float64) (*Resultado, error) {
+	factor, cambio, origen, err := cv.factor(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resultado{Moneda: cv.Moneda, OrigenTasa: origen}
+	if cambio != nil {
+		tasa := cambio.Tasa
+		r.Tasa = &tasa
+		if !cambio.Fecha.IsZero() {
+			fecha := cambio.Fecha
+			r.FechaTasa = &fecha
+		}
+	}
+	if origen == OrigenSinTasa {
+		return r, nil
+	}
+
+	r.Montos = make(map[string]*float64, len(montos))
+	for nombre, monto := range montos {
+		if monto == nil {
+			r.Montos[nombre] = nil
+			continue
+		}
+		convertido := redondear(*monto * factor)
+		r.Montos[nombre] = &convertido
+	}
+	return r, nil
+}
+
+/*
+Expresion SQL del factor de conversion de una fila de
+dbo.factura, para sumar montos convertidos en la BD. Usa
+la misma tasa que Aplicar, salvo el historial: las facturas
+sin tasa dan NULL y no se suman. El prefijo es el alias de
+la tabla ("f.") o vacio.
+*/
+func (cv *Conversion) ExpresionFactor(prefijo string) (string, []interface{}) {
+	if cv.Moneda == MonedaLocal {
+		return "1", nil
+	}
+	if cv.cambio != nil {
+		return "(1 / @convTasa)", []interface{}{sql.Named("convTasa", cv.cambio.Tasa)}
+	}
+
+	tasa := "(CASE WHEN " + prefijo + "CodigoMoneda = @convDolar AND " + prefijo + "CambioABolivares > 1 THEN " + prefijo + "CambioABolivares " +
+		"WHEN " + prefijo + "CodigoMonedaDeCobro = @convDolar AND " + prefijo + "CambioMonedaCXC > 1 THEN " + prefijo + "CambioMonedaCXC " +
+		"WHEN " + prefijo + "CambioMostrarTotalEnDivisas > 1 THEN " + prefijo + "CambioMostrarTotalEnDivisas END)"
+	return "(1 / " + tasa + ")", []interface{}{sql.Named("convDolar", MonedaDolar)}
+}
+
+/*
+Igual que ExpresionFactor para un renglon de
+dbo.renglonCobroDeFactura (prefijos del cobro y de su
+factura): el cobro en dolares usa su propio
+CambioAMonedaLocal y los demas la tasa de la factura.
+*/
+func (cv *Conversion) ExpresionFactorCobro(cobro, factura string) (string, []interface{}) {
+	factor, params := cv.ExpresionFactor(factura)
+	if cv.Moneda == MonedaLocal || cv.cambio != nil {
+		return factor, params
+	}
+	return "(CASE WHEN " + cobro + "CodigoMoneda = @convDolar AND " + cobro + "CambioAMonedaLocal > 1 " +
+		"THEN 1 / " + cobro + "CambioAMonedaLocal ELSE " + factor + " END)", params
+}
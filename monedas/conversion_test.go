@@ -0,0 +1,171 @@
+package monedas
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dia(texto string) time.Time {
+	f, _ := time.Parse("2006-01-02", texto)
+	return f
+}
+
+func monto(valor float64) *float64 {
+	return &valor
+}
+
+func texto(valor string) *string {
+	return &valor
+}
+
+// Nombres de los parametros sql.Named
+func nombres(params []interface{}) []string {
+	var lista []string
+	for _, p := range params {
+		lista = append(lista, p.(sql.NamedArg).Name)
+	}
+	return lista
+}
+
+// Historial ya cargado, para no consultar la BD
+var historialPrueba = []Cambio{
+	{Fecha: dia("2025-04-01"), Tasa: 30},
+	{Fecha: dia("2025-04-10"), Tasa: 35},
+}
+
+func TestAplicar(t *testing.T) {
+	sinTasa := Documento{Moneda: MonedaLocal, Fecha: dia("2025-04-05"), CambioABolivares: monto(1), CambioCXC: 1, CambioDivisas: 1}
+	fechaCambio := &Cambio{Fecha: dia("2025-04-20"), Tasa: 50}
+
+	casos := []struct {
+		nombre    string
+		moneda    string
+		cambio    *Cambio // tasa de fechaCambio
+		historial []Cambio
+		doc       Documento
+		tasa      *float64
+		fechaTasa *time.Time
+		origen    string
+		total     *float64 // total de 1000 convertido
+	}{
+		{"VED no convierte", MonedaLocal, nil, nil,
+			Documento{Moneda: MonedaDolar, CambioABolivares: monto(40)},
+			nil, nil, OrigenDocumento, monto(1000)},
+		{"factura en dolares", MonedaDolar, nil, nil,
+			Documento{Moneda: MonedaDolar, CambioABolivares: monto(40), CambioCXC: 38, CambioDivisas: 36},
+			monto(40), nil, OrigenDocumento, monto(25)},
+		{"cobro en dolares", MonedaDolar, nil, nil,
+			Documento{Moneda: MonedaLocal, CambioABolivares: monto(40), MonedaCobro: texto(MonedaDolar), CambioCXC: 38, CambioDivisas: 36},
+			monto(38), nil, OrigenDocumento, monto(26.32)},
+		{"cobro en bolivares usa divisas", MonedaDolar, nil, nil,
+			Documento{Moneda: MonedaLocal, MonedaCobro: texto(MonedaLocal), CambioCXC: 38, CambioDivisas: 36},
+			monto(36), nil, OrigenDocumento, monto(27.78)},
+		{"tasa 1 no cuenta", MonedaDolar, nil, nil,
+			Documento{Moneda: MonedaDolar, CambioABolivares: monto(1), CambioDivisas: 36},
+			monto(36), nil, OrigenDocumento, monto(27.78)},
+		{"fechaCambio antes que el documento", MonedaDolar, fechaCambio, nil,
+			Documento{Moneda: MonedaDolar, CambioABolivares: monto(40)},
+			monto(50), &fechaCambio.Fecha, OrigenFechaCambio, monto(20)},
+		{"historial en la fecha del documento", MonedaDolar, nil, historialPrueba,
+			sinTasa, monto(30), &historialPrueba[0].Fecha, OrigenFechaDocumento, monto(33.33)},
+		{"historial, ultimo dia anterior", MonedaDolar, nil, historialPrueba,
+			Documento{Moneda: MonedaLocal, Fecha: dia("2025-05-01")},
+			monto(35), &historialPrueba[1].Fecha, OrigenFechaDocumento, monto(28.57)},
+		{"sin tasa", MonedaDolar, nil, historialPrueba,
+			Documento{Moneda: MonedaLocal, Fecha: dia("2025-03-31")},
+			nil, nil, OrigenSinTasa, nil},
+		{"sin historial", MonedaDolar, nil, []Cambio{}, sinTasa, nil, nil, OrigenSinTasa, nil},
+	}
+
+	for _, caso := range casos {
+		cv := &Conversion{Moneda: caso.moneda, cambio: caso.cambio, historial: caso.historial, cargado: true}
+		r, err := cv.Aplicar(caso.doc, map[string]*float64{"total": monto(1000), "descuento": nil})
+		if err != nil {
+			t.Errorf("%s: Aplicar() error %v", caso.nombre, err)
+			continue
+		}
+
+		if r.Moneda != caso.moneda || r.OrigenTasa != caso.origen ||
+			!reflect.DeepEqual(r.Tasa, caso.tasa) || !reflect.DeepEqual(r.FechaTasa, caso.fechaTasa) {
+			t.Errorf("%s: Aplicar() = moneda %s, tasa %v, fechaTasa %v, origen %s; se esperaba %s, %v, %v, %s",
+				caso.nombre, r.Moneda, valor(r.Tasa), r.FechaTasa, r.OrigenTasa,
+				caso.moneda, valor(caso.tasa), caso.fechaTasa, caso.origen)
+		}
+
+		if caso.total == nil {
+			if r.Montos != nil {
+				t.Errorf("%s: Aplicar() montos = %v, se esperaba nil", caso.nombre, r.Montos)
+			}
+			continue
+		}
+		descuento, ok := r.Montos["descuento"]
+		if !reflect.DeepEqual(r.Montos["total"], caso.total) || !ok || descuento != nil {
+			t.Errorf("%s: Aplicar() total = %v, descuento = %v; se esperaba %v y nil",
+				caso.nombre, valor(r.Montos["total"]), valor(descuento), *caso.total)
+		}
+	}
+}
+
+func valor(monto *float64) interface{} {
+	if monto == nil {
+		return nil
+	}
+	return *monto
+}
+
+// Tasa guardada en la factura, igual que Documento.tasaGuardada
+const tasaFactura = "(CASE WHEN f.CodigoMoneda = @convDolar AND f.CambioABolivares > 1 THEN f.CambioABolivares " +
+	"WHEN f.CodigoMonedaDeCobro = @convDolar AND f.CambioMonedaCXC > 1 THEN f.CambioMonedaCXC " +
+	"WHEN f.CambioMostrarTotalEnDivisas > 1 THEN f.CambioMostrarTotalEnDivisas END)"
+
+func TestExpresionFactor(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		cv        Conversion
+		esperado  string
+		parametro []string
+	}{
+		{"VED", Conversion{Moneda: MonedaLocal}, "1", nil},
+		{"fechaCambio", Conversion{Moneda: MonedaDolar, cambio: &Cambio{Tasa: 50}}, "(1 / @convTasa)", []string{"convTasa"}},
+		{"tasa de la factura", Conversion{Moneda: MonedaDolar}, "(1 / " + tasaFactura + ")", []string{"convDolar"}},
+	}
+
+	for _, caso := range casos {
+		expresion, params := caso.cv.ExpresionFactor("f.")
+		if expresion != caso.esperado || !reflect.DeepEqual(nombres(params), caso.parametro) {
+			t.Errorf("%s: ExpresionFactor(%q) = %q, %v; se esperaba %q, %v",
+				caso.nombre, "f.", expresion, nombres(params), caso.esperado, caso.parametro)
+		}
+	}
+
+	// Sin prefijo las columnas van sin alias
+	if expresion, _ := (&Conversion{Moneda: MonedaDolar}).ExpresionFactor(""); strings.Contains(expresion, "f.") {
+		t.Errorf("ExpresionFactor(%q) = %q, no se esperaba el alias f.", "", expresion)
+	}
+}
+
+func TestExpresionFactorCobro(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		cv        Conversion
+		esperado  string
+		parametro []string
+	}{
+		{"VED", Conversion{Moneda: MonedaLocal}, "1", nil},
+		{"fechaCambio", Conversion{Moneda: MonedaDolar, cambio: &Cambio{Tasa: 50}}, "(1 / @convTasa)", []string{"convTasa"}},
+		{"tasa del cobro o de la factura", Conversion{Moneda: MonedaDolar},
+			"(CASE WHEN r.CodigoMoneda = @convDolar AND r.CambioAMonedaLocal > 1 THEN 1 / r.CambioAMonedaLocal " +
+				"ELSE (1 / " + tasaFactura + ") END)", []string{"convDolar"}},
+	}
+
+	for _, caso := range casos {
+		expresion, params := caso.cv.ExpresionFactorCobro("r.", "f.")
+		if expresion != caso.esperado || !reflect.DeepEqual(nombres(params), caso.parametro) {
+			t.Errorf("%s: ExpresionFactorCobro(%q, %q) = %q, %v; se esperaba %q, %v",
+				caso.nombre, "r.", "f.", expresion, nombres(params), caso.esperado, caso.parametro)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package monedas
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Logger Interno para el registro de errores y problemas.
+	Solo se instancia en este modulo y generar el archivo
+	errores_monedas.log
+*/
+
+// Logger para registrar errores en un archivo
+var errorLogger *log.Logger
+
+func initErrorLogger() {
+	logFile, err := os.OpenFile("errores_monedas.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Println("Error al abrir archivo de log de monedas:", err)
+		return
+	}
+	errorLogger = log.New(logFile, "", log.Ldate|log.Ltime)
+	log.Println("Logger de errores de monedas inicializado correctamente")
+}
+
+func logError(mensaje string, err error) {
+	if errorLogger != nil {
+		errorLogger.Printf("[ERROR] %s: %v\n", mensaje, err)
+	} else {
+		log.Printf("[ERROR] %s: %v\n", mensaje, err)
+	}
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// Tiempo maximo que se guarda en cache una consulta de monedas
+const ttlCacheMonedas = 30 * time.Minute
+
+type Moneda struct {
+	Codigo       string  `json:"codigo"`
+	Nombre       string  `json:"nombre"`
+	Simbolo      *string `json:"simbolo"`
+	Activa       bool    `json:"activa"`
+	TipoDeMoneda string  `json:"tipoDeMoneda"`
+}
+
+func MonedaRoutes(api *gin.RouterGroup, db *sql.DB) {
+	initErrorLogger()
+
+	api.GET("/", cache.Respuestas(db, ttlCacheMonedas, "Moneda"), listarMonedas(db))
+	api.GET("/:codigo/cambios", cache.Respuestas(db, ttlCacheMonedas, "factura"), historialCambios(db))
+	api.GET("/:codigo/cambio", cache.Respuestas(db, ttlCacheMonedas, "factura"), cambioEnFecha(db))
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Lista las monedas registradas en Galac.
+Querys:
+
+activa: si/true para traer solo las monedas activas
+*/
+func listarMonedas(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		query := "SELECT Codigo, Nombre, Simbolo, Activa, TipoDeMoneda FROM dbo.Moneda"
+		activa := strings.ToLower(c.Query("activa"))
+		if activa == "si" || activa == "true" {
+			query += " WHERE Activa = 'S'"
+		}
+		query += " ORDER BY Codigo"
+
+		rows, err := db.Query(query)
+		if err != nil {
+			mensaje := "Error al consultar las monedas"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		monedas := []Moneda{}
+		for rows.Next() {
+			var m Moneda
+			var activa string
+			if err := rows.Scan(&m.Codigo, &m.Nombre, &m.Simbolo, &activa, &m.TipoDeMoneda); err != nil {
+				mensaje := "Error al leer datos de monedas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			m.Activa = activa == "S"
+			monedas = append(monedas, m)
+		}
+
+		if len(monedas) == 0 {
+			respuesta.SinResultados(c, "No se encontraron monedas")
+			return
+		}
+
+		respuesta.Exito(c, "Monedas encontradas", monedas, len(monedas))
+	}
+}
+
+// Verifica que la moneda exista en dbo.Moneda
+func existeMoneda(db *sql.DB, codigo string) (bool, error) {
+	var existe int
+	err := db.QueryRow("SELECT COUNT(*) FROM dbo.Moneda WHERE Codigo = @codigo", sql.Named("codigo", codigo)).Scan(&existe)
+	return existe > 0, err
+}
+
+/*
+Historial de tasas de una moneda (bolivares por unidad).
+Querys:
+
+desde, hasta: rango de fechas AAAA-MM-DD, opcionales
+*/
+func historialCambios(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		codigo := strings.ToUpper(strings.TrimSpace(c.Param("codigo")))
+
+		var v respuesta.Validador
+		desde, hasta := v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		existe, err := existeMoneda(db, codigo)
+		if err != nil {
+			mensaje := "Error al consultar la moneda"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		if !existe {
+			respuesta.Error(c, http.StatusNotFound, respuesta.NoEncontrado, "No existe la moneda "+codigo)
+			return
+		}
+
+		cambios, err := HistorialCambios(db, codigo, desde, hasta)
+		if err != nil {
+			mensaje := "Error al consultar el historial de tasas"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if len(cambios) == 0 {
+			respuesta.SinResultados(c, "No se encontraron tasas de "+codigo+" en el periodo")
+			return
+		}
+
+		respuesta.Exito(c, "Historial de tasas de "+codigo, cambios, len(cambios))
+	}
+}
+
+/*
+Tasa aplicable a una fecha: la del ultimo dia con tasa
+igual o anterior.
+Querys:
+
+fecha: AAAA-MM-DD, por defecto hoy
+*/
+func cambioEnFecha(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		codigo := strings.ToUpper(strings.TrimSpace(c.Param("codigo")))
+
+		var v respuesta.Validador
+		fecha := v.Fecha(c.Query("fecha"), "fecha")
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if fecha == nil {
+			hoy := time.Date(requestTime.Year(), requestTime.Month(), requestTime.Day(), 0, 0, 0, 0, time.UTC)
+			fecha = &hoy
+		}
+
+		existe, err := existeMoneda(db, codigo)
+		if err != nil {
+			mensaje := "Error al consultar la moneda"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		if !existe {
+			respuesta.Error(c, http.StatusNotFound, respuesta.NoEncontrado, "No existe la moneda "+codigo)
+			return
+		}
+
+		cambio, err := CambioEnFecha(db, codigo, *fecha)
+		if err != nil {
+			mensaje := "Error al consultar la tasa de cambio"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if cambio == nil {
+			respuesta.SinResultados(c, "No hay tasa de "+codigo+" registrada hasta el "+fecha.Format("2006-01-02"))
+			return
+		}
+
+		respuesta.Exito(c, "Tasa de "+codigo+" aplicable al "+fecha.Format("2006-01-02"), cambio, 1)
+	}
+}
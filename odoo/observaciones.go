@@ -0,0 +1,171 @@
+package odoo
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+	Lectura de los datos de Odoo que se colocan en el campo
+	Observaciones de las facturas de Galac.
+
+	Las facturas generadas desde las suscripciones de Odoo
+	llevan textos como:
+
+		ABRIL-Suscripcion: SUB75885
+
+	De ahi se extrae el mes facturado, el codigo de la
+	suscripcion y cualquier otro dato que venga en el texto.
+*/
+
+var numeroMes = map[string]int{
+	"ENERO":      1,
+	"FEBRERO":    2,
+	"MARZO":      3,
+	"ABRIL":      4,
+	"MAYO":       5,
+	"JUNIO":      6,
+	"JULIO":      7,
+	"AGOSTO":     8,
+	"SEPTIEMBRE": 9,
+	"SETIEMBRE":  9,
+	"OCTUBRE":    10,
+	"NOVIEMBRE":  11,
+	"DICIEMBRE":  12,
+}
+
+// Etiqueta que sigue al mes facturado en el formato de Odoo
+var etiquetaMes = map[string]bool{
+	"SUSCRIPCION": true,
+	"SUSCRIPCIÓN": true,
+}
+
+// Palabras que forman parte del formato y no se consideran datos
+var etiquetas = map[string]bool{
+	"SUSCRIPCION": true,
+	"SUSCRIPCIÓN": true,
+	"SUB":         true,
+}
+
+/*
+Caracteres que pueden formar parte de un codigo SUB. Cualquier
+otro caracter lo delimita, tanto al leer las Observaciones
+como en el filtro LIKE de SQL Server (ver PatronLikeSuscripcion),
+asi ambos reconocen los mismos codigos: en "SUB75885A" no hay
+ningun codigo, en "SUB75885-A" esta SUB75885.
+*/
+const caracteresCodigo = "A-Z0-9"
+
+var (
+	patronSuscripcion = regexp.MustCompile(`^SUB[0-9]+$`)
+	patronAnio        = regexp.MustCompile(`^(19|20)\d{2}$`)
+	separadores       = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	limitesCodigo     = regexp.MustCompile(`[^` + caracteresCodigo + `]+`)
+)
+
+// Datos extraidos de las Observaciones de una factura
+type Observaciones struct {
+	Suscripcion   *string  // Primer codigo SUB encontrado
+	Suscripciones []string // Todos los codigos SUB encontrados
+	MesFacturado  *int     // Numero del mes (1 a 12)
+	AnioFacturado *int
+	Otros         []string // Palabras que no forman parte del formato
+}
+
+/*
+Extrae los datos de Odoo del texto de Observaciones.
+Si el texto no tiene el formato esperado, los campos
+quedan en nil y todas las palabras van a Otros.
+
+El mes (y el año) solo se toman si van justo antes de la
+etiqueta "Suscripcion": en "ABRIL-Suscripcion: SUB75885" el
+mes es abril, pero en "Pago de MAYO, SUB75885 anulada" no
+hay mes facturado.
+*/
+func ParsearObservaciones(texto string) Observaciones {
+	var obs Observaciones
+	texto = strings.ToUpper(texto)
+
+	for _, palabra := range limitesCodigo.Split(texto, -1) {
+		if patronSuscripcion.MatchString(palabra) {
+			obs.Suscripciones = append(obs.Suscripciones, palabra)
+		}
+	}
+	if len(obs.Suscripciones) > 0 {
+		codigo := obs.Suscripciones[0]
+		obs.Suscripcion = &codigo
+	}
+
+	var palabras []string
+	for _, palabra := range separadores.Split(texto, -1) {
+		if palabra != "" {
+			palabras = append(palabras, palabra)
+		}
+	}
+
+	// Posiciones del mes y del año facturados, -1 si no vienen
+	posMes, posAnio := -1, -1
+	for i, palabra := range palabras {
+		if numeroMes[palabra] == 0 {
+			continue
+		}
+		siguiente := i + 1
+		if siguiente < len(palabras) && patronAnio.MatchString(palabras[siguiente]) {
+			siguiente++
+		}
+		if siguiente < len(palabras) && etiquetaMes[palabras[siguiente]] {
+			posMes = i
+			if siguiente == i+2 {
+				posAnio = i + 1
+			}
+			break
+		}
+	}
+	if posMes >= 0 {
+		mes := numeroMes[palabras[posMes]]
+		obs.MesFacturado = &mes
+
+		// El año tambien puede venir antes del mes: "2025 ABRIL-Suscripcion"
+		if posAnio < 0 && posMes > 0 && patronAnio.MatchString(palabras[posMes-1]) {
+			posAnio = posMes - 1
+		}
+	}
+	if posAnio >= 0 {
+		anio, _ := strconv.Atoi(palabras[posAnio])
+		obs.AnioFacturado = &anio
+	}
+
+	for i, palabra := range palabras {
+		if i == posMes || i == posAnio || etiquetas[palabra] || patronSuscripcion.MatchString(palabra) {
+			continue
+		}
+		obs.Otros = append(obs.Otros, palabra)
+	}
+
+	return obs
+}
+
+/*
+Normaliza un codigo de suscripcion recibido por la API.
+Acepta "SUB75885", "sub75885" o el codigo de cliente
+"NSUB75885". Retorna false si no es un codigo valido.
+*/
+func NormalizarSuscripcion(codigo string) (string, bool) {
+	codigo = strings.ToUpper(strings.TrimSpace(codigo))
+	codigo = strings.TrimPrefix(codigo, "N")
+	if !patronSuscripcion.MatchString(codigo) {
+		return "", false
+	}
+	return codigo, true
+}
+
+/*
+Patron LIKE de SQL Server que encuentra el codigo SUB (ya
+normalizado) dentro de Observaciones con la misma regla de
+ParsearObservaciones. El texto debe venir rodeado de espacios
+para que el codigo pueda estar al inicio o al final.
+*/
+func PatronLikeSuscripcion(codigo string) string {
+	return "%[^" + caracteresCodigo + "]" + codigo + "[^" + caracteresCodigo + "]%"
+}
@@ -0,0 +1,118 @@
+package odoo
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func entero(n int) *int { return &n }
+
+func TestParsearObservaciones(t *testing.T) {
+	casos := []struct {
+		nombre        string
+		texto         string
+		suscripciones []string
+		mes           *int
+		anio          *int
+		otros         []string
+	}{
+		{"formato de odoo", "ABRIL-Suscripcion: SUB75885", []string{"SUB75885"}, entero(4), nil, nil},
+		{"minusculas y acento", "abril - suscripción: sub75885", []string{"SUB75885"}, entero(4), nil, nil},
+		{"con año despues del mes", "MARZO 2025-Suscripcion: SUB1", []string{"SUB1"}, entero(3), entero(2025), nil},
+		{"con año antes del mes", "2024 DICIEMBRE-Suscripcion: SUB1", []string{"SUB1"}, entero(12), entero(2024), nil},
+		{"mes pegado al codigo", "SETIEMBRE SUB9", []string{"SUB9"}, nil, nil, []string{"SETIEMBRE"}},
+		{"varios codigos", "ENERO-Suscripcion: SUB1, SUB2", []string{"SUB1", "SUB2"}, entero(1), nil, nil},
+		{"mes fuera del formato", "Pago de MAYO, SUB75885 anulada", []string{"SUB75885"}, nil, nil, []string{"PAGO", "DE", "MAYO", "ANULADA"}},
+		{"codigo con letra pegada", "ABRIL-Suscripcion: SUB75885A", nil, entero(4), nil, []string{"SUB75885A"}},
+		{"codigo con sufijo separado", "SUB75885-A", []string{"SUB75885"}, nil, nil, []string{"A"}},
+		{"sin formato", "Venta de contado", nil, nil, nil, []string{"VENTA", "DE", "CONTADO"}},
+		{"vacio", "", nil, nil, nil, nil},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			obs := ParsearObservaciones(caso.texto)
+
+			if !reflect.DeepEqual(obs.Suscripciones, caso.suscripciones) {
+				t.Errorf("Suscripciones = %v, se esperaba %v", obs.Suscripciones, caso.suscripciones)
+			}
+			if len(caso.suscripciones) > 0 && (obs.Suscripcion == nil || *obs.Suscripcion != caso.suscripciones[0]) {
+				t.Errorf("Suscripcion = %v, se esperaba %s", obs.Suscripcion, caso.suscripciones[0])
+			}
+			if !reflect.DeepEqual(obs.MesFacturado, caso.mes) {
+				t.Errorf("MesFacturado = %v, se esperaba %v", valor(obs.MesFacturado), valor(caso.mes))
+			}
+			if !reflect.DeepEqual(obs.AnioFacturado, caso.anio) {
+				t.Errorf("AnioFacturado = %v, se esperaba %v", valor(obs.AnioFacturado), valor(caso.anio))
+			}
+			if !reflect.DeepEqual(obs.Otros, caso.otros) {
+				t.Errorf("Otros = %q, se esperaba %q", obs.Otros, caso.otros)
+			}
+		})
+	}
+}
+
+func valor(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+func TestNormalizarSuscripcion(t *testing.T) {
+	casos := []struct {
+		codigo   string
+		esperado string
+		valido   bool
+	}{
+		{"SUB75885", "SUB75885", true},
+		{" sub75885 ", "SUB75885", true},
+		{"NSUB75885", "SUB75885", true},
+		{"SUB", "", false},
+		{"SUB75885A", "", false},
+		{"75885", "", false},
+		{"", "", false},
+	}
+
+	for _, caso := range casos {
+		codigo, ok := NormalizarSuscripcion(caso.codigo)
+		if codigo != caso.esperado || ok != caso.valido {
+			t.Errorf("NormalizarSuscripcion(%q) = %q, %v; se esperaba %q, %v", caso.codigo, codigo, ok, caso.esperado, caso.valido)
+		}
+	}
+}
+
+/*
+El patron LIKE y ParsearObservaciones deben reconocer los
+mismos codigos. El LIKE se traduce a una expresion regular
+equivalente para compararlos sin base de datos.
+*/
+func TestPatronLikeCoincideConParser(t *testing.T) {
+	textos := []string{
+		"ABRIL-Suscripcion: SUB75885",
+		"SUB75885",
+		"SUB75885A",
+		"SUB75885-A",
+		"XSUB75885",
+		"SUB758851",
+		"SUB7588",
+		"Suscripciones SUB1, SUB75885.",
+	}
+
+	like := PatronLikeSuscripcion("SUB75885")
+	expresion := "^" + strings.ReplaceAll(like, "%", ".*") + "$"
+	patron := regexp.MustCompile(expresion)
+
+	for _, texto := range textos {
+		enSQL := patron.MatchString(" " + strings.ToUpper(texto) + " ")
+		enParser := false
+		for _, codigo := range ParsearObservaciones(texto).Suscripciones {
+			enParser = enParser || codigo == "SUB75885"
+		}
+		if enSQL != enParser {
+			t.Errorf("%q: LIKE = %v, ParsearObservaciones = %v", texto, enSQL, enParser)
+		}
+	}
+}
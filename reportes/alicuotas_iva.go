@@ -0,0 +1,335 @@
+package reportes
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/exportar"
+	"github.com/desarrolladoresnet/api_galac_bd/facturas"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Desglose del IVA de las ventas por alicuota.
+
+	Cada factura tiene tres "casillas" de IVA (Alicuota1..3),
+	pero la casilla no siempre corresponde a la misma tasa.
+	Para cuadrar la declaracion mensual se agrupa por la tasa
+	real, clasificada segun la tabla dbo.alicuotaIVA vigente
+	en la fecha de cada documento:
+
+	  - GENERAL:   MontoAlicuotaGeneral (16%)
+	  - REDUCIDA:  MontoAlicuota2 (8%)
+	  - ADICIONAL: MontoAlicuota3 (31%, bienes suntuarios)
+	  - OTRA:      porcentaje que no coincide con la tabla
+
+	Se excluyen los borradores y los documentos anulados
+	(Cancelada = 'S' o con motivo de anulacion), que en el Libro de Ventas aparecen con
+	montos en cero, asi que ambos reportes suman lo mismo. Las
+	notas de credito restan.
+
+	Con el query moneda se agregan los totales (exento, base
+	imponible e IVA) convertidos con la tasa de cada documento.
+*/
+
+const (
+	AlicuotaGeneral   = "GENERAL"
+	AlicuotaReducida  = "REDUCIDA"
+	AlicuotaAdicional = "ADICIONAL"
+	AlicuotaOtra      = "OTRA"
+)
+
+// Tolerancia al comparar porcentajes y montos
+const toleranciaIVA = 0.005
+
+// Registro de dbo.alicuotaIVA
+type VigenciaAlicuotaIVA struct {
+	FechaDeInicioDeVigencia time.Time `json:"fechaDeInicioDeVigencia"`
+	MontoAlicuotaGeneral    *float64  `json:"montoAlicuotaGeneral"`
+	MontoAlicuota2          *float64  `json:"montoAlicuota2"`
+	MontoAlicuota3          *float64  `json:"montoAlicuota3"`
+}
+
+type TotalAlicuotaIVA struct {
+	Tipo               string  `json:"tipo"`
+	Porcentaje         float64 `json:"porcentaje"`
+	CantidadDocumentos int     `json:"cantidadDocumentos"`
+	BaseImponible      float64 `json:"baseImponible"`
+	IVA                float64 `json:"iva"`
+	IVACalculado       float64 `json:"ivaCalculado"` // BaseImponible * Porcentaje / 100
+	Diferencia         float64 `json:"diferencia"`   // IVA - IVACalculado
+}
+
+type ReporteAlicuotasIVA struct {
+	Alicuotas          []TotalAlicuotaIVA    `json:"alicuotas"`
+	VentasExentas      float64               `json:"ventasExentas"`
+	TotalBaseImponible float64               `json:"totalBaseImponible"`
+	TotalIVA           float64               `json:"totalIva"`
+	Vigencias          []VigenciaAlicuotaIVA `json:"vigencias"`
+	Conversion         *ConversionReporte    `json:"conversion,omitempty"`
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// Redondea a centimos
+func redondear(monto float64) float64 {
+	return math.Round(monto*100) / 100
+}
+
+func mismoPorcentaje(porcentaje float64, alicuota *float64) bool {
+	return alicuota != nil && math.Abs(porcentaje-*alicuota) < toleranciaIVA
+}
+
+/*
+Clasifica un porcentaje segun la alicuota vigente en la fecha.
+Las vigencias deben venir ordenadas por fecha de inicio.
+*/
+func tipoAlicuota(vigencias []VigenciaAlicuotaIVA, fecha time.Time, porcentaje float64) string {
+	var vigente *VigenciaAlicuotaIVA
+	for i := range vigencias {
+		if vigencias[i].FechaDeInicioDeVigencia.After(fecha) {
+			break
+		}
+		vigente = &vigencias[i]
+	}
+	if vigente == nil {
+		return AlicuotaOtra
+	}
+
+	switch {
+	case mismoPorcentaje(porcentaje, vigente.MontoAlicuotaGeneral):
+		return AlicuotaGeneral
+	case mismoPorcentaje(porcentaje, vigente.MontoAlicuota2):
+		return AlicuotaReducida
+	case mismoPorcentaje(porcentaje, vigente.MontoAlicuota3):
+		return AlicuotaAdicional
+	}
+	return AlicuotaOtra
+}
+
+// Tabla del desglose para exportar, con la fila de totales al final
+func (r ReporteAlicuotasIVA) tabla() exportar.Tabla {
+	t := exportar.Tabla{Columnas: []string{
+		"Alícuota", "Porcentaje", "Cantidad de Documentos", "Base Imponible",
+		"IVA", "IVA Calculado", "Diferencia",
+	}}
+	for _, a := range r.Alicuotas {
+		t.Agregar(a.Tipo, a.Porcentaje, a.CantidadDocumentos, a.BaseImponible,
+			a.IVA, a.IVACalculado, a.Diferencia)
+	}
+	t.Agregar("EXENTO", nil, nil, r.VentasExentas, nil, nil, nil)
+	t.Agregar("TOTALES", nil, nil, r.TotalBaseImponible, r.TotalIVA, nil, nil)
+	return t
+}
+
+/*
+Desglose del IVA por alicuota real.
+Querys:
+
+mes y anio, o desde y hasta (AAAA-MM-DD): obligatorio
+formato: json (por defecto), csv o xlsx
+moneda, fechaCambio: agrega los totales convertidos (solo en json)
+*/
+func reporteAlicuotasIVA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando desglose de IVA por alícuota", nil)
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		desde, hasta := leerPeriodo(c, &v)
+		if v.Valido() && (desde == nil || hasta == nil) {
+			v.Agregar("periodo", "Indique mes y anio, o desde y hasta")
+		}
+		formato, okFormato := exportar.Formato(c)
+		if !okFormato {
+			v.Agregar("formato", "Formato inválido. Use: json, csv o xlsx")
+		}
+		conversion := monedas.LeerConversion(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if !prepararConversion(c, db, conversion, requestID) {
+			return
+		}
+
+		// ----- Historial de alicuotas ----- //
+		vigencias := []VigenciaAlicuotaIVA{}
+		rows, err := db.Query(`
+			SELECT FechaDeInicioDeVigencia, MontoAlicuotaGeneral, MontoAlicuota2, MontoAlicuota3
+			FROM dbo.alicuotaIVA
+			ORDER BY FechaDeInicioDeVigencia
+		`)
+		if err != nil {
+			mensaje := "Error al consultar el historial de alícuotas de IVA"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		for rows.Next() {
+			var a VigenciaAlicuotaIVA
+			if err := rows.Scan(&a.FechaDeInicioDeVigencia, &a.MontoAlicuotaGeneral, &a.MontoAlicuota2, &a.MontoAlicuota3); err != nil {
+				rows.Close()
+				mensaje := "Error al leer el historial de alícuotas de IVA"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			vigencias = append(vigencias, a)
+		}
+		rows.Close()
+
+		// ----- Facturas del periodo ----- //
+		query := `
+			SELECT
+				Fecha, TipoDeDocumento, TotalMontoExento,
+				PorcentajeAlicuota1, MontoGravableAlicuota1, MontoIVAAlicuota1,
+				PorcentajeAlicuota2, MontoGravableAlicuota2, MontoIVAAlicuota2,
+				PorcentajeAlicuota3, MontoGravableAlicuota3, MontoIVAAlicuota3,
+				` + monedas.ColumnasDocumento("") + `
+			FROM dbo.factura
+			WHERE Fecha >= @desde AND Fecha < @hasta
+			  AND StatusFactura <> @borrador
+			  AND NOT ` + facturas.CondicionAnulada("") + `
+		`
+
+		rows, err = db.Query(query,
+			sql.Named("desde", *desde),
+			sql.Named("hasta", *hasta),
+			sql.Named("borrador", facturas.StatusBorrador),
+		)
+		if err != nil {
+			mensaje := "Error al consultar las facturas del periodo"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		reporte := ReporteAlicuotasIVA{Vigencias: vigencias, Conversion: nuevaConversionReporte(conversion)}
+		grupos := map[string]*TotalAlicuotaIVA{}
+		documentos := 0
+
+		for rows.Next() {
+			var fecha time.Time
+			var tipoDocumento string
+			var exento *float64
+			var porcentajes, bases, ivas [3]*float64
+			var doc monedas.Documento
+			err := rows.Scan(append([]interface{}{
+				&fecha, &tipoDocumento, &exento,
+				&porcentajes[0], &bases[0], &ivas[0],
+				&porcentajes[1], &bases[1], &ivas[1],
+				&porcentajes[2], &bases[2], &ivas[2],
+			}, doc.Destinos()...)...)
+			if err != nil {
+				mensaje := "Error al leer datos de facturas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			documentos++
+
+			signo := 1.0
+			if tipoDocumento == facturas.TipoDocumentoNotaCredito {
+				signo = -1
+			}
+			sumar(&reporte.VentasExentas, conSigno(exento, signo))
+
+			// Se convierte lo mismo que se suma en las alicuotas
+			totalBase, totalIVA := 0.0, 0.0
+			for i := 0; i < 3; i++ {
+				sumar(&totalBase, bases[i])
+				sumar(&totalIVA, ivas[i])
+			}
+			doc.Fecha = fecha
+			err = reporte.Conversion.acumular(doc, map[string]*float64{
+				"ventasExentas":      conSigno(exento, signo),
+				"totalBaseImponible": conSigno(&totalBase, signo),
+				"totalIva":           conSigno(&totalIVA, signo),
+			})
+			if err != nil {
+				mensaje := "Error al convertir los montos de las facturas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+
+			// Un documento cuenta una sola vez por alicuota aunque use dos casillas con la misma tasa
+			contados := map[string]bool{}
+			for i := 0; i < 3; i++ {
+				if (bases[i] == nil || *bases[i] == 0) && (ivas[i] == nil || *ivas[i] == 0) {
+					continue
+				}
+				porcentaje := 0.0
+				if porcentajes[i] != nil {
+					porcentaje = *porcentajes[i]
+				}
+
+				tipo := tipoAlicuota(vigencias, fecha, porcentaje)
+				clave := tipo + "|" + strconv.FormatFloat(porcentaje, 'f', 2, 64)
+				g, ok := grupos[clave]
+				if !ok {
+					g = &TotalAlicuotaIVA{Tipo: tipo, Porcentaje: porcentaje}
+					grupos[clave] = g
+				}
+				if !contados[clave] {
+					contados[clave] = true
+					g.CantidadDocumentos++
+				}
+				sumar(&g.BaseImponible, conSigno(bases[i], signo))
+				sumar(&g.IVA, conSigno(ivas[i], signo))
+			}
+		}
+
+		// ----- Totales ----- //
+		reporte.Alicuotas = make([]TotalAlicuotaIVA, 0, len(grupos))
+		for _, g := range grupos {
+			g.BaseImponible = redondear(g.BaseImponible)
+			g.IVA = redondear(g.IVA)
+			g.IVACalculado = redondear(g.BaseImponible * g.Porcentaje / 100)
+			g.Diferencia = redondear(g.IVA - g.IVACalculado)
+			reporte.TotalBaseImponible += g.BaseImponible
+			reporte.TotalIVA += g.IVA
+			reporte.Alicuotas = append(reporte.Alicuotas, *g)
+		}
+		reporte.VentasExentas = redondear(reporte.VentasExentas)
+		reporte.TotalBaseImponible = redondear(reporte.TotalBaseImponible)
+		reporte.TotalIVA = redondear(reporte.TotalIVA)
+		reporte.Conversion.redondear()
+
+		orden := map[string]int{AlicuotaGeneral: 0, AlicuotaReducida: 1, AlicuotaAdicional: 2, AlicuotaOtra: 3}
+		sort.Slice(reporte.Alicuotas, func(i, j int) bool {
+			a, b := reporte.Alicuotas[i], reporte.Alicuotas[j]
+			if orden[a.Tipo] != orden[b.Tipo] {
+				return orden[a.Tipo] < orden[b.Tipo]
+			}
+			return a.Porcentaje < b.Porcentaje
+		})
+
+		logError(requestID+" - Desglose de IVA generado con "+strconv.Itoa(documentos)+" documentos", nil)
+
+		// ----- Respuesta ----- //
+		if formato == exportar.FormatoJSON {
+			respuesta.Exito(c, "Desglose de IVA por alícuota generado", reporte, len(reporte.Alicuotas))
+			return
+		}
+
+		nombre := "iva_alicuotas_" + desde.Format("20060102") + "_" + hasta.AddDate(0, 0, -1).Format("20060102")
+		if err := exportar.Enviar(c, formato, nombre, "IVA por alícuota", reporte.tabla()); err != nil {
+			mensaje := "Error al exportar el desglose de IVA"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.Error(c, http.StatusInternalServerError, respuesta.ErrorInterno, mensaje)
+		}
+	}
+}
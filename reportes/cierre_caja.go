@@ -0,0 +1,321 @@
+package reportes
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/exportar"
+	"github.com/desarrolladoresnet/api_galac_bd/facturas"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Cierre de caja diario a partir de los cobros directos de
+	las facturas (dbo.renglonCobroDeFactura).
+
+	Los cobros se totalizan por compania y caja (ConsecutivoCaja
+	de la factura; las cajas de cada compania son distintas
+	aunque tengan el mismo numero), forma de cobro, banco, punto de venta y moneda.
+	Los montos de las notas de credito restan. El vuelto
+	entregado (VueltoDelCobroDirecto) se resta del total en
+	moneda local de cada caja.
+
+	Con el query moneda cada caja y el total traen los montos
+	convertidos: los cobros en dolares con su propia tasa
+	(CambioAMonedaLocal) y los demas con la de su factura.
+*/
+
+type TotalCobroCaja struct {
+	CodigoFormaDelCobro string  `json:"codigoFormaDelCobro"`
+	CodigoBanco         *int    `json:"codigoBanco"`
+	CodigoPuntoDeVenta  *int    `json:"codigoPuntoDeVenta"`
+	CodigoMoneda        string  `json:"codigoMoneda"`
+	CantidadCobros      int     `json:"cantidadCobros"`
+	Monto               float64 `json:"monto"`
+	MontoMonedaLocal    float64 `json:"montoMonedaLocal"`
+}
+
+// Montos de una caja (o del total) en la moneda solicitada
+type CierreConvertido struct {
+	Moneda        string  `json:"moneda"`
+	Monto         float64 `json:"monto"`
+	Vuelto        float64 `json:"vuelto"`
+	Neto          float64 `json:"neto"`
+	CobrosSinTasa int     `json:"cobrosSinTasa"` // no se suman en Monto
+}
+
+type CierreDeCaja struct {
+	ConsecutivoCompania int               `json:"consecutivoCompania"`
+	ConsecutivoCaja     int               `json:"consecutivoCaja"` // 0 si la factura no tiene caja
+	CantidadFacturas    int               `json:"cantidadFacturas"`
+	Cobros              []TotalCobroCaja  `json:"cobros"`
+	MontoMonedaLocal    float64           `json:"montoMonedaLocal"`
+	Vuelto              float64           `json:"vuelto"`
+	NetoMonedaLocal     float64           `json:"netoMonedaLocal"`
+	Conversion          *CierreConvertido `json:"conversion,omitempty"`
+}
+
+type ReporteCierreCaja struct {
+	Fecha            string            `json:"fecha"`
+	Cajas            []CierreDeCaja    `json:"cajas"`
+	MontoMonedaLocal float64           `json:"montoMonedaLocal"`
+	Vuelto           float64           `json:"vuelto"`
+	NetoMonedaLocal  float64           `json:"netoMonedaLocal"`
+	Conversion       *CierreConvertido `json:"conversion,omitempty"`
+}
+
+// Condicion de los documentos del cierre, con alias f
+const condicionCierreCaja = `
+	f.Fecha >= @desde AND f.Fecha < @hasta
+	AND f.StatusFactura <> @borrador
+	AND ISNULL(f.Cancelada, 'N') <> 'S'`
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// Tabla del cierre para exportar, una fila por total de cobro
+func (r ReporteCierreCaja) tabla() exportar.Tabla {
+	t := exportar.Tabla{Columnas: []string{
+		"Compañía", "Caja", "Forma de Cobro", "Banco", "Punto de Venta", "Moneda",
+		"Cantidad de Cobros", "Monto", "Monto Moneda Local",
+	}}
+	for _, caja := range r.Cajas {
+		for _, g := range caja.Cobros {
+			t.Agregar(caja.ConsecutivoCompania, caja.ConsecutivoCaja, g.CodigoFormaDelCobro, g.CodigoBanco, g.CodigoPuntoDeVenta, g.CodigoMoneda,
+				g.CantidadCobros, g.Monto, g.MontoMonedaLocal)
+		}
+		t.Agregar(caja.ConsecutivoCompania, caja.ConsecutivoCaja, "VUELTO", nil, nil, nil, nil, nil, -caja.Vuelto)
+		t.Agregar(caja.ConsecutivoCompania, caja.ConsecutivoCaja, "NETO", nil, nil, nil, nil, nil, caja.NetoMonedaLocal)
+	}
+	t.Agregar(nil, nil, "TOTALES", nil, nil, nil, nil, nil, r.NetoMonedaLocal)
+	return t
+}
+
+/*
+Cierre de caja de un dia.
+Querys:
+
+fecha: dia del cierre (AAAA-MM-DD), por defecto hoy
+compania: solo esa compania (ConsecutivoCompania)
+caja: solo esa caja (ConsecutivoCaja)
+formato: json (por defecto), csv o xlsx
+moneda, fechaCambio: agrega los montos convertidos (solo en json)
+*/
+func reporteCierreCaja(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		fecha := v.Fecha(c.Query("fecha"), "fecha")
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		caja, conCaja := v.Entero(c.Query("caja"), "caja", 0, math.MaxInt32)
+		formato, okFormato := exportar.Formato(c)
+		if !okFormato {
+			v.Agregar("formato", "Formato inválido. Use: json, csv o xlsx")
+		}
+		conversion := monedas.LeerConversion(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if !prepararConversion(c, db, conversion, requestID) {
+			return
+		}
+		if fecha == nil {
+			hoy := time.Date(requestTime.Year(), requestTime.Month(), requestTime.Day(), 0, 0, 0, 0, time.UTC)
+			fecha = &hoy
+		}
+
+		filtro := condicionCierreCaja
+		params := []interface{}{
+			sql.Named("desde", *fecha),
+			sql.Named("hasta", fecha.AddDate(0, 0, 1)),
+			sql.Named("borrador", facturas.StatusBorrador),
+			sql.Named("notaCredito", facturas.TipoDocumentoNotaCredito),
+		}
+		filtro, params = respuesta.FiltroCompania(filtro, "f.ConsecutivoCompania", params, compania, conCompania)
+		if conCaja {
+			filtro += " AND ISNULL(f.ConsecutivoCaja, 0) = @caja"
+			params = append(params, sql.Named("caja", caja))
+		}
+
+		// Columnas con los montos convertidos, vacias si no se pidio conversion
+		cobrosConvertidos, vueltoConvertido := "", ""
+		if conversion != nil {
+			factorCobro, paramsFactor := conversion.ExpresionFactorCobro("r.", "f.")
+			factorFactura, _ := conversion.ExpresionFactor("f.")
+			cobrosConvertidos = `,
+			       ISNULL(SUM(s.Signo * ISNULL(r.Monto, 0) * ISNULL(NULLIF(r.CambioAMonedaLocal, 0), 1) * ` + factorCobro + `), 0),
+			       SUM(CASE WHEN ` + factorCobro + ` IS NULL THEN 1 ELSE 0 END)`
+			vueltoConvertido = `,
+			       ISNULL(SUM(ISNULL(f.VueltoDelCobroDirecto, 0) * ` + factorFactura + `), 0)`
+			params = append(params, paramsFactor...)
+		}
+
+		reporte := ReporteCierreCaja{Fecha: fecha.Format("2006-01-02"), Cajas: []CierreDeCaja{}}
+		if conversion != nil {
+			reporte.Conversion = &CierreConvertido{Moneda: conversion.Moneda}
+		}
+		posicion := map[[2]int]int{} // compania y caja => posicion en Cajas
+		cierre := func(compania, caja int) *CierreDeCaja {
+			i, ok := posicion[[2]int{compania, caja}]
+			if !ok {
+				i = len(reporte.Cajas)
+				posicion[[2]int{compania, caja}] = i
+				cj := CierreDeCaja{ConsecutivoCompania: compania, ConsecutivoCaja: caja, Cobros: []TotalCobroCaja{}}
+				if conversion != nil {
+					cj.Conversion = &CierreConvertido{Moneda: conversion.Moneda}
+				}
+				reporte.Cajas = append(reporte.Cajas, cj)
+			}
+			return &reporte.Cajas[i]
+		}
+
+		// ----- Cobros por caja ----- //
+		rows, err := db.Query(`
+			SELECT f.ConsecutivoCompania, ISNULL(f.ConsecutivoCaja, 0), ISNULL(r.CodigoFormaDelCobro, ''), r.CodigoBanco,
+			       r.CodigoPuntoDeVenta, ISNULL(r.CodigoMoneda, ''), COUNT(*),
+			       SUM(s.Signo * ISNULL(r.Monto, 0)),
+			       SUM(s.Signo * ISNULL(r.Monto, 0) * ISNULL(NULLIF(r.CambioAMonedaLocal, 0), 1))`+cobrosConvertidos+`
+			FROM dbo.renglonCobroDeFactura r
+			INNER JOIN dbo.factura f
+			        ON f.ConsecutivoCompania = r.ConsecutivoCompania
+			       AND f.Numero = r.NumeroFactura
+			       AND f.TipoDeDocumento = r.TipoDeDocumento
+			CROSS APPLY (SELECT CASE WHEN f.TipoDeDocumento = @notaCredito THEN -1 ELSE 1 END AS Signo) s
+			WHERE `+filtro+`
+			GROUP BY f.ConsecutivoCompania, ISNULL(f.ConsecutivoCaja, 0), ISNULL(r.CodigoFormaDelCobro, ''),
+			         r.CodigoBanco, r.CodigoPuntoDeVenta, ISNULL(r.CodigoMoneda, '')
+			ORDER BY 1, 2, 3, 6, 4, 5
+		`, params...)
+		if err != nil {
+			mensaje := "Error al consultar los cobros del día"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var numeroCompania, numeroCaja, sinTasa int
+			var g TotalCobroCaja
+			var convertido float64
+			destinos := []interface{}{&numeroCompania, &numeroCaja, &g.CodigoFormaDelCobro, &g.CodigoBanco, &g.CodigoPuntoDeVenta,
+				&g.CodigoMoneda, &g.CantidadCobros, &g.Monto, &g.MontoMonedaLocal}
+			if conversion != nil {
+				destinos = append(destinos, &convertido, &sinTasa)
+			}
+			if err := rows.Scan(destinos...); err != nil {
+				mensaje := "Error al leer los cobros del día"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			cj := cierre(numeroCompania, numeroCaja)
+			cj.Cobros = append(cj.Cobros, g)
+			cj.MontoMonedaLocal += g.MontoMonedaLocal
+			if cj.Conversion != nil {
+				cj.Conversion.Monto += convertido
+				cj.Conversion.CobrosSinTasa += sinTasa
+			}
+		}
+		if err := rows.Err(); err != nil {
+			mensaje := "Error al leer los cobros del día"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		rows.Close()
+
+		// ----- Facturas y vuelto por caja ----- //
+		rows, err = db.Query(`
+			SELECT f.ConsecutivoCompania, ISNULL(f.ConsecutivoCaja, 0), COUNT(*),
+			       SUM(ISNULL(f.VueltoDelCobroDirecto, 0))`+vueltoConvertido+`
+			FROM dbo.factura f
+			WHERE `+filtro+`
+			  AND EXISTS (
+				SELECT 1 FROM dbo.renglonCobroDeFactura r
+				WHERE r.ConsecutivoCompania = f.ConsecutivoCompania
+				  AND r.NumeroFactura = f.Numero
+				  AND r.TipoDeDocumento = f.TipoDeDocumento)
+			GROUP BY f.ConsecutivoCompania, ISNULL(f.ConsecutivoCaja, 0)
+		`, params...)
+		if err != nil {
+			mensaje := "Error al consultar las facturas del día"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var numeroCompania, numeroCaja, cantidad int
+			var vuelto, convertido float64
+			destinos := []interface{}{&numeroCompania, &numeroCaja, &cantidad, &vuelto}
+			if conversion != nil {
+				destinos = append(destinos, &convertido)
+			}
+			if err := rows.Scan(destinos...); err != nil {
+				mensaje := "Error al leer las facturas del día"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			cj := cierre(numeroCompania, numeroCaja)
+			cj.CantidadFacturas = cantidad
+			cj.Vuelto = vuelto
+			if cj.Conversion != nil {
+				cj.Conversion.Vuelto = convertido
+			}
+		}
+
+		for i := range reporte.Cajas {
+			cj := &reporte.Cajas[i]
+			cj.NetoMonedaLocal = cj.MontoMonedaLocal - cj.Vuelto
+			reporte.MontoMonedaLocal += cj.MontoMonedaLocal
+			reporte.Vuelto += cj.Vuelto
+			reporte.NetoMonedaLocal += cj.NetoMonedaLocal
+
+			if cv := cj.Conversion; cv != nil {
+				cv.Monto = redondear(cv.Monto)
+				cv.Vuelto = redondear(cv.Vuelto)
+				cv.Neto = redondear(cv.Monto - cv.Vuelto)
+				reporte.Conversion.Monto += cv.Monto
+				reporte.Conversion.Vuelto += cv.Vuelto
+				reporte.Conversion.Neto += cv.Neto
+				reporte.Conversion.CobrosSinTasa += cv.CobrosSinTasa
+			}
+		}
+		if cv := reporte.Conversion; cv != nil {
+			cv.Monto = redondear(cv.Monto)
+			cv.Vuelto = redondear(cv.Vuelto)
+			cv.Neto = redondear(cv.Neto)
+		}
+
+		logError(requestID+" - Cierre de caja del "+reporte.Fecha+" con "+strconv.Itoa(len(reporte.Cajas))+" cajas", nil)
+
+		// ----- Respuesta ----- //
+		if formato == exportar.FormatoJSON {
+			if len(reporte.Cajas) == 0 {
+				respuesta.SinResultados(c, "No hay cobros registrados el "+reporte.Fecha)
+				return
+			}
+			respuesta.Exito(c, "Cierre de caja del "+reporte.Fecha, reporte, len(reporte.Cajas))
+			return
+		}
+
+		if err := exportar.Enviar(c, formato, "cierre_caja_"+fecha.Format("20060102"), "Cierre de caja", reporte.tabla()); err != nil {
+			mensaje := "Error al exportar el cierre de caja"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.Error(c, http.StatusInternalServerError, respuesta.ErrorInterno, mensaje)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package reportes
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/exportar"
+	"github.com/desarrolladoresnet/api_galac_bd/facturas"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Reporte del IGTF (Impuesto a las Grandes Transacciones
+	Financieras) cobrado en las facturas.
+
+	Galac guarda en cada factura la base imponible del IGTF,
+	el impuesto en moneda local (IGTFML), en moneda extranjera
+	(IGTFME) y la alicuota aplicada. El reporte los totaliza
+	por mes, moneda de cobro y forma de cobro para la
+	declaracion mensual, y opcionalmente lista las facturas.
+
+	Igual que en el Libro de Ventas, las notas de credito
+	restan y se presentan en negativo.
+
+	Con el query moneda se agregan los totales de la base y
+	del IGTF en moneda local convertidos; IGTFME ya esta en
+	la moneda extranjera y no se convierte.
+*/
+
+type ResumenIGTF struct {
+	Anio                int     `json:"anio"`
+	Mes                 int     `json:"mes"`
+	CodigoMonedaDeCobro string  `json:"codigoMonedaDeCobro"`
+	FormaDeCobro        string  `json:"formaDeCobro"`
+	CantidadFacturas    int     `json:"cantidadFacturas"`
+	BaseImponibleIGTF   float64 `json:"baseImponibleIgtf"`
+	IGTFML              float64 `json:"igtfMl"`
+	IGTFME              float64 `json:"igtfMe"`
+}
+
+type FacturaIGTF struct {
+	Numero              string    `json:"numero"`
+	Fecha               time.Time `json:"fecha"`
+	TipoDeDocumento     string    `json:"tipoDeDocumento"`
+	NumeroControl       *string   `json:"numeroControl"`
+	CodigoCliente       *string   `json:"codigoCliente"`
+	RIF                 *string   `json:"rif"`
+	NombreCliente       *string   `json:"nombreCliente"`
+	CodigoMonedaDeCobro string    `json:"codigoMonedaDeCobro"`
+	FormaDeCobro        string    `json:"formaDeCobro"`
+	TotalFactura        *float64  `json:"totalFactura"`
+	BaseImponibleIGTF   *float64  `json:"baseImponibleIgtf"`
+	AlicuotaIGTF        *float64  `json:"alicuotaIgtf"`
+	IGTFML              *float64  `json:"igtfMl"`
+	IGTFME              *float64  `json:"igtfMe"`
+}
+
+type TotalesIGTF struct {
+	CantidadFacturas  int     `json:"cantidadFacturas"`
+	BaseImponibleIGTF float64 `json:"baseImponibleIgtf"`
+	IGTFML            float64 `json:"igtfMl"`
+	IGTFME            float64 `json:"igtfMe"`
+}
+
+type ReporteIGTF struct {
+	Resumen    []ResumenIGTF      `json:"resumen"`
+	Totales    TotalesIGTF        `json:"totales"`
+	Facturas   []FacturaIGTF      `json:"facturas,omitempty"`
+	Conversion *ConversionReporte `json:"conversion,omitempty"`
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// Tabla del resumen para exportar, con la fila de totales al final
+func (r ReporteIGTF) tablaResumen() exportar.Tabla {
+	t := exportar.Tabla{Columnas: []string{
+		"Año", "Mes", "Moneda de Cobro", "Forma de Cobro", "Cantidad de Facturas",
+		"Base Imponible IGTF", "IGTF Moneda Local", "IGTF Moneda Extranjera",
+	}}
+	for _, g := range r.Resumen {
+		t.Agregar(g.Anio, g.Mes, g.CodigoMonedaDeCobro, g.FormaDeCobro, g.CantidadFacturas,
+			g.BaseImponibleIGTF, g.IGTFML, g.IGTFME)
+	}
+	t.Agregar(nil, nil, "TOTALES", nil, r.Totales.CantidadFacturas,
+		r.Totales.BaseImponibleIGTF, r.Totales.IGTFML, r.Totales.IGTFME)
+	return t
+}
+
+// Tabla de las facturas (drill-down) para exportar
+func (r ReporteIGTF) tablaFacturas() exportar.Tabla {
+	t := exportar.Tabla{Columnas: []string{
+		"Fecha", "Tipo de Documento", "Número", "Nro. Control", "Código Cliente", "RIF",
+		"Nombre o Razón Social", "Moneda de Cobro", "Forma de Cobro", "Total Factura",
+		"Base Imponible IGTF", "% Alícuota IGTF", "IGTF Moneda Local", "IGTF Moneda Extranjera",
+	}}
+	for _, f := range r.Facturas {
+		t.Agregar(f.Fecha, f.TipoDeDocumento, f.Numero, f.NumeroControl, f.CodigoCliente, f.RIF,
+			f.NombreCliente, f.CodigoMonedaDeCobro, f.FormaDeCobro, f.TotalFactura,
+			f.BaseImponibleIGTF, f.AlicuotaIGTF, f.IGTFML, f.IGTFME)
+	}
+	t.Agregar(nil, nil, nil, nil, nil, nil, "TOTALES", nil, nil, nil,
+		r.Totales.BaseImponibleIGTF, nil, r.Totales.IGTFML, r.Totales.IGTFME)
+	return t
+}
+
+/*
+Totales del IGTF cobrado.
+Querys:
+
+mes y anio, o desde y hasta (AAAA-MM-DD): obligatorio
+codigoMonedaDeCobro: solo las facturas cobradas en esa moneda
+formaDeCobro: solo las facturas con esa forma de cobro
+detalle: si/true para incluir las facturas (drill-down)
+formato: json (por defecto), csv o xlsx. Con detalle se exportan las facturas
+moneda, fechaCambio: agrega los totales convertidos (solo en json)
+*/
+func reporteIGTF(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando reporte de IGTF", nil)
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		desde, hasta := leerPeriodo(c, &v)
+		if v.Valido() && (desde == nil || hasta == nil) {
+			v.Agregar("periodo", "Indique mes y anio, o desde y hasta")
+		}
+		formato, okFormato := exportar.Formato(c)
+		if !okFormato {
+			v.Agregar("formato", "Formato inválido. Use: json, csv o xlsx")
+		}
+		conversion := monedas.LeerConversion(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if !prepararConversion(c, db, conversion, requestID) {
+			return
+		}
+
+		detalle := strings.ToLower(c.Query("detalle"))
+		conDetalle := detalle == "si" || detalle == "true"
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT
+				f.Numero, f.Fecha, f.TipoDeDocumento, f.NumeroControl, f.CodigoCliente,
+				cl.NumeroRIF, cl.Nombre, ISNULL(f.CodigoMonedaDeCobro, ''), f.FormaDeCobro,
+				f.TotalFactura, f.BaseImponibleIGTF, f.AlicuotaIGTF, f.IGTFML, f.IGTFME,
+				` + monedas.ColumnasDocumento("f.") + `
+			FROM dbo.factura f
+			LEFT JOIN dbo.Cliente cl
+				ON cl.ConsecutivoCompania = f.ConsecutivoCompania AND cl.Codigo = f.CodigoCliente
+			WHERE f.StatusFactura <> @borrador
+			  AND ISNULL(f.Cancelada, 'N') <> 'S'
+			  AND (ISNULL(f.IGTFML, 0) <> 0 OR ISNULL(f.IGTFME, 0) <> 0)
+			  AND f.Fecha >= @desde AND f.Fecha < @hasta
+		`
+		params := []interface{}{
+			sql.Named("borrador", facturas.StatusBorrador),
+			sql.Named("desde", *desde),
+			sql.Named("hasta", *hasta),
+		}
+
+		if moneda := strings.TrimSpace(c.Query("codigoMonedaDeCobro")); moneda != "" {
+			query += " AND f.CodigoMonedaDeCobro = @moneda"
+			params = append(params, sql.Named("moneda", moneda))
+		}
+		if forma := strings.TrimSpace(c.Query("formaDeCobro")); forma != "" {
+			query += " AND f.FormaDeCobro = @formaDeCobro"
+			params = append(params, sql.Named("formaDeCobro", forma))
+		}
+		query += " ORDER BY f.Fecha, f.NumeroControl, f.Numero"
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar las facturas con IGTF"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		reporte := ReporteIGTF{Resumen: []ResumenIGTF{}, Conversion: nuevaConversionReporte(conversion)}
+		grupos := map[string]int{} // clave => posicion en Resumen
+
+		for rows.Next() {
+			var f FacturaIGTF
+			var tipoDocumento string
+			var base, ml, me *float64
+			var doc monedas.Documento
+			err := rows.Scan(append([]interface{}{
+				&f.Numero, &f.Fecha, &tipoDocumento, &f.NumeroControl, &f.CodigoCliente,
+				&f.RIF, &f.NombreCliente, &f.CodigoMonedaDeCobro, &f.FormaDeCobro,
+				&f.TotalFactura, &base, &f.AlicuotaIGTF, &ml, &me,
+			}, doc.Destinos()...)...)
+			if err != nil {
+				mensaje := "Error al leer datos de facturas con IGTF"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+
+			signo := 1.0
+			if tipoDocumento == facturas.TipoDocumentoNotaCredito {
+				signo = -1
+			}
+			f.TipoDeDocumento = nombresTipoDocumento[tipoDocumento]
+			if f.TipoDeDocumento == "" {
+				f.TipoDeDocumento = tipoDocumento
+			}
+			f.BaseImponibleIGTF = conSigno(base, signo)
+			f.IGTFML = conSigno(ml, signo)
+			f.IGTFME = conSigno(me, signo)
+
+			// ----- Acumulado por mes, moneda y forma de cobro ----- //
+			clave := f.Fecha.Format("2006-01") + "|" + f.CodigoMonedaDeCobro + "|" + f.FormaDeCobro
+			i, ok := grupos[clave]
+			if !ok {
+				i = len(reporte.Resumen)
+				grupos[clave] = i
+				reporte.Resumen = append(reporte.Resumen, ResumenIGTF{
+					Anio:                f.Fecha.Year(),
+					Mes:                 int(f.Fecha.Month()),
+					CodigoMonedaDeCobro: f.CodigoMonedaDeCobro,
+					FormaDeCobro:        f.FormaDeCobro,
+				})
+			}
+			g := &reporte.Resumen[i]
+			g.CantidadFacturas++
+			sumar(&g.BaseImponibleIGTF, f.BaseImponibleIGTF)
+			sumar(&g.IGTFML, f.IGTFML)
+			sumar(&g.IGTFME, f.IGTFME)
+
+			reporte.Totales.CantidadFacturas++
+			sumar(&reporte.Totales.BaseImponibleIGTF, f.BaseImponibleIGTF)
+			sumar(&reporte.Totales.IGTFML, f.IGTFML)
+			sumar(&reporte.Totales.IGTFME, f.IGTFME)
+
+			doc.Fecha = f.Fecha
+			err = reporte.Conversion.acumular(doc, map[string]*float64{
+				"baseImponibleIgtf": f.BaseImponibleIGTF,
+				"igtfMl":            f.IGTFML,
+			})
+			if err != nil {
+				mensaje := "Error al convertir los montos del IGTF"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+
+			if conDetalle {
+				reporte.Facturas = append(reporte.Facturas, f)
+			}
+		}
+		reporte.Conversion.redondear()
+
+		logError(requestID+" - Reporte de IGTF generado con "+strconv.Itoa(reporte.Totales.CantidadFacturas)+" facturas", nil)
+
+		// ----- Respuesta ----- //
+		if formato == exportar.FormatoJSON {
+			respuesta.Exito(c, "Reporte de IGTF generado", reporte, len(reporte.Resumen))
+			return
+		}
+
+		nombre := "igtf_" + desde.Format("20060102") + "_" + hasta.AddDate(0, 0, -1).Format("20060102")
+		tabla := reporte.tablaResumen()
+		if conDetalle {
+			nombre += "_detalle"
+			tabla = reporte.tablaFacturas()
+		}
+		if err := exportar.Enviar(c, formato, nombre, "IGTF", tabla); err != nil {
+			mensaje := "Error al exportar el reporte de IGTF"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.Error(c, http.StatusInternalServerError, respuesta.ErrorInterno, mensaje)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package reportes
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/compras"
+	"github.com/desarrolladoresnet/api_galac_bd/exportar"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Libro de Compras mensual exigido por el SENIAT.
+
+	Se genera a partir de dbo.cxP: las cuentas por pagar con
+	AplicaParaLibrodeCompras = 'S' cuyo mes de aplicacion
+	(MesDeAplicacion/AnoDeAplicacion) es el solicitado, que
+	no siempre coincide con la fecha del documento. Las
+	cuentas anuladas no forman parte del libro.
+
+	Los otros impuestos salen del detalle OtrosImpuestosCxP.
+	La BD no tiene la tabla de proveedores, por eso el libro
+	trae el codigo del proveedor y no su RIF ni su nombre.
+
+	Igual que en el Libro de Ventas, las notas de credito se
+	presentan en negativo, incluido el IVA retenido, y el
+	libro es de una sola compania.
+*/
+
+type OtroImpuestoCompra struct {
+	Siglas        string   `json:"siglas"`
+	Descripcion   *string  `json:"descripcion"`
+	Alicuota      *float64 `json:"alicuota"`
+	BaseImponible float64  `json:"baseImponible"`
+	Monto         float64  `json:"monto"`
+}
+
+type RenglonLibroCompras struct {
+	NumeroOperacion             int                  `json:"numeroOperacion"`
+	Fecha                       *time.Time           `json:"fecha"`
+	CodigoProveedor             string               `json:"codigoProveedor"`
+	TipoDeDocumento             string               `json:"tipoDeDocumento"`
+	NumeroFactura               *string              `json:"numeroFactura"`
+	NumeroNotaDebito            *string              `json:"numeroNotaDebito"`
+	NumeroNotaCredito           *string              `json:"numeroNotaCredito"`
+	NumeroControl               *string              `json:"numeroControl"`
+	NumeroPlanillaImportacion   *string              `json:"numeroPlanillaImportacion"`
+	NumeroExpedienteImportacion *string              `json:"numeroExpedienteImportacion"`
+	TipoDeTransaccion           *string              `json:"tipoDeTransaccion"`
+	NumeroFacturaAfectada       *string              `json:"numeroFacturaAfectada"`
+	TipoDeCompra                *string              `json:"tipoDeCompra"`
+	CreditoFiscal               *string              `json:"creditoFiscal"`
+	TotalComprasConIVA          float64              `json:"totalComprasConIva"`
+	ComprasExentas              *float64             `json:"comprasExentas"`
+	BaseImponibleGeneral        *float64             `json:"baseImponibleGeneral"`
+	IVAGeneral                  *float64             `json:"ivaGeneral"`
+	BaseImponibleAlicuota2      *float64             `json:"baseImponibleAlicuota2"`
+	IVAAlicuota2                *float64             `json:"ivaAlicuota2"`
+	BaseImponibleAlicuota3      *float64             `json:"baseImponibleAlicuota3"`
+	IVAAlicuota3                *float64             `json:"ivaAlicuota3"`
+	TotalOtrosImpuestos         *float64             `json:"totalOtrosImpuestos"`
+	OtrosImpuestos              []OtroImpuestoCompra `json:"otrosImpuestos"`
+	IVARetenido                 *float64             `json:"ivaRetenido"`
+	PorcentajeRetencion         *float64             `json:"porcentajeRetencion"`
+	NumeroComprobanteRetencion  *string              `json:"numeroComprobanteRetencion"`
+	FechaAplicacionRetencion    *time.Time           `json:"fechaAplicacionRetencion"`
+}
+
+type TotalesLibroCompras struct {
+	TotalComprasConIVA     float64              `json:"totalComprasConIva"`
+	ComprasExentas         float64              `json:"comprasExentas"`
+	BaseImponibleGeneral   float64              `json:"baseImponibleGeneral"`
+	IVAGeneral             float64              `json:"ivaGeneral"`
+	BaseImponibleAlicuota2 float64              `json:"baseImponibleAlicuota2"`
+	IVAAlicuota2           float64              `json:"ivaAlicuota2"`
+	BaseImponibleAlicuota3 float64              `json:"baseImponibleAlicuota3"`
+	IVAAlicuota3           float64              `json:"ivaAlicuota3"`
+	TotalOtrosImpuestos    float64              `json:"totalOtrosImpuestos"`
+	OtrosImpuestos         []OtroImpuestoCompra `json:"otrosImpuestos"` // por siglas
+	IVARetenido            float64              `json:"ivaRetenido"`
+}
+
+type LibroCompras struct {
+	Mes       int                   `json:"mes"`
+	Anio      int                   `json:"anio"`
+	Renglones []RenglonLibroCompras `json:"renglones"`
+	Totales   TotalesLibroCompras   `json:"totales"`
+}
+
+var nombresTipoCxp = map[string]string{
+	compras.TipoCxpFactura:     "FACTURA",
+	compras.TipoCxpNotaCredito: "NOTA_CREDITO",
+	compras.TipoCxpNotaDebito:  "NOTA_DEBITO",
+}
+
+// Clave de una cuenta por pagar para unir su detalle
+type claveCxp struct {
+	compania    int
+	consecutivo int
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+func (t *TotalesLibroCompras) acumular(r RenglonLibroCompras) {
+	t.TotalComprasConIVA += r.TotalComprasConIVA
+	sumar(&t.ComprasExentas, r.ComprasExentas)
+	sumar(&t.BaseImponibleGeneral, r.BaseImponibleGeneral)
+	sumar(&t.IVAGeneral, r.IVAGeneral)
+	sumar(&t.BaseImponibleAlicuota2, r.BaseImponibleAlicuota2)
+	sumar(&t.IVAAlicuota2, r.IVAAlicuota2)
+	sumar(&t.BaseImponibleAlicuota3, r.BaseImponibleAlicuota3)
+	sumar(&t.IVAAlicuota3, r.IVAAlicuota3)
+	sumar(&t.TotalOtrosImpuestos, r.TotalOtrosImpuestos)
+	sumar(&t.IVARetenido, r.IVARetenido)
+}
+
+// Totales de otros impuestos por siglas, ordenados
+func totalOtrosImpuestos(renglones []RenglonLibroCompras) []OtroImpuestoCompra {
+	porSiglas := map[string]*OtroImpuestoCompra{}
+	for _, r := range renglones {
+		for _, oi := range r.OtrosImpuestos {
+			total, ok := porSiglas[oi.Siglas]
+			if !ok {
+				total = &OtroImpuestoCompra{Siglas: oi.Siglas, Descripcion: oi.Descripcion, Alicuota: oi.Alicuota}
+				porSiglas[oi.Siglas] = total
+			}
+			total.BaseImponible += oi.BaseImponible
+			total.Monto += oi.Monto
+		}
+	}
+
+	totales := []OtroImpuestoCompra{}
+	for _, total := range porSiglas {
+		totales = append(totales, *total)
+	}
+	sort.Slice(totales, func(i, j int) bool { return totales[i].Siglas < totales[j].Siglas })
+	return totales
+}
+
+// Siglas de los otros impuestos de un renglon, para exportar
+func siglasOtrosImpuestos(lista []OtroImpuestoCompra) string {
+	siglas := make([]string, len(lista))
+	for i, oi := range lista {
+		siglas[i] = oi.Siglas
+	}
+	return strings.Join(siglas, ", ")
+}
+
+// Tabla del libro para exportar, con la fila de totales al final
+func (l LibroCompras) tabla() exportar.Tabla {
+	t := exportar.Tabla{Columnas: []string{
+		"Nro. Operación", "Fecha", "Código Proveedor", "Tipo de Documento",
+		"Nro. Factura", "Nro. Nota de Débito", "Nro. Nota de Crédito", "Nro. Control",
+		"Nro. Planilla de Importación", "Nro. Expediente de Importación",
+		"Tipo de Transacción", "Nro. Factura Afectada", "Total Compras con IVA", "Compras Exentas",
+		"Base Imponible Alícuota General", "IVA Alícuota General",
+		"Base Imponible Alícuota 2", "IVA Alícuota 2",
+		"Base Imponible Alícuota 3", "IVA Alícuota 3",
+		"Otros Impuestos", "Siglas Otros Impuestos",
+		"IVA Retenido", "% Retención", "Nro. Comprobante Retención",
+	}}
+
+	for _, r := range l.Renglones {
+		t.Agregar(r.NumeroOperacion, r.Fecha, r.CodigoProveedor, r.TipoDeDocumento,
+			r.NumeroFactura, r.NumeroNotaDebito, r.NumeroNotaCredito, r.NumeroControl,
+			r.NumeroPlanillaImportacion, r.NumeroExpedienteImportacion,
+			r.TipoDeTransaccion, r.NumeroFacturaAfectada, r.TotalComprasConIVA, r.ComprasExentas,
+			r.BaseImponibleGeneral, r.IVAGeneral,
+			r.BaseImponibleAlicuota2, r.IVAAlicuota2,
+			r.BaseImponibleAlicuota3, r.IVAAlicuota3,
+			r.TotalOtrosImpuestos, siglasOtrosImpuestos(r.OtrosImpuestos),
+			r.IVARetenido, r.PorcentajeRetencion, r.NumeroComprobanteRetencion)
+	}
+
+	tot := l.Totales
+	t.Agregar(nil, nil, "TOTALES", nil, nil, nil, nil, nil, nil, nil, nil, nil,
+		tot.TotalComprasConIVA, tot.ComprasExentas,
+		tot.BaseImponibleGeneral, tot.IVAGeneral,
+		tot.BaseImponibleAlicuota2, tot.IVAAlicuota2,
+		tot.BaseImponibleAlicuota3, tot.IVAAlicuota3,
+		tot.TotalOtrosImpuestos, siglasOtrosImpuestos(tot.OtrosImpuestos),
+		tot.IVARetenido, nil, nil)
+
+	return t
+}
+
+// Condicion de las cuentas que van en el libro del periodo
+const condicionLibroCompras = `
+	c.AplicaParaLibrodeCompras = 'S'
+	AND c.MesDeAplicacion = @mes AND c.AnoDeAplicacion = @anio
+	AND ISNULL(c.Status, '') <> @anulado`
+
+/*
+Otros impuestos de las cuentas del libro, por cuenta.
+El signo se aplica despues, segun el tipo de documento.
+*/
+func otrosImpuestosLibro(db *sql.DB, condicion string, params []interface{}) (map[claveCxp][]OtroImpuestoCompra, error) {
+	rows, err := db.Query(`
+		SELECT oi.ConsecutivoCompania, oi.ConsecutivoCxP, oi.Siglas, oi.Descripcion,
+		       oi.AlicuotaOI, oi.MontoBaseImponible, oi.Monto
+		FROM dbo.OtrosImpuestosCxP oi
+		JOIN dbo.cxP c
+			ON c.ConsecutivoCompania = oi.ConsecutivoCompania AND c.ConsecutivoCxp = oi.ConsecutivoCxP
+		WHERE `+condicion+`
+		ORDER BY oi.ConsecutivoCompania, oi.ConsecutivoCxP, oi.ConsecutivoRenglonOI
+	`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	porCuenta := map[claveCxp][]OtroImpuestoCompra{}
+	for rows.Next() {
+		var clave claveCxp
+		var oi OtroImpuestoCompra
+		if err := rows.Scan(&clave.compania, &clave.consecutivo, &oi.Siglas, &oi.Descripcion,
+			&oi.Alicuota, &oi.BaseImponible, &oi.Monto); err != nil {
+			return nil, err
+		}
+		porCuenta[clave] = append(porCuenta[clave], oi)
+	}
+	return porCuenta, rows.Err()
+}
+
+/*
+Genera el Libro de Compras de un mes de aplicacion.
+Querys:
+
+mes: obligatorio, entre 1 y 12
+anio: obligatorio
+compania: ConsecutivoCompania; obligatorio si el mes tiene cuentas de varias (409)
+formato: json (por defecto), csv o xlsx
+*/
+func libroDeCompras(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando Libro de Compras", nil)
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		periodo, ok := v.MesAnio(c.Query("mes"), c.Query("anio"))
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		formato, okFormato := exportar.Formato(c)
+		if !okFormato {
+			v.Agregar("formato", "Formato inválido. Use: json, csv o xlsx")
+		}
+		if !v.Valido() || !ok {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		condicion, params, ok := companiaLibro(c, db, requestID, "dbo.cxP c", "c.ConsecutivoCompania",
+			condicionLibroCompras,
+			[]interface{}{
+				sql.Named("mes", periodo.Mes),
+				sql.Named("anio", periodo.Anio),
+				sql.Named("anulado", compras.StatusCxpAnulado),
+			}, compania, conCompania)
+		if !ok {
+			return
+		}
+
+		// ----- Otros impuestos ----- //
+		otros, err := otrosImpuestosLibro(db, condicion, params)
+		if err != nil {
+			mensaje := "Error al consultar los otros impuestos del Libro de Compras"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT
+				c.ConsecutivoCompania, c.ConsecutivoCxp, c.Fecha, c.CodigoProveedor, c.TipoDeCxp, c.Numero, c.NumeroControl,
+				c.NumeroPlanillaDeImportacion, c.NumeroExpedienteDeImportacion, c.TipoDeTransaccion,
+				c.NumeroDeFacturaAfectada, c.TipoDeCompra, c.CreditoFiscal,
+				c.MontoExento, c.MontoGravableAlicuotaGeneral, c.MontoIvaalicuotaGeneral,
+				c.MontoGravableAlicuota2, c.MontoIvaalicuota2, c.MontoGravableAlicuota3, c.MontoIvaalicuota3,
+				c.MontoGravado, c.MontoIva, c.TotalOtrosImpuestos,
+				c.MontoRetenido, c.PorcentajeRetencionAplicado, c.NumeroComprobanteRetencion, c.FechaAplicacionRetIva
+			FROM dbo.cxP c
+			WHERE ` + condicion + `
+			ORDER BY c.Fecha, c.NumeroControl, c.ConsecutivoCxp
+		`
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar las cuentas por pagar del Libro de Compras"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		libro := LibroCompras{Mes: periodo.Mes, Anio: periodo.Anio, Renglones: []RenglonLibroCompras{}}
+
+		for rows.Next() {
+			var r RenglonLibroCompras
+			var clave claveCxp
+			var tipo *string
+			var numero string
+			var exento, base1, iva1, base2, iva2, base3, iva3, gravado, iva, otrosImpuestos *float64
+
+			err := rows.Scan(
+				&clave.compania, &clave.consecutivo, &r.Fecha, &r.CodigoProveedor, &tipo, &numero, &r.NumeroControl,
+				&r.NumeroPlanillaImportacion, &r.NumeroExpedienteImportacion, &r.TipoDeTransaccion,
+				&r.NumeroFacturaAfectada, &r.TipoDeCompra, &r.CreditoFiscal,
+				&exento, &base1, &iva1, &base2, &iva2, &base3, &iva3,
+				&gravado, &iva, &otrosImpuestos,
+				&r.IVARetenido, &r.PorcentajeRetencion, &r.NumeroComprobanteRetencion, &r.FechaAplicacionRetencion,
+			)
+			if err != nil {
+				mensaje := "Error al leer datos del Libro de Compras"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+
+			// El numero va en la columna que corresponde al tipo de documento
+			tipoDocumento := ""
+			if tipo != nil {
+				tipoDocumento = *tipo
+			}
+			signo := 1.0
+			switch tipoDocumento {
+			case compras.TipoCxpNotaCredito:
+				r.NumeroNotaCredito = &numero
+				signo = -1
+			case compras.TipoCxpNotaDebito:
+				r.NumeroNotaDebito = &numero
+			default:
+				r.NumeroFactura = &numero
+			}
+
+			r.TipoDeDocumento = nombresTipoCxp[tipoDocumento]
+			if r.TipoDeDocumento == "" {
+				r.TipoDeDocumento = tipoDocumento
+			}
+
+			r.NumeroOperacion = len(libro.Renglones) + 1
+			r.ComprasExentas = conSigno(exento, signo)
+			r.BaseImponibleGeneral = conSigno(base1, signo)
+			r.IVAGeneral = conSigno(iva1, signo)
+			r.BaseImponibleAlicuota2 = conSigno(base2, signo)
+			r.IVAAlicuota2 = conSigno(iva2, signo)
+			r.BaseImponibleAlicuota3 = conSigno(base3, signo)
+			r.IVAAlicuota3 = conSigno(iva3, signo)
+			r.TotalOtrosImpuestos = conSigno(otrosImpuestos, signo)
+			r.IVARetenido = conSigno(r.IVARetenido, signo)
+
+			// Total del documento: exento + gravado + IVA + otros impuestos
+			for _, monto := range []*float64{exento, gravado, iva, otrosImpuestos} {
+				sumar(&r.TotalComprasConIVA, monto)
+			}
+			r.TotalComprasConIVA *= signo
+
+			r.OtrosImpuestos = []OtroImpuestoCompra{}
+			for _, oi := range otros[clave] {
+				oi.BaseImponible *= signo
+				oi.Monto *= signo
+				r.OtrosImpuestos = append(r.OtrosImpuestos, oi)
+			}
+
+			libro.Totales.acumular(r)
+			libro.Renglones = append(libro.Renglones, r)
+		}
+		libro.Totales.OtrosImpuestos = totalOtrosImpuestos(libro.Renglones)
+
+		logError(requestID+" - Libro de Compras "+strconv.Itoa(periodo.Mes)+"/"+strconv.Itoa(periodo.Anio)+
+			" generado con "+strconv.Itoa(len(libro.Renglones))+" renglones", nil)
+
+		// ----- Respuesta ----- //
+		if formato == exportar.FormatoJSON {
+			respuesta.Exito(c, "Libro de Compras generado", libro, len(libro.Renglones))
+			return
+		}
+
+		nombre := "libro_compras_" + strconv.Itoa(periodo.Anio) + "_" + strconv.Itoa(periodo.Mes)
+		if err := exportar.Enviar(c, formato, nombre, "Libro de Compras", libro.tabla()); err != nil {
+			mensaje := "Error al exportar el Libro de Compras"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.Error(c, http.StatusInternalServerError, respuesta.ErrorInterno, mensaje)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package reportes
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/exportar"
+	"github.com/desarrolladoresnet/api_galac_bd/facturas"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Libro de Ventas mensual exigido por el SENIAT.
+
+	Se genera a partir de dbo.factura (facturas, notas de
+	credito y notas de debito del mes) con el RIF y el nombre
+	del Cliente. Los borradores no forman parte del libro.
+
+	Los documentos anulados (Cancelada = 'S' o con motivo de
+	anulacion) se mantienen en el libro para no romper la secuencia de numeros de control,
+	pero marcados como anulados y con todos los montos en cero.
+
+	Las notas de credito restan, por eso sus montos se
+	presentan en negativo y asi se suman en los totales.
+
+	El libro es de una sola compania: si el periodo tiene
+	documentos de varias hay que indicar compania.
+*/
+
+type RenglonLibroVentas struct {
+	NumeroOperacion         int       `json:"numeroOperacion"`
+	Fecha                   time.Time `json:"fecha"`
+	RIF                     *string   `json:"rif"`
+	NombreCliente           *string   `json:"nombreCliente"`
+	TipoDeDocumento         string    `json:"tipoDeDocumento"`
+	Anulada                 bool      `json:"anulada"`
+	NumeroFactura           *string   `json:"numeroFactura"`
+	NumeroNotaDebito        *string   `json:"numeroNotaDebito"`
+	NumeroNotaCredito       *string   `json:"numeroNotaCredito"`
+	NumeroControl           *string   `json:"numeroControl"`
+	TipoDeTransaccion       *string   `json:"tipoDeTransaccion"`
+	NumeroFacturaAfectada   *string   `json:"numeroFacturaAfectada"`
+	TotalVentasConIVA       *float64  `json:"totalVentasConIva"`
+	VentasExentas           *float64  `json:"ventasExentas"`
+	BaseImponibleAlicuota1  *float64  `json:"baseImponibleAlicuota1"`
+	PorcentajeAlicuota1     *float64  `json:"porcentajeAlicuota1"`
+	IVAAlicuota1            *float64  `json:"ivaAlicuota1"`
+	BaseImponibleAlicuota2  *float64  `json:"baseImponibleAlicuota2"`
+	PorcentajeAlicuota2     *float64  `json:"porcentajeAlicuota2"`
+	IVAAlicuota2            *float64  `json:"ivaAlicuota2"`
+	BaseImponibleAlicuota3  *float64  `json:"baseImponibleAlicuota3"`
+	PorcentajeAlicuota3     *float64  `json:"porcentajeAlicuota3"`
+	IVAAlicuota3            *float64  `json:"ivaAlicuota3"`
+	IVARetenido             *float64  `json:"ivaRetenido"`
+	NumeroComprobanteRetIVA *int      `json:"numeroComprobanteRetIva"`
+}
+
+type TotalesLibroVentas struct {
+	TotalVentasConIVA      float64 `json:"totalVentasConIva"`
+	VentasExentas          float64 `json:"ventasExentas"`
+	BaseImponibleAlicuota1 float64 `json:"baseImponibleAlicuota1"`
+	IVAAlicuota1           float64 `json:"ivaAlicuota1"`
+	BaseImponibleAlicuota2 float64 `json:"baseImponibleAlicuota2"`
+	IVAAlicuota2           float64 `json:"ivaAlicuota2"`
+	BaseImponibleAlicuota3 float64 `json:"baseImponibleAlicuota3"`
+	IVAAlicuota3           float64 `json:"ivaAlicuota3"`
+	IVARetenido            float64 `json:"ivaRetenido"`
+}
+
+type LibroVentas struct {
+	Mes        int                  `json:"mes"`
+	Anio       int                  `json:"anio"`
+	Renglones  []RenglonLibroVentas `json:"renglones"`
+	Totales    TotalesLibroVentas   `json:"totales"`
+	Conversion *ConversionLibro     `json:"conversion,omitempty"`
+}
+
+/*
+Totales del libro en la moneda solicitada (query moneda),
+convertidos renglon por renglon con la tasa de cada documento.
+Los renglones sin tasa no se suman.
+*/
+type ConversionLibro struct {
+	Moneda            string             `json:"moneda"`
+	Totales           TotalesLibroVentas `json:"totales"`
+	DocumentosSinTasa int                `json:"documentosSinTasa"`
+}
+
+var nombresTipoDocumento = map[string]string{
+	facturas.TipoDocumentoFactura:     "FACTURA",
+	facturas.TipoDocumentoNotaCredito: "NOTA_CREDITO",
+	facturas.TipoDocumentoNotaDebito:  "NOTA_DEBITO",
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+func (t *TotalesLibroVentas) acumular(r RenglonLibroVentas) {
+	sumar(&t.TotalVentasConIVA, r.TotalVentasConIVA)
+	sumar(&t.VentasExentas, r.VentasExentas)
+	sumar(&t.BaseImponibleAlicuota1, r.BaseImponibleAlicuota1)
+	sumar(&t.IVAAlicuota1, r.IVAAlicuota1)
+	sumar(&t.BaseImponibleAlicuota2, r.BaseImponibleAlicuota2)
+	sumar(&t.IVAAlicuota2, r.IVAAlicuota2)
+	sumar(&t.BaseImponibleAlicuota3, r.BaseImponibleAlicuota3)
+	sumar(&t.IVAAlicuota3, r.IVAAlicuota3)
+	sumar(&t.IVARetenido, r.IVARetenido)
+}
+
+// Deja en cero los montos de un documento anulado
+func (r *RenglonLibroVentas) anularMontos() {
+	for _, monto := range []**float64{
+		&r.TotalVentasConIVA, &r.VentasExentas,
+		&r.BaseImponibleAlicuota1, &r.IVAAlicuota1,
+		&r.BaseImponibleAlicuota2, &r.IVAAlicuota2,
+		&r.BaseImponibleAlicuota3, &r.IVAAlicuota3,
+		&r.IVARetenido,
+	} {
+		cero := 0.0
+		*monto = &cero
+	}
+}
+
+// Tabla del libro para exportar, con la fila de totales al final
+func (l LibroVentas) tabla() exportar.Tabla {
+	t := exportar.Tabla{Columnas: []string{
+		"Nro. Operación", "Fecha", "RIF", "Nombre o Razón Social", "Tipo de Documento", "Anulada",
+		"Nro. Factura", "Nro. Nota de Débito", "Nro. Nota de Crédito", "Nro. Control",
+		"Tipo de Transacción", "Nro. Factura Afectada", "Total Ventas con IVA", "Ventas Exentas",
+		"Base Imponible Alícuota 1", "% Alícuota 1", "IVA Alícuota 1",
+		"Base Imponible Alícuota 2", "% Alícuota 2", "IVA Alícuota 2",
+		"Base Imponible Alícuota 3", "% Alícuota 3", "IVA Alícuota 3",
+		"IVA Retenido", "Nro. Comprobante Retención IVA",
+	}}
+
+	for _, r := range l.Renglones {
+		anulada := ""
+		if r.Anulada {
+			anulada = "SI"
+		}
+		t.Agregar(r.NumeroOperacion, r.Fecha, r.RIF, r.NombreCliente, r.TipoDeDocumento, anulada,
+			r.NumeroFactura, r.NumeroNotaDebito, r.NumeroNotaCredito, r.NumeroControl,
+			r.TipoDeTransaccion, r.NumeroFacturaAfectada, r.TotalVentasConIVA, r.VentasExentas,
+			r.BaseImponibleAlicuota1, r.PorcentajeAlicuota1, r.IVAAlicuota1,
+			r.BaseImponibleAlicuota2, r.PorcentajeAlicuota2, r.IVAAlicuota2,
+			r.BaseImponibleAlicuota3, r.PorcentajeAlicuota3, r.IVAAlicuota3,
+			r.IVARetenido, r.NumeroComprobanteRetIVA)
+	}
+
+	tot := l.Totales
+	t.Agregar(nil, nil, nil, "TOTALES", nil, nil, nil, nil, nil, nil, nil, nil,
+		tot.TotalVentasConIVA, tot.VentasExentas,
+		tot.BaseImponibleAlicuota1, nil, tot.IVAAlicuota1,
+		tot.BaseImponibleAlicuota2, nil, tot.IVAAlicuota2,
+		tot.BaseImponibleAlicuota3, nil, tot.IVAAlicuota3,
+		tot.IVARetenido, nil)
+
+	return t
+}
+
+// Suma a los totales convertidos el renglon en la moneda solicitada
+func (cl *ConversionLibro) acumular(cv *monedas.Conversion, doc monedas.Documento, r RenglonLibroVentas) error {
+	resultado, err := cv.Aplicar(doc, map[string]*float64{
+		"totalVentasConIva":      r.TotalVentasConIVA,
+		"ventasExentas":          r.VentasExentas,
+		"baseImponibleAlicuota1": r.BaseImponibleAlicuota1,
+		"ivaAlicuota1":           r.IVAAlicuota1,
+		"baseImponibleAlicuota2": r.BaseImponibleAlicuota2,
+		"ivaAlicuota2":           r.IVAAlicuota2,
+		"baseImponibleAlicuota3": r.BaseImponibleAlicuota3,
+		"ivaAlicuota3":           r.IVAAlicuota3,
+		"ivaRetenido":            r.IVARetenido,
+	})
+	if err != nil {
+		return err
+	}
+	if resultado.Montos == nil {
+		cl.DocumentosSinTasa++
+		return nil
+	}
+
+	m := resultado.Montos
+	cl.Totales.acumular(RenglonLibroVentas{
+		TotalVentasConIVA:      m["totalVentasConIva"],
+		VentasExentas:          m["ventasExentas"],
+		BaseImponibleAlicuota1: m["baseImponibleAlicuota1"],
+		IVAAlicuota1:           m["ivaAlicuota1"],
+		BaseImponibleAlicuota2: m["baseImponibleAlicuota2"],
+		IVAAlicuota2:           m["ivaAlicuota2"],
+		BaseImponibleAlicuota3: m["baseImponibleAlicuota3"],
+		IVAAlicuota3:           m["ivaAlicuota3"],
+		IVARetenido:            m["ivaRetenido"],
+	})
+	return nil
+}
+
+/*
+Genera el Libro de Ventas de un mes.
+Querys:
+
+mes: obligatorio, entre 1 y 12
+anio: obligatorio
+compania: ConsecutivoCompania; obligatorio si el mes tiene documentos de varias (409)
+formato: json (por defecto), csv o xlsx
+moneda, fechaCambio: agrega los totales convertidos (solo en json)
+*/
+func libroDeVentas(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando Libro de Ventas", nil)
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		periodo, ok := v.MesAnio(c.Query("mes"), c.Query("anio"))
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		formato, okFormato := exportar.Formato(c)
+		if !okFormato {
+			v.Agregar("formato", "Formato inválido. Use: json, csv o xlsx")
+		}
+		conversion := monedas.LeerConversion(c, &v)
+		if !v.Valido() || !ok {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if !prepararConversion(c, db, conversion, requestID) {
+			return
+		}
+
+		condicion, params, ok := companiaLibro(c, db, requestID, "dbo.factura f", "f.ConsecutivoCompania",
+			"f.Fecha >= @desde AND f.Fecha < @hasta AND f.StatusFactura <> @borrador",
+			[]interface{}{
+				sql.Named("desde", periodo.Desde),
+				sql.Named("hasta", periodo.Hasta),
+				sql.Named("borrador", facturas.StatusBorrador),
+			}, compania, conCompania)
+		if !ok {
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT
+				f.Fecha, cl.NumeroRIF, cl.Nombre, f.TipoDeDocumento, f.Numero, f.NumeroControl,
+				f.TipoDeTransaccion, f.NumeroFacturaAfectada, f.TotalFactura, f.TotalMontoExento,
+				f.MontoGravableAlicuota1, f.PorcentajeAlicuota1, f.MontoIVAAlicuota1,
+				f.MontoGravableAlicuota2, f.PorcentajeAlicuota2, f.MontoIVAAlicuota2,
+				f.MontoGravableAlicuota3, f.PorcentajeAlicuota3, f.MontoIVAAlicuota3,
+				f.MontoIvaRetenido, f.NumeroComprobanteRetIVA,
+				` + monedas.ColumnasDocumento("f.") + `,
+				CASE WHEN ` + facturas.CondicionAnulada("f.") + ` THEN 1 ELSE 0 END AS Anulada
+			FROM dbo.factura f
+			LEFT JOIN dbo.Cliente cl
+				ON cl.ConsecutivoCompania = f.ConsecutivoCompania AND cl.Codigo = f.CodigoCliente
+			WHERE ` + condicion + `
+			ORDER BY f.Fecha, f.NumeroControl, f.Numero
+		`
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar las facturas del Libro de Ventas"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		libro := LibroVentas{Mes: periodo.Mes, Anio: periodo.Anio, Renglones: []RenglonLibroVentas{}}
+		if conversion != nil {
+			libro.Conversion = &ConversionLibro{Moneda: conversion.Moneda}
+		}
+
+		for rows.Next() {
+			var r RenglonLibroVentas
+			var tipoDocumento, numero string
+			var total, exento, base1, iva1, base2, iva2, base3, iva3, retenido *float64
+			var doc monedas.Documento
+
+			destinos := []interface{}{
+				&r.Fecha, &r.RIF, &r.NombreCliente, &tipoDocumento, &numero, &r.NumeroControl,
+				&r.TipoDeTransaccion, &r.NumeroFacturaAfectada, &total, &exento,
+				&base1, &r.PorcentajeAlicuota1, &iva1,
+				&base2, &r.PorcentajeAlicuota2, &iva2,
+				&base3, &r.PorcentajeAlicuota3, &iva3,
+				&retenido, &r.NumeroComprobanteRetIVA,
+			}
+			destinos = append(destinos, doc.Destinos()...)
+			err := rows.Scan(append(destinos, &r.Anulada)...)
+			if err != nil {
+				mensaje := "Error al leer datos del Libro de Ventas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+
+			// El numero va en la columna que corresponde al tipo de documento
+			signo := 1.0
+			switch tipoDocumento {
+			case facturas.TipoDocumentoNotaCredito:
+				r.NumeroNotaCredito = &numero
+				signo = -1
+			case facturas.TipoDocumentoNotaDebito:
+				r.NumeroNotaDebito = &numero
+			default:
+				r.NumeroFactura = &numero
+			}
+
+			r.TipoDeDocumento = nombresTipoDocumento[tipoDocumento]
+			if r.TipoDeDocumento == "" {
+				r.TipoDeDocumento = tipoDocumento
+			}
+
+			r.NumeroOperacion = len(libro.Renglones) + 1
+			r.TotalVentasConIVA = conSigno(total, signo)
+			r.VentasExentas = conSigno(exento, signo)
+			r.BaseImponibleAlicuota1 = conSigno(base1, signo)
+			r.IVAAlicuota1 = conSigno(iva1, signo)
+			r.BaseImponibleAlicuota2 = conSigno(base2, signo)
+			r.IVAAlicuota2 = conSigno(iva2, signo)
+			r.BaseImponibleAlicuota3 = conSigno(base3, signo)
+			r.IVAAlicuota3 = conSigno(iva3, signo)
+			r.IVARetenido = conSigno(retenido, signo)
+
+			// El anulado queda en el libro sin montos
+			if r.Anulada {
+				r.anularMontos()
+			}
+
+			libro.Totales.acumular(r)
+			if libro.Conversion != nil && !r.Anulada {
+				doc.Fecha = r.Fecha
+				if err := libro.Conversion.acumular(conversion, doc, r); err != nil {
+					mensaje := "Error al convertir los montos del Libro de Ventas"
+					logError(requestID+" - "+mensaje, err)
+					respuesta.ErrorBD(c, mensaje)
+					return
+				}
+			}
+			libro.Renglones = append(libro.Renglones, r)
+		}
+
+		logError(requestID+" - Libro de Ventas "+strconv.Itoa(periodo.Mes)+"/"+strconv.Itoa(periodo.Anio)+
+			" generado con "+strconv.Itoa(len(libro.Renglones))+" renglones", nil)
+
+		// ----- Respuesta ----- //
+		if formato == exportar.FormatoJSON {
+			respuesta.Exito(c, "Libro de Ventas generado", libro, len(libro.Renglones))
+			return
+		}
+
+		nombre := "libro_ventas_" + strconv.Itoa(periodo.Anio) + "_" + strconv.Itoa(periodo.Mes)
+		if err := exportar.Enviar(c, formato, nombre, "Libro de Ventas", libro.tabla()); err != nil {
+			mensaje := "Error al exportar el Libro de Ventas"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.Error(c, http.StatusInternalServerError, respuesta.ErrorInterno, mensaje)
+		}
+	}
+}
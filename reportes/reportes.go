@@ -0,0 +1,217 @@
+package reportes
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Logger Interno para el registro de errores y problemas.
+	Solo se instancia en este modulo y generar el archivo
+	errores_reportes.log
+*/
+
+// Logger para registrar errores en un archivo
+var errorLogger *log.Logger
+
+func initErrorLogger() {
+	logFile, err := os.OpenFile("errores_reportes.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Println("Error al abrir archivo de log de reportes:", err)
+		return
+	}
+	errorLogger = log.New(logFile, "", log.Ldate|log.Ltime)
+	log.Println("Logger de errores de reportes inicializado correctamente")
+}
+
+func logError(mensaje string, err error) {
+	if errorLogger != nil {
+		errorLogger.Printf("[ERROR] %s: %v\n", mensaje, err)
+	} else {
+		log.Printf("[ERROR] %s: %v\n", mensaje, err)
+	}
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Reportes fiscales y contables generados a partir de
+	las tablas de Galac. Todos son de solo lectura y pueden
+	exportarse a CSV o XLSX con el query formato.
+*/
+
+// Tiempo maximo que se guarda en cache un reporte
+const ttlCacheReportes = 15 * time.Minute
+
+func ReporteRoutes(api *gin.RouterGroup, db *sql.DB) {
+	initErrorLogger()
+
+	api.GET("/libro-ventas", cache.Respuestas(db, ttlCacheReportes, "factura", "Cliente"), libroDeVentas(db))
+	api.GET("/libro-compras", cache.Respuestas(db, ttlCacheReportes, "cxP", "OtrosImpuestosCxP"), libroDeCompras(db))
+	api.GET("/igtf", cache.Respuestas(db, ttlCacheReportes, "factura", "Cliente"), reporteIGTF(db))
+	api.GET("/alicuotas-iva", cache.Respuestas(db, ttlCacheReportes, "factura", "alicuotaIVA"), reporteAlicuotasIVA(db))
+	api.GET("/cierre-caja", cache.Respuestas(db, ttlCacheReportes, "factura", "renglonCobroDeFactura"), reporteCierreCaja(db))
+}
+
+/*
+Lee el periodo de un reporte: mes y anio juntos, o un
+rango desde/hasta (AAAA-MM-DD). Si no viene ninguno se
+retornan nil y el reporte no se filtra por fecha.
+*/
+func leerPeriodo(c *gin.Context, v *respuesta.Validador) (*time.Time, *time.Time) {
+	if c.Query("mes") != "" || c.Query("anio") != "" {
+		periodo, ok := v.MesAnio(c.Query("mes"), c.Query("anio"))
+		if !ok {
+			return nil, nil
+		}
+		return &periodo.Desde, &periodo.Hasta
+	}
+	return v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+}
+
+/*
+Busca la tasa de fechaCambio si se pidio conversion.
+Si falla responde al cliente y retorna false.
+*/
+func prepararConversion(c *gin.Context, db *sql.DB, cv *monedas.Conversion, requestID string) bool {
+	if cv == nil {
+		return true
+	}
+	ok, err := cv.Preparar(db)
+	if err != nil {
+		mensaje := "Error al consultar la tasa de cambio"
+		logError(requestID+" - "+mensaje, err)
+		respuesta.ErrorBD(c, mensaje)
+		return false
+	}
+	if !ok {
+		respuesta.ErrorValidacion(c, []respuesta.ErrorParametro{
+			{Parametro: "fechaCambio", Mensaje: monedas.MensajeSinTasa},
+		})
+		return false
+	}
+	return true
+}
+
+/*
+Compania de un libro fiscal. El libro es de un solo
+contribuyente, asi que no se mezclan companias: si no se
+indico compania se busca en tabla con la condicion del
+periodo; si hay documentos de una sola se usa esa y si hay
+de varias se responde 409. Retorna la condicion con el
+filtro de compania. Si falla responde al cliente y retorna
+false.
+*/
+func companiaLibro(c *gin.Context, db *sql.DB, requestID, tabla, columna, condicion string, params []interface{}, compania int, conCompania bool) (string, []interface{}, bool) {
+	if !conCompania {
+		companias, err := respuesta.LeerUnico(db, `
+			SELECT DISTINCT TOP 2 `+columna+`
+			FROM `+tabla+`
+			WHERE `+condicion+`
+			ORDER BY `+columna, params, func(rows *sql.Rows) (int, error) {
+			var compania int
+			return compania, rows.Scan(&compania)
+		})
+		if err != nil {
+			mensaje := "Error al consultar las compañías del periodo"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return "", nil, false
+		}
+		if len(companias) > 1 {
+			respuesta.Error(c, http.StatusConflict, respuesta.Ambiguo,
+				"Hay documentos de varias compañías en el periodo, indique compania")
+			return "", nil, false
+		}
+		// Sin documentos el libro sale vacio
+		if len(companias) == 0 {
+			return condicion, params, true
+		}
+		compania, conCompania = companias[0], true
+	}
+	condicion, params = respuesta.FiltroCompania(condicion, columna, params, compania, conCompania)
+	return condicion, params, true
+}
+
+/*
+Totales de un reporte en la moneda solicitada (query moneda),
+convertidos documento por documento con la tasa de cada uno.
+Las claves son los nombres de los totales en la respuesta.
+Los documentos sin tasa no se suman.
+*/
+type ConversionReporte struct {
+	Moneda            string             `json:"moneda"`
+	Totales           map[string]float64 `json:"totales"`
+	DocumentosSinTasa int                `json:"documentosSinTasa"`
+
+	cv *monedas.Conversion
+}
+
+// Retorna nil si no se pidio conversion
+func nuevaConversionReporte(cv *monedas.Conversion) *ConversionReporte {
+	if cv == nil {
+		return nil
+	}
+	return &ConversionReporte{Moneda: cv.Moneda, Totales: map[string]float64{}, cv: cv}
+}
+
+// Suma a los totales los montos del documento convertidos
+func (cr *ConversionReporte) acumular(doc monedas.Documento, montos map[string]*float64) error {
+	if cr == nil {
+		return nil
+	}
+	resultado, err := cr.cv.Aplicar(doc, montos)
+	if err != nil {
+		return err
+	}
+	if resultado.Montos == nil {
+		cr.DocumentosSinTasa++
+		return nil
+	}
+	for nombre, monto := range resultado.Montos {
+		total := cr.Totales[nombre]
+		sumar(&total, monto)
+		cr.Totales[nombre] = total
+	}
+	return nil
+}
+
+// Redondea los totales a centimos
+func (cr *ConversionReporte) redondear() {
+	if cr == nil {
+		return
+	}
+	for nombre, total := range cr.Totales {
+		cr.Totales[nombre] = redondear(total)
+	}
+}
+
+// Suma un monto opcional
+func sumar(total *float64, monto *float64) {
+	if monto != nil {
+		*total += *monto
+	}
+}
+
+// Multiplica un monto opcional por el signo del documento
+func conSigno(monto *float64, signo float64) *float64 {
+	if monto == nil {
+		return nil
+	}
+	valor := *monto * signo
+	return &valor
+}
@@ -0,0 +1,390 @@
+package reportes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/facturas"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Retenciones de IVA sobre las ventas.
+
+	Los contribuyentes especiales retienen parte del IVA de
+	cada factura y entregan un comprobante. Galac guarda los
+	datos de la retencion en la propia factura (MontoIvaRetenido,
+	NumeroComprobanteRetIVA, FechaComprobanteRetIVA, SeRetuvoIVA)
+	y los comprobantes en dbo.comprobanteRetencionIVA.
+
+	Una factura de un cliente contribuyente especial que no
+	tiene retencion registrada se marca como pendiente, para
+	poder reclamar el comprobante antes del cierre del mes.
+*/
+
+/*
+Valor de Cliente.TipoDeContribuyente de los contribuyentes
+especiales. El esquema de Galac no documenta los codigos del
+campo, asi que la API no asume ninguno: se configura en
+codigos_galac.json o se indica con el query tipoEspecial.
+
+	{"contribuyenteEspecial": "1"}
+*/
+var contribuyenteEspecial string
+
+/*
+Lee el codigo de los contribuyentes especiales desde el
+archivo. Si el archivo no existe o no trae el codigo, el
+reporte de retenciones exige tipoEspecial.
+*/
+func CargarContribuyenteEspecial(ruta string) error {
+	contenido, err := os.ReadFile(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("Sin codigo de contribuyente especial configurado (" + ruta + ")")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var archivo struct {
+		ContribuyenteEspecial string `json:"contribuyenteEspecial"`
+	}
+	if err := json.Unmarshal(contenido, &archivo); err != nil {
+		return err
+	}
+	contribuyenteEspecial = strings.TrimSpace(archivo.ContribuyenteEspecial)
+	if contribuyenteEspecial == "" {
+		log.Println("Sin codigo de contribuyente especial configurado (" + ruta + ")")
+	}
+	return nil
+}
+
+var estadosRetencion = map[string]string{
+	"TODAS":      "TODAS",
+	"RETENIDAS":  "RETENIDAS",
+	"PENDIENTES": "PENDIENTES",
+}
+
+type RetencionFactura struct {
+	Numero                  string     `json:"numero"`
+	Fecha                   time.Time  `json:"fecha"`
+	TipoDeDocumento         string     `json:"tipoDeDocumento"`
+	NumeroControl           *string    `json:"numeroControl"`
+	CodigoCliente           *string    `json:"codigoCliente"`
+	RIF                     *string    `json:"rif"`
+	NombreCliente           *string    `json:"nombreCliente"`
+	TipoDeContribuyente     *string    `json:"tipoDeContribuyente"`
+	TotalIVA                *float64   `json:"totalIva"`
+	TotalFactura            *float64   `json:"totalFactura"`
+	MontoIvaRetenido        *float64   `json:"montoIvaRetenido"`
+	NumeroComprobanteRetIVA *int       `json:"numeroComprobanteRetIva"`
+	FechaComprobanteRetIVA  *time.Time `json:"fechaComprobanteRetIva"`
+	FechaAplicacionRetIVA   *time.Time `json:"fechaAplicacionRetIva"`
+	SeRetuvoIVA             bool       `json:"seRetuvoIva"`
+	ContribuyenteEspecial   bool       `json:"contribuyenteEspecial"`
+	RetencionPendiente      bool       `json:"retencionPendiente"`
+
+	// Montos convertidos con el query moneda (ver monedas.Conversion)
+	Conversion *monedas.Resultado `json:"conversion,omitempty"`
+}
+
+type ComprobanteRetencionIVA struct {
+	Numero                  string     `json:"numero"`
+	Fecha                   time.Time  `json:"fecha"`
+	NombreOperador          *string    `json:"nombreOperador"`
+	FechaUltimaModificacion *time.Time `json:"fechaUltimaModificacion"`
+}
+
+func RetencionesIVA(api *gin.RouterGroup, db *sql.DB) {
+	api.GET("/", cache.Respuestas(db, ttlCacheReportes, "factura", "Cliente"), retencionesIVA(db))
+	api.GET("/comprobantes", cache.Respuestas(db, ttlCacheReportes, "comprobanteRetencionIVA", "factura"), comprobantesRetencionIVA(db))
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// La retencion esta registrada si SeRetuvoIVA = 'S' o hay monto retenido
+const condicionRetenida = "(f.SeRetuvoIVA = 'S' OR ISNULL(f.MontoIvaRetenido, 0) <> 0)"
+
+/*
+Factura o nota de debito con IVA de un contribuyente especial
+sin retencion registrada. Las notas de credito no se retienen.
+*/
+const condicionPendiente = "(f.TipoDeDocumento IN (@tipoFactura, @tipoNotaDebito) AND cl.TipoDeContribuyente = @especial" +
+	" AND ISNULL(f.TotalIVA, 0) <> 0 AND NOT " + condicionRetenida + ")"
+
+// Tipos de documento a los que se les retiene el IVA
+func retenible(tipoDocumento string) bool {
+	return tipoDocumento == facturas.TipoDocumentoFactura || tipoDocumento == facturas.TipoDocumentoNotaDebito
+}
+
+/*
+Facturas con retencion de IVA o pendientes de retencion.
+Querys:
+
+mes y anio, o desde y hasta (AAAA-MM-DD): periodo de la factura
+codigoCliente: codigo del cliente en Galac
+estado: TODAS (por defecto), RETENIDAS o PENDIENTES
+tipoEspecial: TipoDeContribuyente de los especiales (ver codigos_galac.json)
+page, pageSize: paginacion, por defecto 1 y 1000
+moneda, fechaCambio: agrega a cada factura sus montos convertidos
+
+Los borradores y las facturas anuladas no se incluyen.
+*/
+func retencionesIVA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando consulta de retenciones de IVA", nil)
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		desde, hasta := leerPeriodo(c, &v)
+		codigoCliente := strings.TrimSpace(c.Query("codigoCliente"))
+		estado := strings.ToUpper(c.DefaultQuery("estado", "TODAS"))
+		tipoEspecial := strings.TrimSpace(c.DefaultQuery("tipoEspecial", contribuyenteEspecial))
+		if tipoEspecial == "" {
+			v.Agregar("tipoEspecial", "Indique el TipoDeContribuyente de los contribuyentes especiales (no está configurado en codigos_galac.json)")
+		}
+		v.Opcion(estado, "estado", estadosRetencion, "Estado inválido. Use: TODAS, RETENIDAS o PENDIENTES")
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize := v.Positivo(c.Query("pageSize"), "pageSize", 1000)
+		conversion := monedas.LeerConversion(c, &v)
+
+		if !v.Valido() {
+			logError(requestID+" - Parámetros de retenciones inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if !prepararConversion(c, db, conversion, requestID) {
+			return
+		}
+
+		// ----- Filtros ----- //
+		filtros := map[string]interface{}{"estado": estado, "tipoEspecial": tipoEspecial}
+		condicion := `
+			FROM dbo.factura f
+			LEFT JOIN dbo.Cliente cl
+				ON cl.ConsecutivoCompania = f.ConsecutivoCompania AND cl.Codigo = f.CodigoCliente
+			WHERE f.StatusFactura <> @borrador
+			  AND ISNULL(f.Cancelada, 'N') <> 'S'
+		`
+		params := []interface{}{
+			sql.Named("borrador", facturas.StatusBorrador),
+			sql.Named("especial", tipoEspecial),
+			sql.Named("tipoFactura", facturas.TipoDocumentoFactura),
+			sql.Named("tipoNotaDebito", facturas.TipoDocumentoNotaDebito),
+		}
+
+		switch estado {
+		case "RETENIDAS":
+			condicion += " AND " + condicionRetenida
+		case "PENDIENTES":
+			condicion += " AND " + condicionPendiente
+		default:
+			condicion += " AND (" + condicionRetenida + " OR " + condicionPendiente + ")"
+		}
+
+		if desde != nil {
+			condicion += " AND f.Fecha >= @desde"
+			params = append(params, sql.Named("desde", *desde))
+			filtros["desde"] = desde.Format("2006-01-02")
+		}
+		if hasta != nil {
+			condicion += " AND f.Fecha < @hasta"
+			params = append(params, sql.Named("hasta", *hasta))
+			filtros["hasta"] = hasta.AddDate(0, 0, -1).Format("2006-01-02")
+		}
+		if codigoCliente != "" {
+			condicion += " AND f.CodigoCliente = @codigoCliente"
+			params = append(params, sql.Named("codigoCliente", codigoCliente))
+			filtros["codigoCliente"] = codigoCliente
+		}
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) "+condicion, params...).Scan(&total); err != nil {
+			mensaje := "Error al contar las retenciones de IVA"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron retenciones de IVA con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT
+				f.Numero, f.Fecha, f.TipoDeDocumento, f.NumeroControl, f.CodigoCliente,
+				cl.NumeroRIF, cl.Nombre, cl.TipoDeContribuyente, f.TotalIVA, f.TotalFactura,
+				f.MontoIvaRetenido, f.NumeroComprobanteRetIVA, f.FechaComprobanteRetIVA,
+				f.FechaAplicacionRetIVA, f.SeRetuvoIVA, ` + monedas.ColumnasDocumento("f.") + `
+			` + condicion + `
+			ORDER BY f.Fecha, f.Numero
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar las retenciones de IVA"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		retenciones := []RetencionFactura{}
+		pendientes := 0
+		for rows.Next() {
+			var r RetencionFactura
+			var seRetuvo *string
+			var doc monedas.Documento
+			err := rows.Scan(append([]interface{}{
+				&r.Numero, &r.Fecha, &r.TipoDeDocumento, &r.NumeroControl, &r.CodigoCliente,
+				&r.RIF, &r.NombreCliente, &r.TipoDeContribuyente, &r.TotalIVA, &r.TotalFactura,
+				&r.MontoIvaRetenido, &r.NumeroComprobanteRetIVA, &r.FechaComprobanteRetIVA,
+				&r.FechaAplicacionRetIVA, &seRetuvo,
+			}, doc.Destinos()...)...)
+			if err != nil {
+				mensaje := "Error al leer datos de retenciones de IVA"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+
+			r.SeRetuvoIVA = (seRetuvo != nil && *seRetuvo == "S") ||
+				(r.MontoIvaRetenido != nil && *r.MontoIvaRetenido != 0)
+			r.ContribuyenteEspecial = r.TipoDeContribuyente != nil && *r.TipoDeContribuyente == tipoEspecial
+			r.RetencionPendiente = r.ContribuyenteEspecial && !r.SeRetuvoIVA && retenible(r.TipoDeDocumento) &&
+				r.TotalIVA != nil && *r.TotalIVA != 0
+			if r.RetencionPendiente {
+				pendientes++
+			}
+
+			if conversion != nil {
+				doc.Fecha = r.Fecha
+				r.Conversion, err = conversion.Aplicar(doc, map[string]*float64{
+					"totalIva":         r.TotalIVA,
+					"totalFactura":     r.TotalFactura,
+					"montoIvaRetenido": r.MontoIvaRetenido,
+				})
+				if err != nil {
+					mensaje := "Error al convertir los montos de las retenciones de IVA"
+					logError(requestID+" - "+mensaje, err)
+					respuesta.ErrorBD(c, mensaje)
+					return
+				}
+			}
+
+			retenciones = append(retenciones, r)
+		}
+
+		logError(requestID+" - Retenciones de IVA: "+strconv.Itoa(len(retenciones))+
+			" facturas, "+strconv.Itoa(pendientes)+" pendientes en la página", nil)
+
+		respuesta.Pagina(c, "Retenciones de IVA obtenidas", retenciones, len(retenciones),
+			respuesta.NuevaPaginacion(total, page, pageSize), filtros)
+	}
+}
+
+/*
+Comprobantes de retencion de IVA registrados en Galac.
+Querys:
+
+mes y anio, o desde y hasta (AAAA-MM-DD): fecha del comprobante
+numero: numero exacto del comprobante
+codigoCliente: solo los comprobantes registrados en facturas del cliente
+
+El comprobante no guarda el cliente; se relaciona con el
+cliente por las facturas que tienen su numero en
+NumeroComprobanteRetIVA.
+*/
+func comprobantesRetencionIVA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		desde, hasta := leerPeriodo(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		query := `
+			SELECT cr.Numero, cr.Fecha, cr.NombreOperador, cr.FechaUltimaModificacion
+			FROM dbo.comprobanteRetencionIVA cr
+			WHERE 1=1
+		`
+		params := []interface{}{}
+		if desde != nil {
+			query += " AND cr.Fecha >= @desde"
+			params = append(params, sql.Named("desde", *desde))
+		}
+		if hasta != nil {
+			query += " AND cr.Fecha < @hasta"
+			params = append(params, sql.Named("hasta", *hasta))
+		}
+		if numero := strings.TrimSpace(c.Query("numero")); numero != "" {
+			query += " AND cr.Numero = @numero"
+			params = append(params, sql.Named("numero", numero))
+		}
+		if codigoCliente := strings.TrimSpace(c.Query("codigoCliente")); codigoCliente != "" {
+			query += `
+			  AND EXISTS (
+				SELECT 1 FROM dbo.factura f
+				WHERE f.ConsecutivoCompania = cr.ConsecutivoCompania
+				  AND f.NumeroComprobanteRetIVA = TRY_CAST(cr.Numero AS int)
+				  AND f.CodigoCliente = @codigoCliente)`
+			params = append(params, sql.Named("codigoCliente", codigoCliente))
+		}
+		query += " ORDER BY cr.Fecha, cr.Numero"
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar los comprobantes de retención de IVA"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		comprobantes := []ComprobanteRetencionIVA{}
+		for rows.Next() {
+			var comp ComprobanteRetencionIVA
+			if err := rows.Scan(&comp.Numero, &comp.Fecha, &comp.NombreOperador, &comp.FechaUltimaModificacion); err != nil {
+				mensaje := "Error al leer datos de comprobantes de retención de IVA"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			comprobantes = append(comprobantes, comp)
+		}
+
+		if len(comprobantes) == 0 {
+			respuesta.SinResultados(c, "No se encontraron comprobantes de retención de IVA")
+			return
+		}
+
+		respuesta.Exito(c, "Comprobantes de retención de IVA obtenidos", comprobantes, len(comprobantes))
+	}
+}
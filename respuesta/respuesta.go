@@ -0,0 +1,298 @@
+package respuesta
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Formato comun de respuesta para todos los modulos de la API.
+
+	Toda respuesta JSON tiene la forma:
+
+	{
+	  "success": true,
+	  "statusCode": 200,
+	  "message": "...",
+	  "data": ...,
+	  "count": 10,
+	  ...paginacion (solo en busquedas paginadas)
+	  "filtros": {...},
+	  "error": { "codigo": "...", "detalles": [...] }   (solo en errores)
+	}
+
+	Los errores de SQL Server nunca se envian al cliente,
+	solo se registran en el log de cada modulo.
+*/
+
+// Codigo de error tipado que se envia al cliente
+type Codigo string
+
+const (
+	ParametroInvalido Codigo = "PARAMETRO_INVALIDO"
+	ParametroFaltante Codigo = "PARAMETRO_FALTANTE"
+	NoEncontrado      Codigo = "NO_ENCONTRADO"
+	Ambiguo           Codigo = "AMBIGUO" // hay datos de varias companias y falta compania
+	ErrorBaseDatos    Codigo = "ERROR_BASE_DATOS"
+	ErrorInterno      Codigo = "ERROR_INTERNO"
+)
+
+// Error de validacion de un parametro de la peticion
+type ErrorParametro struct {
+	Parametro string `json:"parametro"`
+	Mensaje   string `json:"mensaje"`
+}
+
+type DetalleError struct {
+	Codigo   Codigo           `json:"codigo"`
+	Detalles []ErrorParametro `json:"detalles,omitempty"`
+}
+
+// Datos de paginacion, se serializan en el primer nivel de la respuesta
+type Paginacion struct {
+	Total       int  `json:"total"`
+	Page        int  `json:"page"`
+	PageSize    int  `json:"pageSize"`
+	TotalPages  int  `json:"totalPages"`
+	IsFirstPage bool `json:"isFirstPage"`
+	IsLastPage  bool `json:"isLastPage"`
+	HasNextPage bool `json:"hasNextPage"`
+	HasPrevPage bool `json:"hasPrevPage"`
+}
+
+type Respuesta struct {
+	Success    bool        `json:"success"`
+	StatusCode int         `json:"statusCode"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data,omitempty"`
+	Count      *int        `json:"count,omitempty"`
+	*Paginacion
+	Filtros map[string]interface{} `json:"filtros,omitempty"`
+	Error   *DetalleError          `json:"error,omitempty"`
+}
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+func NuevaPaginacion(total, page, pageSize int) *Paginacion {
+	totalPages := (total + pageSize - 1) / pageSize
+	return &Paginacion{
+		Total:       total,
+		Page:        page,
+		PageSize:    pageSize,
+		TotalPages:  totalPages,
+		IsFirstPage: page == 1,
+		IsLastPage:  page == totalPages,
+		HasNextPage: page < totalPages,
+		HasPrevPage: page > 1,
+	}
+}
+
+// Respuesta exitosa con una lista o un objeto
+func Exito(c *gin.Context, mensaje string, data interface{}, count int) {
+	c.JSON(http.StatusOK, Respuesta{
+		Success:    true,
+		StatusCode: http.StatusOK,
+		Message:    mensaje,
+		Data:       data,
+		Count:      &count,
+	})
+}
+
+// Respuesta exitosa de una busqueda paginada
+func Pagina(c *gin.Context, mensaje string, data interface{}, count int, paginacion *Paginacion, filtros map[string]interface{}) {
+	if len(filtros) == 0 {
+		filtros = nil
+	}
+	c.JSON(http.StatusOK, Respuesta{
+		Success:    true,
+		StatusCode: http.StatusOK,
+		Message:    mensaje,
+		Data:       data,
+		Count:      &count,
+		Paginacion: paginacion,
+		Filtros:    filtros,
+	})
+}
+
+/*
+Respuesta de una busqueda sin coincidencias.
+Se mantiene el status 200 (como siempre lo hizo la API)
+pero con success en false y el codigo NO_ENCONTRADO.
+*/
+func SinResultados(c *gin.Context, mensaje string) {
+	count := 0
+	c.JSON(http.StatusOK, Respuesta{
+		Success:    false,
+		StatusCode: http.StatusOK,
+		Message:    mensaje,
+		Data:       []interface{}{},
+		Count:      &count,
+		Error:      &DetalleError{Codigo: NoEncontrado},
+	})
+}
+
+// Respuesta de error generica
+func Error(c *gin.Context, status int, codigo Codigo, mensaje string) {
+	c.AbortWithStatusJSON(status, Respuesta{
+		Success:    false,
+		StatusCode: status,
+		Message:    mensaje,
+		Error:      &DetalleError{Codigo: codigo},
+	})
+}
+
+// Error al consultar la BD, el detalle solo va al log
+func ErrorBD(c *gin.Context, mensaje string) {
+	Error(c, http.StatusInternalServerError, ErrorBaseDatos, mensaje)
+}
+
+// Error 400 con la lista de todos los parametros invalidos
+func ErrorValidacion(c *gin.Context, errores []ErrorParametro) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, Respuesta{
+		Success:    false,
+		StatusCode: http.StatusBadRequest,
+		Message:    "Parámetros inválidos",
+		Error:      &DetalleError{Codigo: ParametroInvalido, Detalles: errores},
+	})
+}
+
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+/////////////////////////////////////////////////////
+
+/*
+	Validador acumula los errores de todos los parametros de
+	una peticion, para responder un unico 400 con la lista
+	completa en lugar de fallar en el primer parametro.
+*/
+
+type Validador struct {
+	errores []ErrorParametro
+}
+
+func (v *Validador) Agregar(parametro, mensaje string) {
+	v.errores = append(v.errores, ErrorParametro{Parametro: parametro, Mensaje: mensaje})
+}
+
+func (v *Validador) Valido() bool {
+	return len(v.errores) == 0
+}
+
+func (v *Validador) Errores() []ErrorParametro {
+	return v.errores
+}
+
+/*
+Lee un entero opcional entre min y max.
+Si el valor viene vacio retorna (0, false) sin error.
+*/
+func (v *Validador) Entero(valor, parametro string, min, max int) (int, bool) {
+	if valor == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(valor))
+	if err != nil || n < min || n > max {
+		v.Agregar(parametro, "Debe ser un número entre "+strconv.Itoa(min)+" y "+strconv.Itoa(max))
+		return 0, false
+	}
+	return n, true
+}
+
+// Lee un entero opcional mayor o igual a 1, con valor por defecto
+func (v *Validador) Positivo(valor, parametro string, porDefecto int) int {
+	if valor == "" {
+		return porDefecto
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(valor))
+	if err != nil || n < 1 {
+		v.Agregar(parametro, "Debe ser un número mayor o igual a 1")
+		return porDefecto
+	}
+	return n
+}
+
+/*
+Lee una fecha opcional con formato AAAA-MM-DD.
+Si el valor viene vacio retorna nil sin error.
+*/
+func (v *Validador) Fecha(valor, parametro string) *time.Time {
+	if valor == "" {
+		return nil
+	}
+	fecha, err := time.Parse("2006-01-02", strings.TrimSpace(valor))
+	if err != nil {
+		v.Agregar(parametro, "Fecha inválida, use el formato AAAA-MM-DD")
+		return nil
+	}
+	return &fecha
+}
+
+// Mes de un reporte, con el rango de fechas [Desde, Hasta)
+type Periodo struct {
+	Mes   int
+	Anio  int
+	Desde time.Time
+	Hasta time.Time
+}
+
+/*
+Lee el mes y el año obligatorios de un reporte mensual.
+Retorna false si falta alguno o si no es valido.
+*/
+func (v *Validador) MesAnio(mes, anio string) (Periodo, bool) {
+	if mes == "" {
+		v.Agregar("mes", "El mes es obligatorio")
+	}
+	if anio == "" {
+		v.Agregar("anio", "El año es obligatorio")
+	}
+	m, okMes := v.Entero(mes, "mes", 1, 12)
+	a, okAnio := v.Entero(anio, "anio", 1900, 2100)
+	if !okMes || !okAnio {
+		return Periodo{}, false
+	}
+
+	desde := time.Date(a, time.Month(m), 1, 0, 0, 0, 0, time.UTC)
+	return Periodo{Mes: m, Anio: a, Desde: desde, Hasta: desde.AddDate(0, 1, 0)}, true
+}
+
+/*
+Lee un rango de fechas opcional (AAAA-MM-DD), ambos extremos
+incluidos. Retorna el rango como [desde, hasta) para usarlo
+en SQL con Fecha >= @desde AND Fecha < @hasta.
+*/
+func (v *Validador) RangoFechas(desde, hasta string) (*time.Time, *time.Time) {
+	d := v.Fecha(desde, "desde")
+	h := v.Fecha(hasta, "hasta")
+	if d != nil && h != nil && h.Before(*d) {
+		v.Agregar("hasta", "La fecha hasta no puede ser anterior a la fecha desde")
+	}
+	if h != nil {
+		siguiente := h.AddDate(0, 0, 1)
+		h = &siguiente
+	}
+	return d, h
+}
+
+/*
+Verifica que el valor (si viene) este entre las opciones y
+retorna el codigo asociado. El mensaje se envia al cliente
+si el valor no es valido.
+*/
+func (v *Validador) Opcion(valor, parametro string, opciones map[string]string, mensaje string) (string, bool) {
+	if valor == "" {
+		return "", false
+	}
+	codigo, ok := opciones[valor]
+	if !ok {
+		v.Agregar(parametro, mensaje)
+		return "", false
+	}
+	return codigo, true
+}
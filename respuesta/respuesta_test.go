@@ -0,0 +1,202 @@
+package respuesta
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Parametros que quedaron con error en el validador
+func parametros(v *Validador) []string {
+	var lista []string
+	for _, e := range v.Errores() {
+		lista = append(lista, e.Parametro)
+	}
+	return lista
+}
+
+func fecha(texto string) *time.Time {
+	f, _ := time.Parse("2006-01-02", texto)
+	return &f
+}
+
+func TestEntero(t *testing.T) {
+	casos := []struct {
+		valor    string
+		esperado int
+		ok       bool
+		errores  []string
+	}{
+		{"", 0, false, nil},
+		{"5", 5, true, nil},
+		{" 12 ", 12, true, nil},
+		{"1", 1, true, nil},
+		{"0", 0, false, []string{"mes"}},
+		{"13", 0, false, []string{"mes"}},
+		{"abc", 0, false, []string{"mes"}},
+		{"5.5", 0, false, []string{"mes"}},
+	}
+
+	for _, caso := range casos {
+		var v Validador
+		n, ok := v.Entero(caso.valor, "mes", 1, 12)
+		if n != caso.esperado || ok != caso.ok || !reflect.DeepEqual(parametros(&v), caso.errores) {
+			t.Errorf("Entero(%q) = %d, %v, errores %v; se esperaba %d, %v, errores %v",
+				caso.valor, n, ok, parametros(&v), caso.esperado, caso.ok, caso.errores)
+		}
+	}
+}
+
+func TestPositivo(t *testing.T) {
+	casos := []struct {
+		valor    string
+		esperado int
+		valido   bool
+	}{
+		{"", 100, true},
+		{"1", 1, true},
+		{"250", 250, true},
+		{"0", 100, false},
+		{"-3", 100, false},
+		{"diez", 100, false},
+	}
+
+	for _, caso := range casos {
+		var v Validador
+		n := v.Positivo(caso.valor, "pageSize", 100)
+		if n != caso.esperado || v.Valido() != caso.valido {
+			t.Errorf("Positivo(%q) = %d, valido %v; se esperaba %d, valido %v", caso.valor, n, v.Valido(), caso.esperado, caso.valido)
+		}
+	}
+}
+
+func TestFecha(t *testing.T) {
+	casos := []struct {
+		valor    string
+		esperado *time.Time
+		valido   bool
+	}{
+		{"", nil, true},
+		{"2025-04-30", fecha("2025-04-30"), true},
+		{" 2025-01-01 ", fecha("2025-01-01"), true},
+		{"2025-02-30", nil, false},
+		{"30/04/2025", nil, false},
+	}
+
+	for _, caso := range casos {
+		var v Validador
+		f := v.Fecha(caso.valor, "desde")
+		if !reflect.DeepEqual(f, caso.esperado) || v.Valido() != caso.valido {
+			t.Errorf("Fecha(%q) = %v, valido %v; se esperaba %v, valido %v", caso.valor, f, v.Valido(), caso.esperado, caso.valido)
+		}
+	}
+}
+
+func TestMesAnio(t *testing.T) {
+	casos := []struct {
+		nombre  string
+		mes     string
+		anio    string
+		periodo Periodo
+		ok      bool
+		errores []string
+	}{
+		{"mes normal", "4", "2025", Periodo{Mes: 4, Anio: 2025, Desde: *fecha("2025-04-01"), Hasta: *fecha("2025-05-01")}, true, nil},
+		{"diciembre pasa al año siguiente", "12", "2024", Periodo{Mes: 12, Anio: 2024, Desde: *fecha("2024-12-01"), Hasta: *fecha("2025-01-01")}, true, nil},
+		{"sin mes", "", "2025", Periodo{}, false, []string{"mes"}},
+		{"sin año", "4", "", Periodo{}, false, []string{"anio"}},
+		{"sin ambos", "", "", Periodo{}, false, []string{"mes", "anio"}},
+		{"mes invalido", "13", "2025", Periodo{}, false, []string{"mes"}},
+		{"año invalido", "4", "1800", Periodo{}, false, []string{"anio"}},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			var v Validador
+			periodo, ok := v.MesAnio(caso.mes, caso.anio)
+			if periodo != caso.periodo || ok != caso.ok {
+				t.Errorf("MesAnio = %+v, %v; se esperaba %+v, %v", periodo, ok, caso.periodo, caso.ok)
+			}
+			if !reflect.DeepEqual(parametros(&v), caso.errores) {
+				t.Errorf("errores = %v, se esperaba %v", parametros(&v), caso.errores)
+			}
+		})
+	}
+}
+
+func TestRangoFechas(t *testing.T) {
+	casos := []struct {
+		nombre  string
+		desde   string
+		hasta   string
+		d       *time.Time
+		h       *time.Time
+		errores []string
+	}{
+		{"sin rango", "", "", nil, nil, nil},
+		{"hasta incluye el dia", "2025-04-01", "2025-04-30", fecha("2025-04-01"), fecha("2025-05-01"), nil},
+		{"mismo dia", "2025-04-15", "2025-04-15", fecha("2025-04-15"), fecha("2025-04-16"), nil},
+		{"solo desde", "2025-04-01", "", fecha("2025-04-01"), nil, nil},
+		{"hasta antes de desde", "2025-04-30", "2025-04-01", fecha("2025-04-30"), fecha("2025-04-02"), []string{"hasta"}},
+		{"fecha invalida", "2025-13-01", "", nil, nil, []string{"desde"}},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			var v Validador
+			d, h := v.RangoFechas(caso.desde, caso.hasta)
+			if !reflect.DeepEqual(d, caso.d) || !reflect.DeepEqual(h, caso.h) {
+				t.Errorf("RangoFechas = %v, %v; se esperaba %v, %v", d, h, caso.d, caso.h)
+			}
+			if !reflect.DeepEqual(parametros(&v), caso.errores) {
+				t.Errorf("errores = %v, se esperaba %v", parametros(&v), caso.errores)
+			}
+		})
+	}
+}
+
+func TestOpcion(t *testing.T) {
+	opciones := map[string]string{"emitida": "0", "anulada": "1"}
+
+	casos := []struct {
+		valor    string
+		esperado string
+		ok       bool
+		valido   bool
+	}{
+		{"", "", false, true},
+		{"emitida", "0", true, true},
+		{"anulada", "1", true, true},
+		{"Emitida", "", false, false},
+		{"pagada", "", false, false},
+	}
+
+	for _, caso := range casos {
+		var v Validador
+		codigo, ok := v.Opcion(caso.valor, "estado", opciones, "Estado inválido")
+		if codigo != caso.esperado || ok != caso.ok || v.Valido() != caso.valido {
+			t.Errorf("Opcion(%q) = %q, %v, valido %v; se esperaba %q, %v, valido %v",
+				caso.valor, codigo, ok, v.Valido(), caso.esperado, caso.ok, caso.valido)
+		}
+	}
+}
+
+func TestNuevaPaginacion(t *testing.T) {
+	casos := []struct {
+		total, page, pageSize int
+		esperado              Paginacion
+	}{
+		{250, 1, 100, Paginacion{Total: 250, Page: 1, PageSize: 100, TotalPages: 3, IsFirstPage: true, HasNextPage: true}},
+		{250, 2, 100, Paginacion{Total: 250, Page: 2, PageSize: 100, TotalPages: 3, HasNextPage: true, HasPrevPage: true}},
+		{250, 3, 100, Paginacion{Total: 250, Page: 3, PageSize: 100, TotalPages: 3, IsLastPage: true, HasPrevPage: true}},
+		{100, 1, 100, Paginacion{Total: 100, Page: 1, PageSize: 100, TotalPages: 1, IsFirstPage: true, IsLastPage: true}},
+		{0, 1, 100, Paginacion{Total: 0, Page: 1, PageSize: 100, TotalPages: 0, IsFirstPage: true}},
+	}
+
+	for _, caso := range casos {
+		p := NuevaPaginacion(caso.total, caso.page, caso.pageSize)
+		if *p != caso.esperado {
+			t.Errorf("NuevaPaginacion(%d, %d, %d) = %+v, se esperaba %+v", caso.total, caso.page, caso.pageSize, *p, caso.esperado)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package respuesta
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Busqueda de un documento por su numero.
+
+	El numero de un documento solo es unico dentro de una
+	compania. Si la peticion trae compania se busca en esa;
+	si no, se leen hasta dos filas para saber si el numero
+	existe en mas de una y en ese caso se responde 409 con
+	el codigo AMBIGUO en lugar de elegir una compania.
+*/
+
+/*
+Agrega a la condicion el filtro por compania, si se indico.
+columna es la columna de la compania con su alias.
+*/
+func FiltroCompania(condicion, columna string, params []interface{}, compania int, conCompania bool) (string, []interface{}) {
+	if conCompania {
+		condicion += " AND " + columna + " = @compania"
+		params = append(params, sql.Named("compania", compania))
+	}
+	return condicion, params
+}
+
+/*
+Ejecuta la consulta y escanea a lo sumo dos filas. La
+consulta debe usar TOP 2 y un ORDER BY estable.
+*/
+func LeerUnico[T any](db *sql.DB, query string, params []interface{}, escanear func(*sql.Rows) (T, error)) ([]T, error) {
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	encontrados := []T{}
+	for rows.Next() && len(encontrados) < 2 {
+		valor, err := escanear(rows)
+		if err != nil {
+			return nil, err
+		}
+		encontrados = append(encontrados, valor)
+	}
+	return encontrados, rows.Err()
+}
+
+/*
+Responde 404 si no se encontro el documento y 409 si esta
+en varias companias. documento va en el mensaje, por
+ejemplo "la factura 123". Retorna false si ya respondio.
+*/
+func Unico[T any](c *gin.Context, encontrados []T, documento string) (T, bool) {
+	var cero T
+	switch len(encontrados) {
+	case 0:
+		Error(c, http.StatusNotFound, NoEncontrado, "No se encontró "+documento)
+		return cero, false
+	case 1:
+		return encontrados[0], true
+	default:
+		Error(c, http.StatusConflict, Ambiguo, "Se encontró "+documento+" en varias compañías, indique compania")
+		return cero, false
+	}
+}
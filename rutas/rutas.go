@@ -0,0 +1,88 @@
+package rutas
+
+import (
+	"database/sql"
+
+	clientes "github.com/desarrolladoresnet/api_galac_bd/cliente"
+	"github.com/desarrolladoresnet/api_galac_bd/compras"
+	"github.com/desarrolladoresnet/api_galac_bd/docs"
+	"github.com/desarrolladoresnet/api_galac_bd/facturas"
+	"github.com/desarrolladoresnet/api_galac_bd/inventario"
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/reportes"
+	"github.com/desarrolladoresnet/api_galac_bd/tesoreria"
+	"github.com/desarrolladoresnet/api_galac_bd/ventas"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Registro de todas las rutas de la API.
+
+	Esta separado de main para que las pruebas puedan armar
+	el mismo router (con db nil) y verificar que todas las
+	rutas esten documentadas en openapi.json.
+*/
+
+func Registrar(router *gin.Engine, db *sql.DB) {
+	// Ruta básica
+	router.GET("/ping", func(c *gin.Context) {
+		c.String(200, "Hello World")
+	})
+
+	// Rutas para obtencion de facturas
+	api_facturas := router.Group("facturas")
+	facturas.Facturas(api_facturas, db)
+
+	// Rutas para consultar facturas por suscripcion de Odoo
+	api_suscripciones := router.Group("suscripciones")
+	facturas.Suscripciones(api_suscripciones, db)
+
+	// Reportes fiscales (Libro de Ventas, etc.)
+	api_reportes := router.Group("reportes")
+	reportes.ReporteRoutes(api_reportes, db)
+
+	// Retenciones de IVA de las ventas
+	api_retenciones := router.Group("retenciones-iva")
+	reportes.RetencionesIVA(api_retenciones, db)
+
+	// Monedas e historial de tasas de cambio
+	api_monedas := router.Group("monedas")
+	monedas.MonedaRoutes(api_monedas, db)
+
+	// Catalogo de articulos de inventario
+	api_articulos := router.Group("articulos")
+	inventario.ArticuloRoutes(api_articulos, db)
+
+	// Consulta masiva de existencias por almacen
+	api_existencias := router.Group("existencias")
+	inventario.ExistenciaRoutes(api_existencias, db)
+
+	// Cuentas por pagar y pagos a proveedores
+	api_cxp := router.Group("cxp")
+	compras.CxpRoutes(api_cxp, db)
+	api_pagos := router.Group("pagos")
+	compras.PagoRoutes(api_pagos, db)
+
+	// Cotizaciones y su conversion a facturas
+	api_cotizaciones := router.Group("cotizaciones")
+	ventas.CotizacionRoutes(api_cotizaciones, db)
+
+	// Contratos de servicio y meses pendientes de facturar
+	api_contratos := router.Group("contratos")
+	ventas.ContratoRoutes(api_contratos, db)
+
+	// Anticipos de clientes y a proveedores
+	api_anticipos := router.Group("anticipos")
+	tesoreria.AnticipoRoutes(api_anticipos, db)
+
+	// Movimientos bancarios y conciliaciones
+	api_bancos := router.Group("bancos")
+	tesoreria.BancoRoutes(api_bancos, db)
+
+	// Rutas para obtencion de clientes
+	api_clientes := router.Group("clientes")
+	clientes.ClienteRoutes(api_clientes, db)
+
+	// Especificacion OpenAPI y explorador de la API
+	docs.DocsRoutes(router)
+}
@@ -0,0 +1,391 @@
+package tesoreria
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Anticipos (dbo.anticipo). Los de clientes traen
+	CodigoCliente y se usan en cobranzas (dbo.anticipoCobrado);
+	los de proveedores traen CodigoProveedor y se usan en
+	pagos (dbo.anticipoPagado).
+
+	El saldo disponible es MontoTotal menos lo usado y lo
+	devuelto. Un anticipo con FechaAnulacion no tiene saldo.
+*/
+
+// Saldo disponible de un anticipo (sin alias)
+const saldoAnticipoSQL = `(CASE WHEN FechaAnulacion IS NULL
+	THEN ISNULL(MontoTotal, 0) - ISNULL(MontoUsado, 0) - ISNULL(MontoDevuelto, 0)
+	ELSE 0 END)`
+
+// Saldos menores a medio centimo se consideran usados
+const toleranciaSaldo = 0.005
+
+// Uso de un anticipo en una cobranza o en un pago
+type AplicacionAnticipo struct {
+	Origen             string     `json:"origen"`    // COBRANZA o PAGO
+	Documento          string     `json:"documento"` // Numero de cobranza o de comprobante
+	Fecha              *time.Time `json:"fecha"`
+	Status             *string    `json:"status"`
+	MontoAplicado      *float64   `json:"montoAplicado"`
+	MontoRestanteAlDia *float64   `json:"montoRestanteAlDia"`
+	CodigoMoneda       *string    `json:"codigoMoneda"`
+	Cambio             *float64   `json:"cambio"`
+}
+
+type Anticipo struct {
+	ConsecutivoCompania  int        `json:"consecutivoCompania"`
+	ConsecutivoAnticipo  int        `json:"consecutivoAnticipo"`
+	Numero               *string    `json:"numero"`
+	Status               *string    `json:"status"`
+	Tipo                 *string    `json:"tipo"`
+	Fecha                *time.Time `json:"fecha"`
+	CodigoCliente        *string    `json:"codigoCliente"`
+	CodigoProveedor      *string    `json:"codigoProveedor"`
+	NombreBeneficiario   *string    `json:"nombreBeneficiario"`
+	Descripcion          *string    `json:"descripcion"`
+	CodigoMoneda         string     `json:"codigoMoneda"`
+	Cambio               *float64   `json:"cambio"`
+	CodigoCuentaBancaria *string    `json:"codigoCuentaBancaria"`
+	NumeroCheque         *string    `json:"numeroCheque"`
+	NumeroCotizacion     *string    `json:"numeroCotizacion"`
+	FechaAnulacion       *time.Time `json:"fechaAnulacion"`
+	FechaCancelacion     *time.Time `json:"fechaCancelacion"`
+	FechaDevolucion      *time.Time `json:"fechaDevolucion"`
+	MontoTotal           *float64   `json:"montoTotal"`
+	MontoUsado           *float64   `json:"montoUsado"`
+	MontoDevuelto        *float64   `json:"montoDevuelto"`
+	Saldo                float64    `json:"saldo"`
+
+	Aplicaciones []AplicacionAnticipo `json:"aplicaciones"`
+}
+
+// Saldo disponible de un cliente en una moneda
+type SaldoAnticiposCliente struct {
+	CodigoCliente string  `json:"codigoCliente"`
+	CodigoMoneda  string  `json:"codigoMoneda"`
+	Anticipos     int     `json:"anticipos"` // Anticipos con saldo
+	MontoTotal    float64 `json:"montoTotal"`
+	MontoUsado    float64 `json:"montoUsado"`
+	MontoDevuelto float64 `json:"montoDevuelto"`
+	Saldo         float64 `json:"saldo"`
+}
+
+const columnasAnticipo = `
+	ConsecutivoCompania, ConsecutivoAnticipo, Numero, Status, Tipo, Fecha, CodigoCliente, CodigoProveedor,
+	NombreBeneficiario, Descripcion, CodigoMoneda, Cambio, CodigoCuentaBancaria, NumeroCheque,
+	NumeroCotizacion, FechaAnulacion, FechaCancelacion, FechaDevolucion, MontoTotal, MontoUsado,
+	MontoDevuelto, ` + saldoAnticipoSQL
+
+type escaner interface {
+	Scan(dest ...interface{}) error
+}
+
+func escanearAnticipo(row escaner) (Anticipo, error) {
+	var a Anticipo
+	err := row.Scan(
+		&a.ConsecutivoCompania, &a.ConsecutivoAnticipo, &a.Numero, &a.Status, &a.Tipo, &a.Fecha, &a.CodigoCliente,
+		&a.CodigoProveedor, &a.NombreBeneficiario, &a.Descripcion, &a.CodigoMoneda, &a.Cambio,
+		&a.CodigoCuentaBancaria, &a.NumeroCheque, &a.NumeroCotizacion, &a.FechaAnulacion, &a.FechaCancelacion,
+		&a.FechaDevolucion, &a.MontoTotal, &a.MontoUsado, &a.MontoDevuelto, &a.Saldo,
+	)
+	a.Aplicaciones = []AplicacionAnticipo{}
+	return a, err
+}
+
+/*
+Agrega a los anticipos las cobranzas y pagos en los que se
+usaron, del mas antiguo al mas reciente.
+*/
+func aplicacionesDeAnticipos(db *sql.DB, anticipos []Anticipo) error {
+	if len(anticipos) == 0 {
+		return nil
+	}
+
+	type claveAnticipo struct {
+		compania    int
+		consecutivo int
+	}
+
+	nombres := make([]string, len(anticipos))
+	params := []interface{}{}
+	posicion := map[claveAnticipo]int{}
+	for i, a := range anticipos {
+		nombre := "a" + strconv.Itoa(i)
+		nombres[i] = "@" + nombre
+		params = append(params, sql.Named(nombre, a.ConsecutivoAnticipo))
+		posicion[claveAnticipo{a.ConsecutivoCompania, a.ConsecutivoAnticipo}] = i
+	}
+	lista := strings.Join(nombres, ", ")
+
+	rows, err := db.Query(`
+		SELECT ac.ConsecutivoCompania, ac.ConsecutivoAnticipoUsado, 'COBRANZA', ac.NumeroCobranza,
+		       co.Fecha, co.StatusCobranza, ac.MontoAplicado, ac.MontoRestanteAlDia, ac.CodigoMoneda, ac.Cambio
+		FROM dbo.anticipoCobrado ac
+		LEFT JOIN dbo.Cobranza co
+		       ON co.ConsecutivoCompania = ac.ConsecutivoCompania AND co.Numero = ac.NumeroCobranza
+		WHERE ac.ConsecutivoAnticipoUsado IN (`+lista+`)
+		UNION ALL
+		SELECT ap.ConsecutivoCompania, ap.ConsecutivoAnticipoUsado, 'PAGO', CONVERT(varchar(20), ap.NumeroComprobante),
+		       p.Fecha, p.StatusOrdenDePago, ap.MontoAplicado, ap.MontoRestanteAlDia, ap.CodigoMoneda, ap.Cambio
+		FROM dbo.anticipoPagado ap
+		LEFT JOIN dbo.Pago p
+		       ON p.ConsecutivoCompania = ap.ConsecutivoCompania AND p.NumeroComprobante = ap.NumeroComprobante
+		WHERE ap.ConsecutivoAnticipoUsado IN (`+lista+`)
+		ORDER BY 5, 4
+	`, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clave claveAnticipo
+		var ap AplicacionAnticipo
+		if err := rows.Scan(&clave.compania, &clave.consecutivo, &ap.Origen, &ap.Documento, &ap.Fecha, &ap.Status,
+			&ap.MontoAplicado, &ap.MontoRestanteAlDia, &ap.CodigoMoneda, &ap.Cambio); err != nil {
+			return err
+		}
+		if i, ok := posicion[clave]; ok {
+			anticipos[i].Aplicaciones = append(anticipos[i].Aplicaciones, ap)
+		}
+	}
+	return rows.Err()
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Anticipos con su saldo y sus aplicaciones.
+Querys:
+
+codigoCliente, codigoProveedor: codigo exacto
+tipo, status: valor exacto de Tipo y Status
+desde, hasta: rango de la fecha del anticipo
+conSaldo: si/true para traer solo los anticipos con saldo
+page, pageSize: paginacion, por defecto 1 y 100 (maximo 1000)
+*/
+func buscarAnticipos(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		desde, hasta := v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize, ok := v.Entero(c.Query("pageSize"), "pageSize", 1, 1000)
+		if !ok {
+			pageSize = 100
+		}
+		if !v.Valido() {
+			logError(requestID+" - Parámetros de búsqueda de anticipos inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filterQuery := " WHERE 1=1"
+		params := []interface{}{}
+		aplicados := map[string]interface{}{}
+
+		// Filtros exactos: query -> columna
+		for _, filtro := range []struct{ query, columna string }{
+			{"codigoCliente", "CodigoCliente"},
+			{"codigoProveedor", "CodigoProveedor"},
+			{"tipo", "Tipo"},
+			{"status", "Status"},
+		} {
+			if valor := strings.TrimSpace(c.Query(filtro.query)); valor != "" {
+				filterQuery += " AND " + filtro.columna + " = @" + filtro.query
+				params = append(params, sql.Named(filtro.query, valor))
+				aplicados[filtro.query] = valor
+			}
+		}
+		if desde != nil {
+			filterQuery += " AND Fecha >= @desde"
+			params = append(params, sql.Named("desde", *desde))
+			aplicados["desde"] = desde.Format("2006-01-02")
+		}
+		if hasta != nil {
+			filterQuery += " AND Fecha < @hasta"
+			params = append(params, sql.Named("hasta", *hasta))
+			aplicados["hasta"] = hasta.AddDate(0, 0, -1).Format("2006-01-02")
+		}
+		if conSaldo := strings.ToLower(c.Query("conSaldo")); conSaldo == "si" || conSaldo == "true" {
+			filterQuery += " AND " + saldoAnticipoSQL + " > @tolerancia"
+			params = append(params, sql.Named("tolerancia", toleranciaSaldo))
+			aplicados["conSaldo"] = true
+		}
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.anticipo"+filterQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de anticipos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron anticipos con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT ` + columnasAnticipo + `
+			FROM dbo.anticipo` + filterQuery + `
+			ORDER BY Fecha DESC, ConsecutivoAnticipo DESC
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar los anticipos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		anticipos := []Anticipo{}
+		for rows.Next() {
+			a, err := escanearAnticipo(rows)
+			if err != nil {
+				mensaje := "Error al leer datos de anticipos"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			anticipos = append(anticipos, a)
+		}
+		rows.Close()
+
+		// ----- Cobranzas y pagos donde se usaron ----- //
+		if err := aplicacionesDeAnticipos(db, anticipos); err != nil {
+			mensaje := "Error al consultar el uso de los anticipos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		respuesta.Pagina(c, "Anticipos encontrados", anticipos, len(anticipos),
+			respuesta.NuevaPaginacion(total, page, pageSize), aplicados)
+	}
+}
+
+/*
+Saldo disponible de anticipos por cliente y moneda.
+Solo incluye los clientes con saldo.
+Query: codigoCliente.
+*/
+func saldosAnticipos(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		filterQuery := " WHERE ISNULL(CodigoCliente, '') <> '' AND " + saldoAnticipoSQL + " > @tolerancia"
+		params := []interface{}{sql.Named("tolerancia", toleranciaSaldo)}
+		if codigo := strings.TrimSpace(c.Query("codigoCliente")); codigo != "" {
+			filterQuery += " AND CodigoCliente = @codigoCliente"
+			params = append(params, sql.Named("codigoCliente", codigo))
+		}
+
+		rows, err := db.Query(`
+			SELECT CodigoCliente, CodigoMoneda, COUNT(*), SUM(ISNULL(MontoTotal, 0)), SUM(ISNULL(MontoUsado, 0)),
+			       SUM(ISNULL(MontoDevuelto, 0)), SUM(`+saldoAnticipoSQL+`)
+			FROM dbo.anticipo`+filterQuery+`
+			GROUP BY CodigoCliente, CodigoMoneda
+			ORDER BY CodigoCliente, CodigoMoneda
+		`, params...)
+		if err != nil {
+			mensaje := "Error al consultar los saldos de anticipos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		saldos := []SaldoAnticiposCliente{}
+		for rows.Next() {
+			var s SaldoAnticiposCliente
+			if err := rows.Scan(&s.CodigoCliente, &s.CodigoMoneda, &s.Anticipos, &s.MontoTotal, &s.MontoUsado,
+				&s.MontoDevuelto, &s.Saldo); err != nil {
+				mensaje := "Error al leer los saldos de anticipos"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			saldos = append(saldos, s)
+		}
+
+		if len(saldos) == 0 {
+			respuesta.SinResultados(c, "No hay clientes con anticipos disponibles")
+			return
+		}
+
+		respuesta.Exito(c, "Saldos de anticipos por cliente", saldos, len(saldos))
+	}
+}
+
+/*
+Detalle de un anticipo por su ConsecutivoAnticipo, con las
+cobranzas o pagos donde se uso. Acepta el query compania;
+si el consecutivo existe en varias companias y no se indica
+compania se responde 409.
+*/
+func detalleAnticipo(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		var v respuesta.Validador
+		consecutivo, _ := v.Entero(c.Param("consecutivo"), "consecutivo", 1, math.MaxInt32)
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filtro, params := respuesta.FiltroCompania("ConsecutivoAnticipo = @consecutivo", "ConsecutivoCompania",
+			[]interface{}{sql.Named("consecutivo", consecutivo)}, compania, conCompania)
+		anticipos, err := respuesta.LeerUnico(db, `
+			SELECT TOP 2 `+columnasAnticipo+`
+			FROM dbo.anticipo
+			WHERE `+filtro+`
+			ORDER BY ConsecutivoCompania
+		`, params, func(rows *sql.Rows) (Anticipo, error) { return escanearAnticipo(rows) })
+		if err != nil {
+			mensaje := "Error al consultar el anticipo"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		if _, ok := respuesta.Unico(c, anticipos, "el anticipo "+strconv.Itoa(consecutivo)); !ok {
+			return
+		}
+
+		if err := aplicacionesDeAnticipos(db, anticipos); err != nil {
+			mensaje := "Error al consultar el uso del anticipo"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		respuesta.Exito(c, "Detalle del anticipo "+strconv.Itoa(consecutivo), anticipos[0], 1)
+	}
+}
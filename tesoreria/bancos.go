@@ -0,0 +1,884 @@
+package tesoreria
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Movimientos bancarios (dbo.MovimientoBancario) y
+	conciliaciones (dbo.Conciliacion y
+	dbo.DetalleDeConciliacion).
+
+	TipoConcepto indica si el movimiento entra o sale de la
+	cuenta. El esquema no incluye la tabla de conceptos ni
+	documenta los codigos: solo se conocen los nombres
+	INGRESO y EGRESO, los codigos se configuran en
+	codigos_galac.json:
+
+	{"conceptosBancarios": {"ingreso": ["0"], "egreso": ["1"]}}
+
+	Un valor desconocido no se asume ingreso: el movimiento
+	queda con signo 0, no suma al saldo y se reporta con
+	tipoDesconocido. Si hay alguno, los saldos se marcan como
+	provisionales.
+	El saldo de una cuenta es el saldo de apertura indicado
+	mas la suma de sus movimientos con ese signo, desde el
+	primer movimiento registrado.
+*/
+
+// Valores de TipoConcepto conocidos, en mayusculas
+var (
+	tiposIngreso = []string{"INGRESO"}
+	tiposEgreso  = []string{"EGRESO"}
+)
+
+// Signo del movimiento segun TipoConcepto, con alias m: 1, -1 o 0 si es desconocido
+var signoMovimientoSQL = expresionSignoSQL()
+
+/*
+Lee los codigos de TipoConcepto desde el archivo. Si el
+archivo no existe solo se conocen INGRESO y EGRESO; si
+existe pero no es valido se retorna el error.
+*/
+func CargarTiposConcepto(ruta string) error {
+	contenido, err := os.ReadFile(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("Sin codigos de TipoConcepto configurados (" + ruta + "), los saldos bancarios son provisionales")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var archivo struct {
+		ConceptosBancarios struct {
+			Ingreso []string `json:"ingreso"`
+			Egreso  []string `json:"egreso"`
+		} `json:"conceptosBancarios"`
+	}
+	if err := json.Unmarshal(contenido, &archivo); err != nil {
+		return err
+	}
+
+	ingreso, egreso := []string{"INGRESO"}, []string{"EGRESO"}
+	vistos := map[string]string{"INGRESO": "ingreso", "EGRESO": "egreso"}
+	for _, lista := range []struct {
+		nombre  string
+		valores []string
+		destino *[]string
+	}{
+		{"ingreso", archivo.ConceptosBancarios.Ingreso, &ingreso},
+		{"egreso", archivo.ConceptosBancarios.Egreso, &egreso},
+	} {
+		for _, valor := range lista.valores {
+			valor = strings.ToUpper(strings.TrimSpace(valor))
+			if valor == "" {
+				continue
+			}
+			if otro, ok := vistos[valor]; ok && otro != lista.nombre {
+				return fmt.Errorf("conceptosBancarios: %q está en ingreso y en egreso", valor)
+			}
+			vistos[valor] = lista.nombre
+			*lista.destino = append(*lista.destino, valor)
+		}
+	}
+
+	tiposIngreso, tiposEgreso = ingreso, egreso
+	signoMovimientoSQL = expresionSignoSQL()
+	columnasMovimiento = columnasDeMovimiento()
+	log.Println("Codigos de TipoConcepto cargados: " + strconv.Itoa(len(ingreso)-1) + " de ingreso y " +
+		strconv.Itoa(len(egreso)-1) + " de egreso")
+	return nil
+}
+
+// Arma signoMovimientoSQL con los tipos conocidos
+func expresionSignoSQL() string {
+	expresion := "(CASE UPPER(LTRIM(RTRIM(ISNULL(m.TipoConcepto, ''))))"
+	for _, valor := range literalesSQL(tiposEgreso) {
+		expresion += " WHEN " + valor + " THEN -1"
+	}
+	for _, valor := range literalesSQL(tiposIngreso) {
+		expresion += " WHEN " + valor + " THEN 1"
+	}
+	return expresion + " ELSE 0 END)"
+}
+
+// Opciones del query tipoConcepto => signo
+var opcionesTipoConcepto = map[string]string{
+	"ingreso":     "1",
+	"egreso":      "-1",
+	"desconocido": "0",
+}
+
+// Valores como literales de SQL. Solo para los tipos de este archivo
+func literalesSQL(valores []string) []string {
+	literales := make([]string, len(valores))
+	for i, valor := range valores {
+		literales[i] = "'" + strings.ReplaceAll(valor, "'", "''") + "'"
+	}
+	return literales
+}
+
+// Signo de TipoConcepto, igual que signoMovimientoSQL
+func signoTipoConcepto(tipo *string) int {
+	if tipo == nil {
+		return 0
+	}
+	valor := strings.ToUpper(strings.TrimSpace(*tipo))
+	for _, t := range tiposEgreso {
+		if valor == t {
+			return -1
+		}
+	}
+	for _, t := range tiposIngreso {
+		if valor == t {
+			return 1
+		}
+	}
+	return 0
+}
+
+type MovimientoBancario struct {
+	ConsecutivoCompania      int       `json:"consecutivoCompania"`
+	ConsecutivoMovimiento    int       `json:"consecutivoMovimiento"`
+	CodigoCtaBancaria        string    `json:"codigoCtaBancaria"`
+	CodigoConcepto           string    `json:"codigoConcepto"`
+	Fecha                    time.Time `json:"fecha"`
+	TipoConcepto             *string   `json:"tipoConcepto"`
+	Monto                    *float64  `json:"monto"`
+	MontoConSigno            float64   `json:"montoConSigno"`   // Negativo en los egresos, 0 si el tipo es desconocido
+	TipoDesconocido          bool      `json:"tipoDesconocido"` // TipoConcepto sin signo conocido
+	NumeroDocumento          *string   `json:"numeroDocumento"`
+	Descripcion              *string   `json:"descripcion"`
+	GeneradoPor              *string   `json:"generadoPor"`
+	NroMovimientoRelacionado *string   `json:"nroMovimientoRelacionado"`
+	CambioAbolivares         *float64  `json:"cambioAbolivares"`
+	Conciliado               bool      `json:"conciliado"`
+	NroConciliacion          *string   `json:"nroConciliacion"`
+}
+
+// Movimiento con el saldo de la cuenta despues de aplicarlo
+type MovimientoConSaldo struct {
+	MovimientoBancario
+	Saldo float64 `json:"saldo"`
+}
+
+// Resumen de una cuenta en un rango de fechas
+type SaldoCuentaBancaria struct {
+	ConsecutivoCompania  int     `json:"consecutivoCompania"`
+	CodigoCtaBancaria    string  `json:"codigoCtaBancaria"`
+	SaldoInicial         float64 `json:"saldoInicial"`
+	Ingresos             float64 `json:"ingresos"`
+	Egresos              float64 `json:"egresos"`
+	SaldoFinal           float64 `json:"saldoFinal"`
+	Movimientos          int     `json:"movimientos"`
+	SinConciliar         int     `json:"sinConciliar"`      // Movimientos sin conciliar hasta la fecha final
+	MontoSinConciliar    float64 `json:"montoSinConciliar"` // Con signo
+	SaldoConciliadoFinal float64 `json:"saldoConciliadoFinal"`
+
+	// Movimientos del rango con TipoConcepto desconocido, fuera de los saldos
+	TiposDesconocidos    int     `json:"tiposDesconocidos"`
+	MontoTipoDesconocido float64 `json:"montoTipoDesconocido"` // Sin signo
+
+	// Algun movimiento hasta el final tiene TipoConcepto desconocido
+	Provisional bool `json:"provisional"`
+}
+
+// Saldo corrido de una cuenta
+type SaldoCorrido struct {
+	ConsecutivoCompania int                  `json:"consecutivoCompania"`
+	CodigoCtaBancaria   string               `json:"codigoCtaBancaria"`
+	SaldoApertura       float64              `json:"saldoApertura"` // Saldo antes del primer movimiento registrado
+	SaldoInicial        float64              `json:"saldoInicial"`
+	SaldoFinal          float64              `json:"saldoFinal"`
+	TiposDesconocidos   int                  `json:"tiposDesconocidos"` // Movimientos hasta el final con TipoConcepto desconocido
+	Provisional         bool                 `json:"provisional"`       // TiposDesconocidos > 0, el saldo no es confiable
+	Movimientos         []MovimientoConSaldo `json:"movimientos"`
+}
+
+type RenglonConciliacion struct {
+	Consecutivo     int       `json:"consecutivo"`
+	CodConcepto     string    `json:"codConcepto"`
+	TipoConcepto    *string   `json:"tipoConcepto"`
+	FechaRef        time.Time `json:"fechaRef"`
+	NumeroDocumento string    `json:"numeroDocumento"`
+	DescripcionDet  string    `json:"descripcionDet"`
+	Monto           *float64  `json:"monto"`
+}
+
+type Conciliacion struct {
+	ConsecutivoCompania     int        `json:"consecutivoCompania"`
+	NroConciliacion         string     `json:"nroConciliacion"`
+	CodigoCuenta            string     `json:"codigoCuenta"`
+	MesDeAplicacion         *int       `json:"mesDeAplicacion"`
+	AnoDeAplicacion         *int       `json:"anoDeAplicacion"`
+	SaldoEdoCuentaBancario  *float64   `json:"saldoEdoCuentaBancario"`
+	Status                  *string    `json:"status"`
+	TotalDebe               *float64   `json:"totalDebe"`
+	TotalHaber              *float64   `json:"totalHaber"`
+	NombreOperador          *string    `json:"nombreOperador"`
+	FechaUltimaModificacion *time.Time `json:"fechaUltimaModificacion"`
+}
+
+// Conciliacion con sus renglones y los movimientos conciliados en ella
+type DetalleConciliacion struct {
+	Conciliacion
+	Renglones   []RenglonConciliacion `json:"renglones"`
+	Movimientos []MovimientoBancario  `json:"movimientos"`
+}
+
+var columnasMovimiento = columnasDeMovimiento()
+
+// Arma columnasMovimiento, que incluye signoMovimientoSQL
+func columnasDeMovimiento() string {
+	return `
+	m.ConsecutivoCompania, m.ConsecutivoMovimiento, m.CodigoCtaBancaria, m.CodigoConcepto, m.Fecha,
+	m.TipoConcepto, m.Monto, ` + signoMovimientoSQL + ` * ISNULL(m.Monto, 0) AS MontoConSigno, m.NumeroDocumento,
+	m.Descripcion, m.GeneradoPor, m.NroMovimientoRelacionado, m.CambioAbolivares, m.ConciliadoSn,
+	m.NroConciliacion`
+}
+
+// Destinos de Scan para columnasMovimiento
+func (mb *MovimientoBancario) destinos(conciliado **string) []interface{} {
+	return []interface{}{
+		&mb.ConsecutivoCompania, &mb.ConsecutivoMovimiento, &mb.CodigoCtaBancaria, &mb.CodigoConcepto, &mb.Fecha,
+		&mb.TipoConcepto, &mb.Monto, &mb.MontoConSigno, &mb.NumeroDocumento, &mb.Descripcion, &mb.GeneradoPor,
+		&mb.NroMovimientoRelacionado, &mb.CambioAbolivares, conciliado, &mb.NroConciliacion,
+	}
+}
+
+// Completa los campos derivados despues del Scan
+func (mb *MovimientoBancario) completar(conciliado *string) {
+	mb.Conciliado = conciliado != nil && *conciliado == "S"
+	mb.TipoDesconocido = signoTipoConcepto(mb.TipoConcepto) == 0
+	if mb.NroConciliacion != nil && strings.TrimSpace(*mb.NroConciliacion) == "" {
+		mb.NroConciliacion = nil
+	}
+}
+
+func escanearMovimiento(row escaner) (MovimientoBancario, error) {
+	var mb MovimientoBancario
+	var conciliado *string
+	err := row.Scan(mb.destinos(&conciliado)...)
+	mb.completar(conciliado)
+	return mb, err
+}
+
+const columnasConciliacion = `
+	ConsecutivoCompania, NroConciliacion, CodigoCuenta, MesDeAplicacion, AnoDeAplicacion,
+	SaldoEdoCuentaBancario, Status, TotalDebe, TotalHaber, NombreOperador, FechaUltimaModificacion`
+
+func escanearConciliacion(row escaner) (Conciliacion, error) {
+	var cc Conciliacion
+	err := row.Scan(&cc.ConsecutivoCompania, &cc.NroConciliacion, &cc.CodigoCuenta, &cc.MesDeAplicacion,
+		&cc.AnoDeAplicacion, &cc.SaldoEdoCuentaBancario, &cc.Status, &cc.TotalDebe, &cc.TotalHaber,
+		&cc.NombreOperador, &cc.FechaUltimaModificacion)
+	return cc, err
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Movimientos bancarios paginados, del mas reciente al mas
+antiguo.
+Querys:
+
+codigoCtaBancaria, codigoConcepto, numeroDocumento, nroConciliacion: valor exacto
+tipoConcepto: ingreso, egreso o desconocido
+conciliado: si o no
+desde, hasta: rango de la fecha del movimiento
+page, pageSize: paginacion, por defecto 1 y 100 (maximo 1000)
+*/
+func buscarMovimientosBancarios(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		desde, hasta := v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize, ok := v.Entero(c.Query("pageSize"), "pageSize", 1, 1000)
+		if !ok {
+			pageSize = 100
+		}
+		signo, conTipo := v.Opcion(strings.ToLower(c.Query("tipoConcepto")), "tipoConcepto", opcionesTipoConcepto,
+			"Tipo de concepto inválido. Use: ingreso, egreso o desconocido")
+		conciliado, conConciliado := v.Opcion(strings.ToLower(c.Query("conciliado")), "conciliado",
+			map[string]string{"si": "S", "no": "N"}, "Use: si o no")
+		if !v.Valido() {
+			logError(requestID+" - Parámetros de búsqueda de movimientos bancarios inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filterQuery := " WHERE 1=1"
+		params := []interface{}{}
+		aplicados := map[string]interface{}{}
+
+		// Filtros exactos: query -> columna
+		for _, filtro := range []struct{ query, columna string }{
+			{"codigoCtaBancaria", "m.CodigoCtaBancaria"},
+			{"codigoConcepto", "m.CodigoConcepto"},
+			{"numeroDocumento", "m.NumeroDocumento"},
+			{"nroConciliacion", "m.NroConciliacion"},
+		} {
+			if valor := strings.TrimSpace(c.Query(filtro.query)); valor != "" {
+				filterQuery += " AND " + filtro.columna + " = @" + filtro.query
+				params = append(params, sql.Named(filtro.query, valor))
+				aplicados[filtro.query] = valor
+			}
+		}
+		if conTipo {
+			filterQuery += " AND " + signoMovimientoSQL + " = " + signo
+			aplicados["tipoConcepto"] = strings.ToLower(c.Query("tipoConcepto"))
+		}
+		if conConciliado {
+			filterQuery += " AND (CASE WHEN m.ConciliadoSn = 'S' THEN 'S' ELSE 'N' END) = @conciliado"
+			params = append(params, sql.Named("conciliado", conciliado))
+			aplicados["conciliado"] = conciliado == "S"
+		}
+		if desde != nil {
+			filterQuery += " AND m.Fecha >= @desde"
+			params = append(params, sql.Named("desde", *desde))
+			aplicados["desde"] = desde.Format("2006-01-02")
+		}
+		if hasta != nil {
+			filterQuery += " AND m.Fecha < @hasta"
+			params = append(params, sql.Named("hasta", *hasta))
+			aplicados["hasta"] = hasta.AddDate(0, 0, -1).Format("2006-01-02")
+		}
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.MovimientoBancario m"+filterQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de movimientos bancarios"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron movimientos bancarios con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT ` + columnasMovimiento + `
+			FROM dbo.MovimientoBancario m` + filterQuery + `
+			ORDER BY m.Fecha DESC, m.ConsecutivoMovimiento DESC
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar los movimientos bancarios"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		movimientos := []MovimientoBancario{}
+		for rows.Next() {
+			mb, err := escanearMovimiento(rows)
+			if err != nil {
+				mensaje := "Error al leer datos de movimientos bancarios"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			movimientos = append(movimientos, mb)
+		}
+
+		respuesta.Pagina(c, "Movimientos bancarios encontrados", movimientos, len(movimientos),
+			respuesta.NuevaPaginacion(total, page, pageSize), aplicados)
+	}
+}
+
+/*
+Saldos por cuenta bancaria en un rango de fechas: saldo
+inicial, ingresos, egresos, saldo final y lo pendiente por
+conciliar. Sin desde el saldo inicial es 0; sin hasta se
+toman todos los movimientos. Los movimientos de tipo
+desconocido no suman y se cuentan aparte.
+Querys: codigoCtaBancaria, desde, hasta.
+*/
+func saldosCuentasBancarias(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		var v respuesta.Validador
+		desde, hasta := v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filterQuery := " WHERE 1=1"
+		enRango := "1=1"
+		params := []interface{}{}
+		if codigo := strings.TrimSpace(c.Query("codigoCtaBancaria")); codigo != "" {
+			filterQuery += " AND m.CodigoCtaBancaria = @codigoCtaBancaria"
+			params = append(params, sql.Named("codigoCtaBancaria", codigo))
+		}
+		if desde != nil {
+			enRango = "m.Fecha >= @desde"
+			params = append(params, sql.Named("desde", *desde))
+		}
+		if hasta != nil {
+			filterQuery += " AND m.Fecha < @hasta"
+			params = append(params, sql.Named("hasta", *hasta))
+		}
+
+		rows, err := db.Query(`
+			SELECT m.ConsecutivoCompania, m.CodigoCtaBancaria,
+			       SUM(CASE WHEN `+enRango+` THEN 0 ELSE s.Neto END),
+			       SUM(CASE WHEN `+enRango+` AND s.Neto > 0 THEN s.Neto ELSE 0 END),
+			       SUM(CASE WHEN `+enRango+` AND s.Neto < 0 THEN -s.Neto ELSE 0 END),
+			       SUM(s.Neto),
+			       SUM(CASE WHEN `+enRango+` THEN 1 ELSE 0 END),
+			       SUM(CASE WHEN ISNULL(m.ConciliadoSn, 'N') <> 'S' THEN 1 ELSE 0 END),
+			       SUM(CASE WHEN ISNULL(m.ConciliadoSn, 'N') <> 'S' THEN s.Neto ELSE 0 END),
+			       SUM(CASE WHEN `+enRango+` AND s.Signo = 0 THEN 1 ELSE 0 END),
+			       SUM(CASE WHEN `+enRango+` AND s.Signo = 0 THEN ISNULL(m.Monto, 0) ELSE 0 END),
+			       SUM(CASE WHEN s.Signo = 0 THEN 1 ELSE 0 END)
+			FROM dbo.MovimientoBancario m
+			CROSS APPLY (SELECT `+signoMovimientoSQL+` AS Signo) t
+			CROSS APPLY (SELECT t.Signo, t.Signo * ISNULL(m.Monto, 0) AS Neto) s`+filterQuery+`
+			GROUP BY m.ConsecutivoCompania, m.CodigoCtaBancaria
+			ORDER BY m.ConsecutivoCompania, m.CodigoCtaBancaria
+		`, params...)
+		if err != nil {
+			mensaje := "Error al consultar los saldos bancarios"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		saldos := []SaldoCuentaBancaria{}
+		provisionales := 0
+		for rows.Next() {
+			var s SaldoCuentaBancaria
+			var desconocidosTotal int
+			if err := rows.Scan(&s.ConsecutivoCompania, &s.CodigoCtaBancaria, &s.SaldoInicial, &s.Ingresos, &s.Egresos,
+				&s.SaldoFinal, &s.Movimientos, &s.SinConciliar, &s.MontoSinConciliar, &s.TiposDesconocidos,
+				&s.MontoTipoDesconocido, &desconocidosTotal); err != nil {
+				mensaje := "Error al leer los saldos bancarios"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			s.SaldoConciliadoFinal = s.SaldoFinal - s.MontoSinConciliar
+			s.Provisional = desconocidosTotal > 0
+			if s.Provisional {
+				provisionales++
+			}
+			saldos = append(saldos, s)
+		}
+
+		if len(saldos) == 0 {
+			respuesta.SinResultados(c, "No hay movimientos bancarios en el rango indicado")
+			return
+		}
+
+		mensaje := "Saldos por cuenta bancaria"
+		if provisionales > 0 {
+			mensaje += " (" + strconv.Itoa(provisionales) + " provisionales por movimientos con TipoConcepto desconocido)"
+		}
+		respuesta.Exito(c, mensaje, saldos, len(saldos))
+	}
+}
+
+/*
+Saldo corrido de una cuenta: sus movimientos en orden
+cronologico con el saldo despues de cada uno. El saldo
+parte de saldoApertura e incluye los movimientos anteriores
+a desde.
+Querys:
+
+compania: ConsecutivoCompania; obligatorio si la cuenta tiene movimientos en varias (409)
+saldoApertura: saldo de la cuenta antes de su primer movimiento, por defecto 0
+desde, hasta: rango de la fecha del movimiento
+page, pageSize: paginacion, por defecto 1 y 100 (maximo 1000)
+*/
+func saldoCorridoCuenta(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		cuenta := strings.TrimSpace(c.Param("cuenta"))
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		desde, hasta := v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize, ok := v.Entero(c.Query("pageSize"), "pageSize", 1, 1000)
+		if !ok {
+			pageSize = 100
+		}
+		apertura := 0.0
+		if valor := strings.TrimSpace(c.Query("saldoApertura")); valor != "" {
+			n, err := strconv.ParseFloat(valor, 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				v.Agregar("saldoApertura", "Debe ser un número, con punto decimal")
+			}
+			apertura = n
+		}
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		// ----- Compania de la cuenta ----- //
+		if !conCompania {
+			companias, err := respuesta.LeerUnico(db, `
+				SELECT DISTINCT TOP 2 ConsecutivoCompania
+				FROM dbo.MovimientoBancario
+				WHERE CodigoCtaBancaria = @cuenta
+				ORDER BY ConsecutivoCompania
+			`, []interface{}{sql.Named("cuenta", cuenta)}, func(rows *sql.Rows) (int, error) {
+				var compania int
+				return compania, rows.Scan(&compania)
+			})
+			if err != nil {
+				mensaje := "Error al consultar la cuenta bancaria"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			var ok bool
+			if compania, ok = respuesta.Unico(c, companias, "la cuenta "+cuenta); !ok {
+				return
+			}
+		}
+
+		// enRango va sin alias porque se usa dentro y fuera de la subconsulta
+		filterQuery := " WHERE m.ConsecutivoCompania = @compania AND m.CodigoCtaBancaria = @cuenta"
+		enRango := "1=1"
+		params := []interface{}{sql.Named("compania", compania), sql.Named("cuenta", cuenta)}
+		if desde != nil {
+			enRango = "Fecha >= @desde"
+			params = append(params, sql.Named("desde", *desde))
+		}
+		if hasta != nil {
+			filterQuery += " AND m.Fecha < @hasta"
+			params = append(params, sql.Named("hasta", *hasta))
+		}
+
+		// ----- Saldos y conteo ----- //
+		corrido := SaldoCorrido{ConsecutivoCompania: compania, CodigoCtaBancaria: cuenta, SaldoApertura: apertura,
+			Movimientos: []MovimientoConSaldo{}}
+		var total int
+		err := db.QueryRow(`
+			SELECT ISNULL(SUM(CASE WHEN `+enRango+` THEN 0 ELSE s.Neto END), 0),
+			       ISNULL(SUM(s.Neto), 0),
+			       COUNT(CASE WHEN `+enRango+` THEN 1 END),
+			       COUNT(CASE WHEN s.Signo = 0 THEN 1 END)
+			FROM dbo.MovimientoBancario m
+			CROSS APPLY (SELECT `+signoMovimientoSQL+` AS Signo) t
+			CROSS APPLY (SELECT t.Signo, t.Signo * ISNULL(m.Monto, 0) AS Neto) s`+filterQuery,
+			params...).Scan(&corrido.SaldoInicial, &corrido.SaldoFinal, &total, &corrido.TiposDesconocidos)
+		if err != nil {
+			mensaje := "Error al calcular el saldo de la cuenta"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No hay movimientos de la cuenta "+cuenta+" en el rango indicado")
+			return
+		}
+		corrido.SaldoInicial += apertura
+		corrido.SaldoFinal += apertura
+		corrido.Provisional = corrido.TiposDesconocidos > 0
+
+		// ----- Movimientos con saldo ----- //
+		query := `
+			SELECT * FROM (
+				SELECT ` + columnasMovimiento + `,
+				       SUM(` + signoMovimientoSQL + ` * ISNULL(m.Monto, 0))
+				           OVER (ORDER BY m.Fecha, m.ConsecutivoMovimiento ROWS UNBOUNDED PRECEDING) AS Saldo
+				FROM dbo.MovimientoBancario m` + filterQuery + `
+			) x
+			WHERE ` + enRango + `
+			ORDER BY Fecha, ConsecutivoMovimiento
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar el saldo corrido de la cuenta"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var mc MovimientoConSaldo
+			var conciliado *string
+			if err := rows.Scan(append(mc.destinos(&conciliado), &mc.Saldo)...); err != nil {
+				mensaje := "Error al leer el saldo corrido de la cuenta"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			mc.completar(conciliado)
+			mc.Saldo += apertura
+			corrido.Movimientos = append(corrido.Movimientos, mc)
+		}
+
+		mensaje := "Saldo corrido de la cuenta " + cuenta
+		if corrido.Provisional {
+			mensaje += " (provisional: " + strconv.Itoa(corrido.TiposDesconocidos) + " movimientos con TipoConcepto desconocido)"
+		}
+		respuesta.Pagina(c, mensaje, corrido, len(corrido.Movimientos),
+			respuesta.NuevaPaginacion(total, page, pageSize), map[string]interface{}{"compania": compania, "saldoApertura": apertura})
+	}
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Conciliaciones bancarias paginadas, de la mas reciente a
+la mas antigua.
+Querys:
+
+codigoCuenta, status: valor exacto
+desde, hasta: rango de fechas; se comparan el mes y año de aplicacion
+page, pageSize: paginacion, por defecto 1 y 100 (maximo 1000)
+*/
+func buscarConciliaciones(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		desde, hasta := v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize, ok := v.Entero(c.Query("pageSize"), "pageSize", 1, 1000)
+		if !ok {
+			pageSize = 100
+		}
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filterQuery := " WHERE 1=1"
+		params := []interface{}{}
+		aplicados := map[string]interface{}{}
+
+		for _, filtro := range []struct{ query, columna string }{
+			{"codigoCuenta", "CodigoCuenta"},
+			{"status", "Status"},
+		} {
+			if valor := strings.TrimSpace(c.Query(filtro.query)); valor != "" {
+				filterQuery += " AND " + filtro.columna + " = @" + filtro.query
+				params = append(params, sql.Named(filtro.query, valor))
+				aplicados[filtro.query] = valor
+			}
+		}
+
+		// El periodo AAAAMM de aplicacion se compara con el de las fechas
+		const periodo = "(ISNULL(AnoDeAplicacion, 0) * 100 + ISNULL(MesDeAplicacion, 0))"
+		if desde != nil {
+			filterQuery += " AND " + periodo + " >= @periodoDesde"
+			params = append(params, sql.Named("periodoDesde", desde.Year()*100+int(desde.Month())))
+			aplicados["desde"] = desde.Format("2006-01-02")
+		}
+		if hasta != nil {
+			ultimo := hasta.AddDate(0, 0, -1)
+			filterQuery += " AND " + periodo + " <= @periodoHasta"
+			params = append(params, sql.Named("periodoHasta", ultimo.Year()*100+int(ultimo.Month())))
+			aplicados["hasta"] = ultimo.Format("2006-01-02")
+		}
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.Conciliacion"+filterQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de conciliaciones"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron conciliaciones con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT ` + columnasConciliacion + `
+			FROM dbo.Conciliacion` + filterQuery + `
+			ORDER BY ` + periodo + ` DESC, NroConciliacion DESC
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar las conciliaciones"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		conciliaciones := []Conciliacion{}
+		for rows.Next() {
+			cc, err := escanearConciliacion(rows)
+			if err != nil {
+				mensaje := "Error al leer datos de conciliaciones"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			conciliaciones = append(conciliaciones, cc)
+		}
+
+		respuesta.Pagina(c, "Conciliaciones encontradas", conciliaciones, len(conciliaciones),
+			respuesta.NuevaPaginacion(total, page, pageSize), aplicados)
+	}
+}
+
+/*
+Detalle de una conciliacion por su numero, con sus
+renglones y los movimientos bancarios conciliados en ella.
+Query: compania. Si el numero existe en varias companias y
+no se indica compania se responde 409.
+*/
+func detalleConciliacion(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		numero := strings.TrimSpace(c.Param("numero"))
+
+		var v respuesta.Validador
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filtro, params := respuesta.FiltroCompania("NroConciliacion = @numero", "ConsecutivoCompania",
+			[]interface{}{sql.Named("numero", numero)}, compania, conCompania)
+		encontradas, err := respuesta.LeerUnico(db, `
+			SELECT TOP 2 `+columnasConciliacion+`
+			FROM dbo.Conciliacion
+			WHERE `+filtro+`
+			ORDER BY ConsecutivoCompania
+		`, params, func(rows *sql.Rows) (Conciliacion, error) { return escanearConciliacion(rows) })
+		if err != nil {
+			mensaje := "Error al consultar la conciliación"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		cc, ok := respuesta.Unico(c, encontradas, "la conciliación "+numero)
+		if !ok {
+			return
+		}
+
+		detalle := DetalleConciliacion{
+			Conciliacion: cc,
+			Renglones:    []RenglonConciliacion{},
+			Movimientos:  []MovimientoBancario{},
+		}
+		params = []interface{}{
+			sql.Named("compania", cc.ConsecutivoCompania),
+			sql.Named("numero", cc.NroConciliacion),
+			sql.Named("cuenta", cc.CodigoCuenta),
+		}
+
+		// ----- Renglones ----- //
+		rows, err := db.Query(`
+			SELECT Consecutivo, CodConcepto, TipoConcepto, FechaRef, NumeroDocumento, DescripcionDet, Monto
+			FROM dbo.DetalleDeConciliacion
+			WHERE ConsecutivoCompania = @compania AND NroConciliacion = @numero AND CodigoCuenta = @cuenta
+			ORDER BY Consecutivo
+		`, params...)
+		if err != nil {
+			mensaje := "Error al consultar los renglones de la conciliación"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r RenglonConciliacion
+			if err := rows.Scan(&r.Consecutivo, &r.CodConcepto, &r.TipoConcepto, &r.FechaRef, &r.NumeroDocumento,
+				&r.DescripcionDet, &r.Monto); err != nil {
+				mensaje := "Error al leer los renglones de la conciliación"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			detalle.Renglones = append(detalle.Renglones, r)
+		}
+		rows.Close()
+
+		// ----- Movimientos conciliados ----- //
+		rows, err = db.Query(`
+			SELECT `+columnasMovimiento+`
+			FROM dbo.MovimientoBancario m
+			WHERE m.ConsecutivoCompania = @compania AND m.NroConciliacion = @numero AND m.CodigoCtaBancaria = @cuenta
+			ORDER BY m.Fecha, m.ConsecutivoMovimiento
+		`, params...)
+		if err != nil {
+			mensaje := "Error al consultar los movimientos de la conciliación"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			mb, err := escanearMovimiento(rows)
+			if err != nil {
+				mensaje := "Error al leer los movimientos de la conciliación"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			detalle.Movimientos = append(detalle.Movimientos, mb)
+		}
+
+		respuesta.Exito(c, "Detalle de la conciliación "+numero, detalle, 1)
+	}
+}
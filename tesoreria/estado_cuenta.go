@@ -0,0 +1,829 @@
+package tesoreria
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/monedas"
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Conciliacion de un estado de cuenta bancario contra Galac.
+
+	Se recibe el archivo del banco (CSV u OFX) y cada linea se
+	busca en dbo.MovimientoBancario y, si es un credito, en
+	dbo.Cobranza. Una linea coincide con un registro si el
+	monto es igual (con su signo) y la fecha esta dentro de la
+	ventana de dias. Si ademas coincide la referencia, solo se
+	toman en cuenta los registros con esa referencia.
+
+	Aunque la ruta es POST no se escribe nada en Galac, el
+	POST solo se usa para poder recibir el archivo.
+
+	En el estado de cuenta los creditos (depositos) son
+	positivos y los debitos negativos. Los montos estan en la
+	moneda de la cuenta, por eso solo se comparan cobranzas en
+	esa moneda.
+*/
+
+// Cantidad maxima de lineas de un estado de cuenta
+const maxLineasEstadoCuenta = 20000
+
+// Tamaño maximo del formulario con el archivo
+const maxBytesEstadoCuenta = 5 << 20
+
+// Diferencia maxima entre montos para considerarlos iguales
+const toleranciaMonto = 0.005
+
+// Dias de diferencia permitidos por defecto entre la linea y el registro de Galac
+const diasVentanaPorDefecto = 3
+
+// Columnas del CSV cuando no se indican, por nombre de encabezado
+var columnasPorDefecto = map[string]string{
+	"fecha":       "fecha",
+	"monto":       "monto",
+	"referencia":  "referencia",
+	"descripcion": "descripcion",
+}
+
+// Formatos de fecha que se prueban si no se indica formatoFecha
+var formatosFechaPorDefecto = []string{"02/01/2006", "2006-01-02", "02-01-2006", "02/01/06", "2006/01/02", "02.01.2006"}
+
+// Linea leida del estado de cuenta
+type LineaEstadoCuenta struct {
+	Linea       int       `json:"linea"` // Numero de linea o de transaccion en el archivo
+	Fecha       time.Time `json:"fecha"`
+	Monto       float64   `json:"monto"` // Positivo en los creditos
+	Referencia  string    `json:"referencia"`
+	Descripcion string    `json:"descripcion"`
+}
+
+// Linea del archivo que no se pudo leer
+type LineaOmitida struct {
+	Linea  int    `json:"linea"`
+	Motivo string `json:"motivo"`
+}
+
+// Cobranza que coincide con una linea
+type CobranzaCandidata struct {
+	ConsecutivoCompania  int       `json:"consecutivoCompania"`
+	Numero               string    `json:"numero"`
+	Fecha                time.Time `json:"fecha"`
+	StatusCobranza       string    `json:"statusCobranza"`
+	CodigoCliente        *string   `json:"codigoCliente"`
+	TotalCobrado         *float64  `json:"totalCobrado"`
+	NumerodelCheque      *string   `json:"numerodelCheque"`
+	CodigoCuentaBancaria *string   `json:"codigoCuentaBancaria"`
+	CodigoMoneda         string    `json:"codigoMoneda"`
+}
+
+// Linea con los registros de Galac que coinciden
+type LineaConciliada struct {
+	LineaEstadoCuenta
+	PorReferencia bool                 `json:"porReferencia"` // La referencia coincide
+	Movimientos   []MovimientoBancario `json:"movimientos"`
+	Cobranzas     []CobranzaCandidata  `json:"cobranzas"`
+}
+
+type ResultadoEstadoCuenta struct {
+	Formato           string              `json:"formato"` // csv u ofx
+	CodigoCtaBancaria *string             `json:"codigoCtaBancaria"`
+	Moneda            string              `json:"moneda"` // Moneda de la cuenta, la de las cobranzas comparadas
+	Dias              int                 `json:"dias"`
+	TotalLineas       int                 `json:"totalLineas"`
+	Conciliadas       []LineaConciliada   `json:"conciliadas"`
+	Ambiguas          []LineaConciliada   `json:"ambiguas"`
+	SinCoincidencia   []LineaEstadoCuenta `json:"sinCoincidencia"`
+	Omitidas          []LineaOmitida      `json:"omitidas"`
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Convierte un monto del banco a numero. decimal es "," o
+"."; si viene vacio se toma como separador decimal el
+ultimo que aparezca, salvo que sea uno solo seguido de
+exactamente tres digitos (miles). Los negativos pueden
+venir con signo, con "-" al final o entre parentesis.
+*/
+func leerMonto(texto, decimal string) (float64, bool) {
+	texto = strings.TrimSpace(texto)
+	negativo := false
+	if strings.HasPrefix(texto, "(") && strings.HasSuffix(texto, ")") {
+		negativo = true
+		texto = strings.Trim(texto, "()")
+	}
+	if strings.HasSuffix(texto, "-") {
+		negativo = true
+		texto = strings.TrimSuffix(texto, "-")
+	}
+
+	var limpio strings.Builder
+	for _, r := range texto {
+		switch {
+		case r >= '0' && r <= '9', r == ',', r == '.':
+			limpio.WriteRune(r)
+		case r == '-':
+			negativo = !negativo
+		}
+	}
+	numero := limpio.String()
+	if numero == "" {
+		return 0, false
+	}
+
+	if decimal == "" {
+		ultimo := strings.LastIndexAny(numero, ",.")
+		if ultimo >= 0 {
+			separador := numero[ultimo : ultimo+1]
+			unico := strings.Count(numero, ",")+strings.Count(numero, ".") == 1
+			if unico && len(numero)-ultimo-1 == 3 {
+				separador = map[string]string{",": ".", ".": ","}[separador]
+			}
+			decimal = separador
+		}
+	}
+	if decimal == "," {
+		numero = strings.ReplaceAll(numero, ".", "")
+		numero = strings.Replace(numero, ",", ".", 1)
+	} else {
+		numero = strings.ReplaceAll(numero, ",", "")
+	}
+
+	monto, err := strconv.ParseFloat(numero, 64)
+	if err != nil {
+		return 0, false
+	}
+	if negativo {
+		monto = -monto
+	}
+	return monto, true
+}
+
+// Convierte un formato como DD/MM/AAAA al layout de Go
+func layoutFecha(formato string) (string, bool) {
+	layout := strings.ToUpper(strings.TrimSpace(formato))
+	for _, r := range []struct{ de, a string }{
+		{"AAAA", "2006"}, {"YYYY", "2006"}, {"AA", "06"}, {"YY", "06"}, {"MM", "01"}, {"DD", "02"},
+	} {
+		layout = strings.ReplaceAll(layout, r.de, r.a)
+	}
+	ok := strings.Contains(layout, "01") && strings.Contains(layout, "02") && strings.Contains(layout, "06")
+	return layout, ok
+}
+
+// Lee una fecha del banco; sin layout se prueban los formatos por defecto
+func leerFecha(texto, layout string) (time.Time, bool) {
+	campos := strings.Fields(texto)
+	if len(campos) == 0 {
+		return time.Time{}, false
+	}
+	formatos := formatosFechaPorDefecto
+	if layout != "" {
+		formatos = []string{layout}
+	}
+	for _, formato := range formatos {
+		if fecha, err := time.Parse(formato, campos[0]); err == nil {
+			return fecha, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Deja solo letras y numeros en mayuscula, sin ceros a la izquierda
+func normalizarReferencia(referencia string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(referencia) {
+		if (r >= '0' && r <= '9') || (r >= 'A' && r <= 'Z') {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimLeft(b.String(), "0")
+}
+
+/*
+Indica si dos referencias (ya normalizadas) son la misma.
+Los bancos suelen recortar la referencia, por eso tambien
+se acepta que una termine con la otra si la mas corta tiene
+al menos 4 caracteres.
+*/
+func mismaReferencia(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	return a == b || (len(b) >= 4 && strings.HasSuffix(a, b))
+}
+
+// Dias entre dos fechas, sin tomar en cuenta la hora
+func diasEntre(a, b time.Time) int {
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(math.Abs(da.Sub(db).Hours() / 24))
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+// Columnas y formatos del CSV enviados en el formulario
+type mapeoCSV struct {
+	encabezado    bool
+	columnas      map[string]string // fecha, monto, debito, credito, referencia, descripcion
+	indicadas     map[string]bool   // Columnas enviadas en el formulario
+	layoutFecha   string
+	decimal       string
+	debitoCredito bool
+}
+
+// Nombre del campo del formulario de una columna: fecha => columnaFecha
+func parametroColumna(campo string) string {
+	return "columna" + strings.ToUpper(campo[:1]) + campo[1:]
+}
+
+/*
+Lee el mapeo de columnas del formulario. Cada columna se
+indica por nombre de encabezado o por numero (desde 1).
+El monto puede venir en una columna con signo o en dos
+columnas de debito y credito.
+*/
+func leerMapeoCSV(c *gin.Context, v *respuesta.Validador) mapeoCSV {
+	mapeo := mapeoCSV{
+		encabezado: strings.ToLower(c.PostForm("encabezado")) != "no",
+		columnas:   map[string]string{},
+		indicadas:  map[string]bool{},
+	}
+	for campo, porDefecto := range columnasPorDefecto {
+		mapeo.columnas[campo] = porDefecto
+	}
+	for _, campo := range []string{"fecha", "monto", "debito", "credito", "referencia", "descripcion"} {
+		if valor := strings.TrimSpace(c.PostForm(parametroColumna(campo))); valor != "" {
+			mapeo.columnas[campo] = valor
+			mapeo.indicadas[campo] = true
+		}
+	}
+
+	debito, credito := mapeo.columnas["debito"], mapeo.columnas["credito"]
+	if (debito == "") != (credito == "") {
+		v.Agregar("columnaDebito", "Indique columnaDebito y columnaCredito juntas")
+	}
+	mapeo.debitoCredito = debito != "" && credito != ""
+
+	if formato := c.PostForm("formatoFecha"); formato != "" {
+		layout, ok := layoutFecha(formato)
+		if !ok {
+			v.Agregar("formatoFecha", "Formato de fecha inválido, use por ejemplo DD/MM/AAAA")
+		}
+		mapeo.layoutFecha = layout
+	}
+	mapeo.decimal, _ = v.Opcion(c.PostForm("separadorDecimal"), "separadorDecimal",
+		map[string]string{",": ",", ".": "."}, "Separador decimal inválido. Use , o .")
+	return mapeo
+}
+
+/*
+Posicion de una columna del mapeo: un numero desde 1 o el
+nombre de un encabezado (sin distinguir mayusculas).
+Retorna -1 si la columna no se encuentra.
+*/
+func posicionColumna(valor string, encabezado []string) int {
+	if valor == "" {
+		return -1
+	}
+	if n, err := strconv.Atoi(valor); err == nil {
+		return n - 1
+	}
+	for i, nombre := range encabezado {
+		if strings.EqualFold(strings.TrimSpace(nombre), valor) {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+Lee las lineas de un estado de cuenta en CSV separado por
+coma o punto y coma. Las filas que no se pueden leer se
+devuelven como omitidas; los errores del mapeo y el exceso
+de lineas se agregan al validador.
+*/
+func leerEstadoCSV(contenido []byte, mapeo mapeoCSV, v *respuesta.Validador) ([]LineaEstadoCuenta, []LineaOmitida) {
+	lector := csv.NewReader(bytes.NewReader(contenido))
+	lector.FieldsPerRecord = -1
+	lector.TrimLeadingSpace = true
+	primeraLinea, _, _ := strings.Cut(string(contenido), "\n")
+	if strings.Count(primeraLinea, ";") > strings.Count(primeraLinea, ",") {
+		lector.Comma = ';'
+	}
+
+	// Se deja de leer al pasar el maximo de lineas
+	registros := [][]string{}
+	for {
+		registro, err := lector.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			v.Agregar("archivo", "El archivo CSV no es válido: "+err.Error())
+			return nil, nil
+		}
+		registros = append(registros, registro)
+		if len(registros) > maxLineasEstadoCuenta+1 {
+			v.Agregar("archivo", "Se permiten como máximo "+strconv.Itoa(maxLineasEstadoCuenta)+" líneas")
+			return nil, nil
+		}
+	}
+
+	var encabezado []string
+	inicio := 0
+	if mapeo.encabezado && len(registros) > 0 {
+		encabezado = registros[0]
+		inicio = 1
+	}
+
+	// ----- Posicion de cada columna ----- //
+	// Las columnas por defecto de referencia y descripcion son opcionales
+	obligatorias := map[string]bool{"fecha": true, "monto": !mapeo.debitoCredito}
+	for campo := range mapeo.indicadas {
+		obligatorias[campo] = true
+	}
+	posiciones := map[string]int{}
+	for campo, valor := range mapeo.columnas {
+		posiciones[campo] = posicionColumna(valor, encabezado)
+		if obligatorias[campo] && posiciones[campo] < 0 {
+			v.Agregar(parametroColumna(campo), "No se encontró la columna "+valor+" en el archivo")
+		}
+	}
+	if !v.Valido() {
+		return nil, nil
+	}
+
+	valor := func(registro []string, campo string) string {
+		i := posiciones[campo]
+		if i < 0 || i >= len(registro) {
+			return ""
+		}
+		return strings.TrimSpace(registro[i])
+	}
+
+	// ----- Lineas ----- //
+	lineas := []LineaEstadoCuenta{}
+	omitidas := []LineaOmitida{}
+	for i := inicio; i < len(registros); i++ {
+		registro := registros[i]
+		numero := i + 1
+		if strings.TrimSpace(strings.Join(registro, "")) == "" {
+			continue
+		}
+
+		fecha, ok := leerFecha(valor(registro, "fecha"), mapeo.layoutFecha)
+		if !ok {
+			omitidas = append(omitidas, LineaOmitida{numero, "Fecha inválida: " + valor(registro, "fecha")})
+			continue
+		}
+
+		var monto float64
+		if mapeo.debitoCredito {
+			debito, okDebito := leerMonto(valor(registro, "debito"), mapeo.decimal)
+			credito, okCredito := leerMonto(valor(registro, "credito"), mapeo.decimal)
+			if !okDebito && !okCredito {
+				omitidas = append(omitidas, LineaOmitida{numero, "La línea no tiene débito ni crédito"})
+				continue
+			}
+			monto = math.Abs(credito) - math.Abs(debito)
+		} else {
+			monto, ok = leerMonto(valor(registro, "monto"), mapeo.decimal)
+			if !ok {
+				omitidas = append(omitidas, LineaOmitida{numero, "Monto inválido: " + valor(registro, "monto")})
+				continue
+			}
+		}
+
+		lineas = append(lineas, LineaEstadoCuenta{
+			Linea:       numero,
+			Fecha:       fecha,
+			Monto:       monto,
+			Referencia:  valor(registro, "referencia"),
+			Descripcion: valor(registro, "descripcion"),
+		})
+	}
+	return lineas, omitidas
+}
+
+// Inicio de cada transaccion y etiquetas de una transaccion OFX (SGML o XML)
+var (
+	inicioTransaccionOFX = regexp.MustCompile(`(?i)<STMTTRN>`)
+	etiquetaOFX          = regexp.MustCompile(`<([A-Za-z0-9.]+)>([^<\r\n]*)`)
+)
+
+/*
+Lee las transacciones (STMTTRN) de un archivo OFX. Sirve
+tanto para OFX 1.x (SGML, sin etiquetas de cierre en los
+valores) como para OFX 2.x (XML). La referencia es
+CHECKNUM, REFNUM o FITID, en ese orden. El exceso de
+transacciones se agrega al validador.
+*/
+func leerEstadoOFX(contenido []byte, v *respuesta.Validador) ([]LineaEstadoCuenta, []LineaOmitida) {
+	lineas := []LineaEstadoCuenta{}
+	omitidas := []LineaOmitida{}
+
+	// Se corta apenas pasa el maximo de transacciones
+	bloques := inicioTransaccionOFX.Split(string(contenido), maxLineasEstadoCuenta+2)
+	if len(bloques)-1 > maxLineasEstadoCuenta {
+		v.Agregar("archivo", "Se permiten como máximo "+strconv.Itoa(maxLineasEstadoCuenta)+" líneas")
+		return nil, nil
+	}
+	for i, bloque := range bloques[1:] {
+		numero := i + 1
+		if fin := strings.Index(strings.ToUpper(bloque), "</STMTTRN>"); fin >= 0 {
+			bloque = bloque[:fin]
+		}
+
+		campos := map[string]string{}
+		for _, m := range etiquetaOFX.FindAllStringSubmatch(bloque, -1) {
+			campos[strings.ToUpper(m[1])] = strings.TrimSpace(m[2])
+		}
+
+		fechaOFX := campos["DTPOSTED"]
+		if len(fechaOFX) < 8 {
+			omitidas = append(omitidas, LineaOmitida{numero, "Transacción sin DTPOSTED"})
+			continue
+		}
+		fecha, err := time.Parse("20060102", fechaOFX[:8])
+		if err != nil {
+			omitidas = append(omitidas, LineaOmitida{numero, "DTPOSTED inválido: " + fechaOFX})
+			continue
+		}
+		// OFX siempre usa punto decimal y no separa miles
+		monto, ok := leerMonto(campos["TRNAMT"], ".")
+		if !ok {
+			omitidas = append(omitidas, LineaOmitida{numero, "TRNAMT inválido: " + campos["TRNAMT"]})
+			continue
+		}
+
+		referencia := campos["CHECKNUM"]
+		if referencia == "" {
+			referencia = campos["REFNUM"]
+		}
+		if referencia == "" {
+			referencia = campos["FITID"]
+		}
+		descripcion := strings.TrimSpace(campos["NAME"] + " " + campos["MEMO"])
+
+		lineas = append(lineas, LineaEstadoCuenta{
+			Linea:       numero,
+			Fecha:       fecha,
+			Monto:       monto,
+			Referencia:  referencia,
+			Descripcion: descripcion,
+		})
+	}
+	return lineas, omitidas
+}
+
+// Indica si el archivo es OFX por su extension o su contenido
+func esOFX(nombre string, contenido []byte) bool {
+	extension := strings.ToLower(filepath.Ext(nombre))
+	if extension == ".ofx" || extension == ".qfx" {
+		return true
+	}
+	inicio := contenido
+	if len(inicio) > 4096 {
+		inicio = inicio[:4096]
+	}
+	return bytes.Contains(bytes.ToUpper(inicio), []byte("<OFX>"))
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Elige los registros de una linea entre los que coinciden en
+monto y fecha: si alguno tiene la misma referencia se toman
+solo esos, y si quedan varios pero uno solo es del mismo
+dia, se toma ese.
+*/
+func elegirCandidatos(linea LineaEstadoCuenta, indices []int, referencia func(int) string, fecha func(int) time.Time) ([]int, bool) {
+	porReferencia := false
+	if ref := normalizarReferencia(linea.Referencia); ref != "" {
+		conReferencia := []int{}
+		for _, i := range indices {
+			if mismaReferencia(ref, normalizarReferencia(referencia(i))) {
+				conReferencia = append(conReferencia, i)
+			}
+		}
+		if len(conReferencia) > 0 {
+			indices = conReferencia
+			porReferencia = true
+		}
+	}
+
+	if len(indices) > 1 {
+		mismoDia := []int{}
+		for _, i := range indices {
+			if diasEntre(fecha(i), linea.Fecha) == 0 {
+				mismoDia = append(mismoDia, i)
+			}
+		}
+		if len(mismoDia) == 1 {
+			indices = mismoDia
+		}
+	}
+	return indices, porReferencia
+}
+
+/*
+Concilia un estado de cuenta contra los movimientos
+bancarios y las cobranzas de Galac.
+Querys:
+
+codigoCtaBancaria: solo movimientos y cobranzas de esa cuenta
+moneda: CodigoMoneda de la cuenta, por defecto VED; solo se comparan cobranzas en esa moneda
+dias: dias de diferencia permitidos entre fechas (0 a 31, por defecto 3)
+
+Formulario (multipart/form-data):
+
+archivo: estado de cuenta en CSV u OFX, hasta 5 MB
+formato: csv u ofx; por defecto segun la extension o el contenido
+encabezado: no si el CSV no trae encabezado (por defecto si)
+columnaFecha, columnaMonto, columnaDebito, columnaCredito,
+columnaReferencia, columnaDescripcion: nombre del encabezado o numero de columna
+formatoFecha: por ejemplo DD/MM/AAAA
+separadorDecimal: , o .
+
+Resultado por linea:
+  - conciliadas: a lo sumo un movimiento y una cobranza
+  - ambiguas: varios movimientos o varias cobranzas posibles,
+    o un registro que tambien coincide con otra linea
+  - sinCoincidencia: ningun registro de Galac
+  - omitidas: lineas del archivo que no se pudieron leer
+*/
+func conciliarEstadoDeCuenta(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+		logError(requestID+" - Iniciando conciliación de estado de cuenta", nil)
+
+		// Antes de leer el formulario, que trae el archivo completo
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytesEstadoCuenta)
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		dias, ok := v.Entero(c.Query("dias"), "dias", 0, 31)
+		if !ok {
+			dias = diasVentanaPorDefecto
+		}
+		moneda := strings.ToUpper(strings.TrimSpace(c.DefaultQuery("moneda", monedas.MonedaLocal)))
+		if moneda == "" {
+			v.Agregar("moneda", "Indique el código de moneda de la cuenta")
+		}
+		formato, conFormato := v.Opcion(strings.ToLower(c.PostForm("formato")), "formato",
+			map[string]string{"csv": "csv", "ofx": "ofx"}, "Formato inválido. Use: csv u ofx")
+
+		var contenido []byte
+		archivo, err := c.FormFile("archivo")
+		if err == nil {
+			var f io.ReadCloser
+			if f, err = archivo.Open(); err == nil {
+				contenido, err = io.ReadAll(f)
+				f.Close()
+			}
+		}
+		var muyGrande *http.MaxBytesError
+		if errors.As(err, &muyGrande) {
+			logError(requestID+" - Estado de cuenta demasiado grande", err)
+			v.Agregar("archivo", "El archivo no puede superar "+strconv.Itoa(maxBytesEstadoCuenta>>20)+" MB")
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if err != nil {
+			logError(requestID+" - Error al leer el estado de cuenta", err)
+			v.Agregar("archivo", "Envíe el estado de cuenta (CSV u OFX) en el campo archivo")
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if !conFormato {
+			formato = "csv"
+			if esOFX(archivo.Filename, contenido) {
+				formato = "ofx"
+			}
+		}
+
+		var lineas []LineaEstadoCuenta
+		var omitidas []LineaOmitida
+		if formato == "ofx" {
+			lineas, omitidas = leerEstadoOFX(contenido, &v)
+		} else {
+			mapeo := leerMapeoCSV(c, &v)
+			if v.Valido() {
+				lineas, omitidas = leerEstadoCSV(contenido, mapeo, &v)
+			}
+		}
+		if v.Valido() && len(lineas) == 0 {
+			v.Agregar("archivo", "El estado de cuenta no tiene líneas válidas")
+		}
+		if !v.Valido() {
+			logError(requestID+" - Parámetros de conciliación de estado de cuenta inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		// ----- Registros de Galac en el rango del archivo ----- //
+		desde, hasta := lineas[0].Fecha, lineas[0].Fecha
+		for _, l := range lineas {
+			if l.Fecha.Before(desde) {
+				desde = l.Fecha
+			}
+			if l.Fecha.After(hasta) {
+				hasta = l.Fecha
+			}
+		}
+		params := []interface{}{
+			sql.Named("desde", desde.AddDate(0, 0, -dias)),
+			sql.Named("hasta", hasta.AddDate(0, 0, dias+1)),
+		}
+		filtroMovimientos := "m.Fecha >= @desde AND m.Fecha < @hasta"
+		filtroCobranzas := "Fecha >= @desde AND Fecha < @hasta AND FechaAnulacion IS NULL AND CodigoMoneda = @moneda"
+		params = append(params, sql.Named("moneda", moneda))
+
+		resultado := ResultadoEstadoCuenta{
+			Formato:         formato,
+			Moneda:          moneda,
+			Dias:            dias,
+			TotalLineas:     len(lineas),
+			Conciliadas:     []LineaConciliada{},
+			Ambiguas:        []LineaConciliada{},
+			SinCoincidencia: []LineaEstadoCuenta{},
+			Omitidas:        omitidas,
+		}
+		if cuenta := strings.TrimSpace(c.Query("codigoCtaBancaria")); cuenta != "" {
+			resultado.CodigoCtaBancaria = &cuenta
+			filtroMovimientos += " AND m.CodigoCtaBancaria = @cuenta"
+			filtroCobranzas += " AND CodigoCuentaBancaria = @cuenta"
+			params = append(params, sql.Named("cuenta", cuenta))
+		}
+
+		rows, err := db.Query(`
+			SELECT `+columnasMovimiento+`
+			FROM dbo.MovimientoBancario m
+			WHERE `+filtroMovimientos, params...)
+		if err != nil {
+			mensaje := "Error al consultar los movimientos bancarios"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		movimientos := []MovimientoBancario{}
+		for rows.Next() {
+			mb, err := escanearMovimiento(rows)
+			if err != nil {
+				mensaje := "Error al leer los movimientos bancarios"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			movimientos = append(movimientos, mb)
+		}
+		rows.Close()
+
+		rows, err = db.Query(`
+			SELECT ConsecutivoCompania, Numero, Fecha, StatusCobranza, CodigoCliente, TotalCobrado,
+			       NumerodelCheque, CodigoCuentaBancaria, CodigoMoneda
+			FROM dbo.Cobranza
+			WHERE `+filtroCobranzas, params...)
+		if err != nil {
+			mensaje := "Error al consultar las cobranzas"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		cobranzas := []CobranzaCandidata{}
+		for rows.Next() {
+			var co CobranzaCandidata
+			if err := rows.Scan(&co.ConsecutivoCompania, &co.Numero, &co.Fecha, &co.StatusCobranza, &co.CodigoCliente,
+				&co.TotalCobrado, &co.NumerodelCheque, &co.CodigoCuentaBancaria, &co.CodigoMoneda); err != nil {
+				mensaje := "Error al leer las cobranzas"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			cobranzas = append(cobranzas, co)
+		}
+
+		// ----- Coincidencias por linea ----- //
+		type coincidencia struct {
+			movimientos   []int
+			cobranzas     []int
+			porReferencia bool
+		}
+		refMovimiento := func(i int) string { return valorTexto(movimientos[i].NumeroDocumento) }
+		fechaMovimiento := func(i int) time.Time { return movimientos[i].Fecha }
+		refCobranza := func(i int) string { return valorTexto(cobranzas[i].NumerodelCheque) }
+		fechaCobranza := func(i int) time.Time { return cobranzas[i].Fecha }
+
+		coincidencias := make([]coincidencia, len(lineas))
+		usosMovimiento := map[int]int{}
+		usosCobranza := map[int]int{}
+		for n, l := range lineas {
+			var mov, cob []int
+			for i, mb := range movimientos {
+				// Sin TipoConcepto conocido no hay signo, se compara el valor absoluto
+				monto := mb.MontoConSigno
+				if mb.TipoDesconocido && mb.Monto != nil {
+					monto = math.Copysign(math.Abs(*mb.Monto), l.Monto)
+				}
+				if math.Abs(monto-l.Monto) <= toleranciaMonto && diasEntre(mb.Fecha, l.Fecha) <= dias {
+					mov = append(mov, i)
+				}
+			}
+			// Solo los creditos pueden ser una cobranza
+			if l.Monto > 0 {
+				for i, co := range cobranzas {
+					if co.TotalCobrado != nil && math.Abs(*co.TotalCobrado-l.Monto) <= toleranciaMonto &&
+						diasEntre(co.Fecha, l.Fecha) <= dias {
+						cob = append(cob, i)
+					}
+				}
+			}
+
+			var refMov, refCob bool
+			mov, refMov = elegirCandidatos(l, mov, refMovimiento, fechaMovimiento)
+			cob, refCob = elegirCandidatos(l, cob, refCobranza, fechaCobranza)
+			coincidencias[n] = coincidencia{mov, cob, refMov || refCob}
+			if len(mov) == 1 {
+				usosMovimiento[mov[0]]++
+			}
+			if len(cob) == 1 {
+				usosCobranza[cob[0]]++
+			}
+		}
+
+		// ----- Clasificacion ----- //
+		for n, l := range lineas {
+			co := coincidencias[n]
+			if len(co.movimientos) == 0 && len(co.cobranzas) == 0 {
+				resultado.SinCoincidencia = append(resultado.SinCoincidencia, l)
+				continue
+			}
+
+			conciliada := LineaConciliada{
+				LineaEstadoCuenta: l,
+				PorReferencia:     co.porReferencia,
+				Movimientos:       []MovimientoBancario{},
+				Cobranzas:         []CobranzaCandidata{},
+			}
+			for _, i := range co.movimientos {
+				conciliada.Movimientos = append(conciliada.Movimientos, movimientos[i])
+			}
+			for _, i := range co.cobranzas {
+				conciliada.Cobranzas = append(conciliada.Cobranzas, cobranzas[i])
+			}
+
+			// Un mismo registro de Galac no puede conciliar dos lineas
+			repetido := (len(co.movimientos) == 1 && usosMovimiento[co.movimientos[0]] > 1) ||
+				(len(co.cobranzas) == 1 && usosCobranza[co.cobranzas[0]] > 1)
+			if len(co.movimientos) > 1 || len(co.cobranzas) > 1 || repetido {
+				resultado.Ambiguas = append(resultado.Ambiguas, conciliada)
+			} else {
+				resultado.Conciliadas = append(resultado.Conciliadas, conciliada)
+			}
+		}
+
+		logError(requestID+" - Conciliación de estado de cuenta completada. Líneas: "+strconv.Itoa(len(lineas))+
+			", conciliadas: "+strconv.Itoa(len(resultado.Conciliadas))+
+			", ambiguas: "+strconv.Itoa(len(resultado.Ambiguas))+
+			", sin coincidencia: "+strconv.Itoa(len(resultado.SinCoincidencia))+
+			", omitidas: "+strconv.Itoa(len(resultado.Omitidas)), nil)
+
+		respuesta.Exito(c, "Conciliación de estado de cuenta completada", resultado, len(lineas))
+	}
+}
+
+// Valor de un texto opcional, vacio si es nil
+func valorTexto(texto *string) string {
+	if texto == nil {
+		return ""
+	}
+	return *texto
+}
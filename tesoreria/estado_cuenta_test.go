@@ -0,0 +1,162 @@
+package tesoreria
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+)
+
+func dia(texto string) time.Time {
+	f, _ := time.Parse("2006-01-02", texto)
+	return f
+}
+
+func TestLeerMonto(t *testing.T) {
+	casos := []struct {
+		texto    string
+		decimal  string
+		esperado float64
+		ok       bool
+	}{
+		{"1.234,56", "", 1234.56, true},
+		{"1,234.56", "", 1234.56, true},
+		{"1.234", "", 1234, true},
+		{"12,50", ",", 12.5, true},
+		{"1,234", ".", 1234, true},
+		{"(100,00)", "", -100, true},
+		{"100,00-", "", -100, true},
+		{"-1500.75", ".", -1500.75, true},
+		{"Bs. 1.000,00", "", 1000, true},
+		{"1234.567", ".", 1234.567, true},
+		{"1234.567", "", 1234567, true},
+		{"abc", "", 0, false},
+		{"", "", 0, false},
+	}
+
+	for _, caso := range casos {
+		monto, ok := leerMonto(caso.texto, caso.decimal)
+		if monto != caso.esperado || ok != caso.ok {
+			t.Errorf("leerMonto(%q, %q) = %v, %v; se esperaba %v, %v", caso.texto, caso.decimal, monto, ok, caso.esperado, caso.ok)
+		}
+	}
+}
+
+func TestLeerFecha(t *testing.T) {
+	casos := []struct {
+		texto    string
+		layout   string
+		esperado time.Time
+		ok       bool
+	}{
+		{"15/04/2025", "", dia("2025-04-15"), true},
+		{"2025-04-15 10:30", "", dia("2025-04-15"), true},
+		{"15-04-2025", "", dia("2025-04-15"), true},
+		{"15.04.2025", "", dia("2025-04-15"), true},
+		{"04/15/2025", "", time.Time{}, false},
+		{"04/15/2025", "01/02/2006", dia("2025-04-15"), true},
+		{"", "", time.Time{}, false},
+	}
+
+	for _, caso := range casos {
+		fecha, ok := leerFecha(caso.texto, caso.layout)
+		if !fecha.Equal(caso.esperado) || ok != caso.ok {
+			t.Errorf("leerFecha(%q, %q) = %v, %v; se esperaba %v, %v", caso.texto, caso.layout, fecha, ok, caso.esperado, caso.ok)
+		}
+	}
+}
+
+func TestMismaReferencia(t *testing.T) {
+	casos := []struct {
+		a, b     string
+		esperado bool
+	}{
+		{"12345", "12345", true},
+		{"9876543210", "3210", true},
+		{"3210", "9876543210", true},
+		{"9876543210", "210", false},
+		{"ABC123", "XYZ123", false},
+		{"", "", false},
+		{"12345", "", false},
+	}
+
+	for _, caso := range casos {
+		if obtenido := mismaReferencia(caso.a, caso.b); obtenido != caso.esperado {
+			t.Errorf("mismaReferencia(%q, %q) = %v, se esperaba %v", caso.a, caso.b, obtenido, caso.esperado)
+		}
+	}
+}
+
+// Mapeo por defecto: encabezado con fecha, monto, referencia y descripcion
+func mapeoPorDefecto() mapeoCSV {
+	mapeo := mapeoCSV{encabezado: true, columnas: map[string]string{}, indicadas: map[string]bool{}}
+	for campo, valor := range columnasPorDefecto {
+		mapeo.columnas[campo] = valor
+	}
+	return mapeo
+}
+
+func TestLeerEstadoCSV(t *testing.T) {
+	contenido := "Fecha;Monto;Referencia;Descripcion\n" +
+		"15/04/2025;1.234,56;000123;Deposito\n" +
+		";;;\n" +
+		"16/04/2025;abc;1;Comision\n" +
+		"31/04/2025;10,00;2;Fecha mala\n"
+
+	var v respuesta.Validador
+	lineas, omitidas := leerEstadoCSV([]byte(contenido), mapeoPorDefecto(), &v)
+	if !v.Valido() {
+		t.Fatalf("errores inesperados: %v", v.Errores())
+	}
+
+	esperadas := []LineaEstadoCuenta{
+		{Linea: 2, Fecha: dia("2025-04-15"), Monto: 1234.56, Referencia: "000123", Descripcion: "Deposito"},
+	}
+	if !reflect.DeepEqual(lineas, esperadas) {
+		t.Errorf("lineas = %+v, se esperaba %+v", lineas, esperadas)
+	}
+	esperadasOmitidas := []LineaOmitida{
+		{4, "Monto inválido: abc"},
+		{5, "Fecha inválida: 31/04/2025"},
+	}
+	if !reflect.DeepEqual(omitidas, esperadasOmitidas) {
+		t.Errorf("omitidas = %+v, se esperaba %+v", omitidas, esperadasOmitidas)
+	}
+}
+
+func TestLeerEstadoCSVMaximoLineas(t *testing.T) {
+	contenido := "fecha,monto\n" + strings.Repeat("15/04/2025,10.00\n", maxLineasEstadoCuenta+1)
+
+	var v respuesta.Validador
+	lineas, _ := leerEstadoCSV([]byte(contenido), mapeoPorDefecto(), &v)
+	if v.Valido() || lineas != nil {
+		t.Errorf("se esperaba error por exceso de lineas, valido %v y %d lineas", v.Valido(), len(lineas))
+	}
+}
+
+func TestLeerEstadoOFX(t *testing.T) {
+	contenido := "<OFX><BANKTRANLIST>\n" +
+		"<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20250415120000<TRNAMT>1234.567<FITID>ABC1<NAME>PAGO<MEMO>CLIENTE</STMTTRN>\n" +
+		"<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20250416<TRNAMT>-10.5<CHECKNUM>77<FITID>ABC2</STMTTRN>\n" +
+		"<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>2025<TRNAMT>-1.00</STMTTRN>\n" +
+		"</BANKTRANLIST></OFX>"
+
+	var v respuesta.Validador
+	lineas, omitidas := leerEstadoOFX([]byte(contenido), &v)
+	if !v.Valido() {
+		t.Fatalf("errores inesperados: %v", v.Errores())
+	}
+
+	esperadas := []LineaEstadoCuenta{
+		{Linea: 1, Fecha: dia("2025-04-15"), Monto: 1234.567, Referencia: "ABC1", Descripcion: "PAGO CLIENTE"},
+		{Linea: 2, Fecha: dia("2025-04-16"), Monto: -10.5, Referencia: "77"},
+	}
+	if !reflect.DeepEqual(lineas, esperadas) {
+		t.Errorf("lineas = %+v, se esperaba %+v", lineas, esperadas)
+	}
+	if !reflect.DeepEqual(omitidas, []LineaOmitida{{3, "Transacción sin DTPOSTED"}}) {
+		t.Errorf("omitidas = %+v", omitidas)
+	}
+}
@@ -0,0 +1,74 @@
+package tesoreria
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/gin-gonic/gin"
+)
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Logger Interno para el registro de errores y problemas.
+	Solo se instancia en este modulo y generar el archivo
+	errores_tesoreria.log
+*/
+
+// Logger para registrar errores en un archivo
+var errorLogger *log.Logger
+
+func initErrorLogger() {
+	logFile, err := os.OpenFile("errores_tesoreria.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Println("Error al abrir archivo de log de tesoreria:", err)
+		return
+	}
+	errorLogger = log.New(logFile, "", log.Ldate|log.Ltime)
+	log.Println("Logger de errores de tesoreria inicializado correctamente")
+}
+
+func logError(mensaje string, err error) {
+	if errorLogger != nil {
+		errorLogger.Printf("[ERROR] %s: %v\n", mensaje, err)
+	} else {
+		log.Printf("[ERROR] %s: %v\n", mensaje, err)
+	}
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Anticipos de clientes y a proveedores (dbo.anticipo) y
+	su uso en cobranzas y pagos. Todo es de solo lectura.
+*/
+
+// Tiempo maximo que se guarda en cache una consulta de tesoreria
+const ttlCacheTesoreria = 5 * time.Minute
+
+// Tablas que leen los anticipos con sus aplicaciones
+var tablasAnticipo = []string{"anticipo", "anticipoCobrado", "Cobranza", "anticipoPagado", "Pago"}
+
+func AnticipoRoutes(api *gin.RouterGroup, db *sql.DB) {
+	initErrorLogger()
+	api.GET("/", cache.Respuestas(db, ttlCacheTesoreria, tablasAnticipo...), buscarAnticipos(db))
+	api.GET("/saldos", cache.Respuestas(db, ttlCacheTesoreria, "anticipo"), saldosAnticipos(db))
+	api.GET("/:consecutivo", cache.Respuestas(db, ttlCacheTesoreria, tablasAnticipo...), detalleAnticipo(db))
+}
+
+// Movimientos bancarios y conciliaciones
+func BancoRoutes(api *gin.RouterGroup, db *sql.DB) {
+	api.GET("/movimientos", cache.Respuestas(db, ttlCacheTesoreria, "MovimientoBancario"), buscarMovimientosBancarios(db))
+	api.GET("/movimientos/saldos", cache.Respuestas(db, ttlCacheTesoreria, "MovimientoBancario"), saldosCuentasBancarias(db))
+	api.GET("/movimientos/saldos/:cuenta", cache.Respuestas(db, ttlCacheTesoreria, "MovimientoBancario"), saldoCorridoCuenta(db))
+	api.GET("/conciliaciones", cache.Respuestas(db, ttlCacheTesoreria, "Conciliacion"), buscarConciliaciones(db))
+	api.GET("/conciliaciones/:numero", cache.Respuestas(db, ttlCacheTesoreria, "Conciliacion", "DetalleDeConciliacion", "MovimientoBancario"), detalleConciliacion(db))
+	api.POST("/estado-de-cuenta/conciliacion", conciliarEstadoDeCuenta(db))
+}
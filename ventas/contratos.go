@@ -0,0 +1,767 @@
+package ventas
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Contratos de servicio (dbo.Contrato y dbo.RenglonContrato).
+
+	Galac genera las facturas recurrentes de cada contrato y
+	deja en dbo.mesGenerado la fecha de cada generacion. Un mes
+	se considera facturado si tiene una generacion o una
+	factura vigente con el contrato en NoContrato.
+
+	Cada renglon espera un periodo de tantos meses como indique
+	su periodicidad, desde su primera factura (o su inicio)
+	hasta su fecha final o la fecha de corte. El periodo queda
+	facturado si algun mes dentro de el lo esta, asi una
+	generacion trimestral corrida un mes no se marca pendiente.
+*/
+
+/*
+	Codigos de contrato que el esquema de Galac no documenta.
+
+	Solo los nombres de periodicidad se conocen. Los codigos
+	numericos de Periodicidad y los StatusContrato activos se
+	configuran en codigos_galac.json:
+
+	{"contratos": {"periodicidad": {"0": 1, "2": 3}, "statusActivo": ["0"]}}
+
+	Una periodicidad que no este configurada no se asume
+	mensual: el renglon se marca y no genera meses pendientes.
+	Sin statusActivo (configurado o en el query) no se sabe si
+	un contrato esta activo: activo queda en null y los meses
+	pendientes se calculan igual.
+*/
+
+// Meses entre facturas segun la Periodicidad del renglon
+var mesesPorPeriodicidad = map[string]int{
+	"MENSUAL":       1,
+	"BIMESTRAL":     2,
+	"TRIMESTRAL":    3,
+	"CUATRIMESTRAL": 4,
+	"SEMESTRAL":     6,
+	"ANUAL":         12,
+}
+
+// StatusContrato activos configurados
+var statusContratoActivo []string
+
+/*
+Lee los codigos de contrato desde el archivo. Si el archivo
+no existe solo se conocen los nombres de periodicidad; si
+existe pero no es valido se retorna el error.
+*/
+func CargarCodigosContrato(ruta string) error {
+	contenido, err := os.ReadFile(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("Sin codigos de contrato configurados (" + ruta + ")")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var archivo struct {
+		Contratos struct {
+			Periodicidad map[string]int `json:"periodicidad"`
+			StatusActivo []string       `json:"statusActivo"`
+		} `json:"contratos"`
+	}
+	if err := json.Unmarshal(contenido, &archivo); err != nil {
+		return err
+	}
+
+	for codigo, meses := range archivo.Contratos.Periodicidad {
+		if meses < 1 {
+			return fmt.Errorf("contratos: la periodicidad %q debe tener al menos 1 mes", codigo)
+		}
+		mesesPorPeriodicidad[strings.ToUpper(strings.TrimSpace(codigo))] = meses
+	}
+	statusContratoActivo = nil
+	for _, status := range archivo.Contratos.StatusActivo {
+		if status = strings.TrimSpace(status); status != "" {
+			statusContratoActivo = append(statusContratoActivo, status)
+		}
+	}
+	log.Println("Codigos de contrato cargados: " + strconv.Itoa(len(archivo.Contratos.Periodicidad)) +
+		" periodicidades y " + strconv.Itoa(len(statusContratoActivo)) + " status activos")
+	return nil
+}
+
+const formatoMes = "2006-01"
+
+type RenglonContrato struct {
+	ConsecutivoContrato int        `json:"consecutivoContrato"`
+	Articulo            *string    `json:"articulo"`
+	Descripcion         *string    `json:"descripcion"`
+	Cantidad            float64    `json:"cantidad"`
+	Imponible           *float64   `json:"imponible"`
+	PorcentajeDescuento *float64   `json:"porcentajeDescuento"`
+	Periodicidad        *string    `json:"periodicidad"`
+	PeriodoDeAplicacion *string    `json:"periodoDeAplicacion"`
+	FechaDeInicio       *time.Time `json:"fechaDeInicio"`
+	FechaFinal          *time.Time `json:"fechaFinal"`
+	FechaPrimeraFactura *time.Time `json:"fechaPrimeraFactura"`
+
+	// La Periodicidad no esta en mesesPorPeriodicidad
+	PeriodicidadDesconocida bool `json:"periodicidadDesconocida"`
+}
+
+// Factura emitida con el contrato en NoContrato
+type FacturaDeContrato struct {
+	Numero        string    `json:"numero"`
+	Fecha         time.Time `json:"fecha"`
+	StatusFactura *string   `json:"statusFactura"`
+	TotalFactura  *float64  `json:"totalFactura"`
+}
+
+type Contrato struct {
+	ConsecutivoCompania    int        `json:"consecutivoCompania"`
+	NumeroContrato         string     `json:"numeroContrato"`
+	StatusContrato         *string    `json:"statusContrato"`
+	CodigoCliente          *string    `json:"codigoCliente"`
+	CodigoClienteAFacturar *string    `json:"codigoClienteAFacturar"`
+	DuracionDelContrato    *string    `json:"duracionDelContrato"`
+	FechaDeInicio          *time.Time `json:"fechaDeInicio"`
+	FechaFinal             *time.Time `json:"fechaFinal"`
+	Observaciones          *string    `json:"observaciones"`
+	CodigoMoneda           string     `json:"codigoMoneda"`
+	CodigoVendedor         *string    `json:"codigoVendedor"`
+	NombreVendedor         *string    `json:"nombreVendedor"`
+
+	// null si no se conocen los status activos. Los meses
+	// pendientes no se calculan si es false
+	Activo *bool `json:"activo"`
+
+	Renglones                  []RenglonContrato `json:"renglones"`
+	MesesGenerados             []string          `json:"mesesGenerados"`             // AAAA-MM
+	MesesPendientes            []string          `json:"mesesPendientes"`            // AAAA-MM
+	PeriodicidadesDesconocidas []string          `json:"periodicidadesDesconocidas"` // valores sin equivalencia en meses
+}
+
+type DetalleContrato struct {
+	Contrato
+	Facturas []FacturaDeContrato `json:"facturas"`
+}
+
+// Contrato con meses sin generar, para /contratos/pendientes
+type ContratoPendiente struct {
+	ConsecutivoCompania        int      `json:"consecutivoCompania"`
+	NumeroContrato             string   `json:"numeroContrato"`
+	StatusContrato             *string  `json:"statusContrato"`
+	Activo                     *bool    `json:"activo"`
+	CodigoCliente              *string  `json:"codigoCliente"`
+	UltimoMesGenerado          *string  `json:"ultimoMesGenerado"`
+	MesesPendientes            []string `json:"mesesPendientes"`
+	PeriodicidadesDesconocidas []string `json:"periodicidadesDesconocidas"`
+}
+
+const columnasContrato = `
+	ct.ConsecutivoCompania, ct.NumeroContrato, ct.StatusContrato, ct.CodigoCliente, ct.FacturarAOtroCliente,
+	ct.CodigoClienteAFacturar, ct.DuracionDelContrato, ct.FechaDeInicio, ct.FechaFinal, ct.Observaciones,
+	ct.CodigoMoneda, ct.CodigoVendedor, ct.NombreVendedor`
+
+// Un contrato se identifica por compania y numero
+type claveContrato struct {
+	compania int
+	numero   string
+}
+
+func escanearContrato(row escaner) (Contrato, error) {
+	var ct Contrato
+	var facturarAOtro string
+	err := row.Scan(
+		&ct.ConsecutivoCompania, &ct.NumeroContrato, &ct.StatusContrato, &ct.CodigoCliente, &facturarAOtro,
+		&ct.CodigoClienteAFacturar, &ct.DuracionDelContrato, &ct.FechaDeInicio, &ct.FechaFinal, &ct.Observaciones,
+		&ct.CodigoMoneda, &ct.CodigoVendedor, &ct.NombreVendedor,
+	)
+	if facturarAOtro != "S" {
+		ct.CodigoClienteAFacturar = nil
+	}
+	ct.Renglones = []RenglonContrato{}
+	ct.MesesGenerados = []string{}
+	ct.MesesPendientes = []string{}
+	ct.PeriodicidadesDesconocidas = []string{}
+	return ct, err
+}
+
+// Primer dia del mes de la fecha
+func inicioDeMes(fecha time.Time) time.Time {
+	return time.Date(fecha.Year(), fecha.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Periodo que debe tener al menos un mes facturado
+type periodoEsperado struct {
+	desde time.Time
+	meses int
+}
+
+/*
+Meses de cada periodo segun la Periodicidad. Retorna false si
+el valor falta o no esta en mesesPorPeriodicidad.
+*/
+func periodicidadEnMeses(periodicidad *string) (int, bool) {
+	if periodicidad == nil {
+		return 0, false
+	}
+	meses, ok := mesesPorPeriodicidad[strings.ToUpper(strings.TrimSpace(*periodicidad))]
+	return meses, ok
+}
+
+/*
+Periodos en los que el contrato debia facturarse hasta el
+corte. Cada renglon aporta un periodo cada tantos meses como
+indique su periodicidad; los de periodicidad desconocida no
+aportan ninguno.
+*/
+func periodosEsperados(ct Contrato, corte time.Time) []periodoEsperado {
+	limite := inicioDeMes(corte)
+	periodos := []periodoEsperado{}
+
+	for _, r := range ct.Renglones {
+		paso, ok := periodicidadEnMeses(r.Periodicidad)
+		if !ok {
+			continue
+		}
+
+		inicio := r.FechaPrimeraFactura
+		if inicio == nil {
+			inicio = r.FechaDeInicio
+		}
+		if inicio == nil {
+			inicio = ct.FechaDeInicio
+		}
+		if inicio == nil {
+			continue
+		}
+
+		fin := limite
+		final := r.FechaFinal
+		if final == nil {
+			final = ct.FechaFinal
+		}
+		if final != nil && inicioDeMes(*final).Before(fin) {
+			fin = inicioDeMes(*final)
+		}
+
+		for mes := inicioDeMes(*inicio); !mes.After(fin); mes = mes.AddDate(0, paso, 0) {
+			periodos = append(periodos, periodoEsperado{mes, paso})
+		}
+	}
+
+	return periodos
+}
+
+/*
+Meses pendientes del contrato, ordenados: el primer mes de
+cada periodo esperado sin ningun mes facturado dentro.
+*/
+func mesesPendientes(ct Contrato, corte time.Time, facturados map[time.Time]bool) []time.Time {
+	pendientes := map[time.Time]bool{}
+	for _, p := range periodosEsperados(ct, corte) {
+		facturado := false
+		for mes := p.desde; mes.Before(p.desde.AddDate(0, p.meses, 0)); mes = mes.AddDate(0, 1, 0) {
+			if facturados[mes] {
+				facturado = true
+				break
+			}
+		}
+		if !facturado {
+			pendientes[p.desde] = true
+		}
+	}
+
+	meses := make([]time.Time, 0, len(pendientes))
+	for mes := range pendientes {
+		meses = append(meses, mes)
+	}
+	sort.Slice(meses, func(i, j int) bool { return meses[i].Before(meses[j]) })
+	return meses
+}
+
+/*
+Carga los renglones, los meses generados y las facturas de
+los contratos que cumplen el filtro (alias ct) y calcula los
+meses pendientes hasta el corte. Retorna las facturas por
+contrato para el detalle.
+*/
+func completarContratos(db *sql.DB, contratos []Contrato, filterQuery string, params []interface{}, corte time.Time, activos map[string]bool) (map[claveContrato][]FacturaDeContrato, error) {
+	posicion := map[claveContrato]int{}
+	for i, ct := range contratos {
+		posicion[claveContrato{ct.ConsecutivoCompania, ct.NumeroContrato}] = i
+	}
+
+	// ----- Renglones ----- //
+	rows, err := db.Query(`
+		SELECT r.ConsecutivoCompania, r.NumeroContrato, r.ConsecutivoContrato, r.Articulo, r.Descripcion,
+		       r.Cantidad, r.Imponible, r.PorcentajeDescuento, r.Periodicidad, r.PeriodoDeAplicacion,
+		       r.FechaDeInicio, r.FechaFinal, r.FechaPrimeraFactura
+		FROM dbo.RenglonContrato r
+		INNER JOIN dbo.Contrato ct
+		        ON ct.ConsecutivoCompania = r.ConsecutivoCompania AND ct.NumeroContrato = r.NumeroContrato
+		`+filterQuery+`
+		ORDER BY r.ConsecutivoContrato
+	`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clave claveContrato
+		var r RenglonContrato
+		if err := rows.Scan(&clave.compania, &clave.numero, &r.ConsecutivoContrato, &r.Articulo, &r.Descripcion,
+			&r.Cantidad, &r.Imponible, &r.PorcentajeDescuento, &r.Periodicidad, &r.PeriodoDeAplicacion,
+			&r.FechaDeInicio, &r.FechaFinal, &r.FechaPrimeraFactura); err != nil {
+			return nil, err
+		}
+		i, ok := posicion[clave]
+		if !ok {
+			continue
+		}
+		if _, conocida := periodicidadEnMeses(r.Periodicidad); !conocida {
+			r.PeriodicidadDesconocida = true
+			valor := ""
+			if r.Periodicidad != nil {
+				valor = strings.TrimSpace(*r.Periodicidad)
+			}
+			if !contiene(contratos[i].PeriodicidadesDesconocidas, valor) {
+				contratos[i].PeriodicidadesDesconocidas = append(contratos[i].PeriodicidadesDesconocidas, valor)
+			}
+		}
+		contratos[i].Renglones = append(contratos[i].Renglones, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Meses facturados por generacion o por factura
+	facturados := map[claveContrato]map[time.Time]bool{}
+	marcar := func(clave claveContrato, fecha time.Time) {
+		if facturados[clave] == nil {
+			facturados[clave] = map[time.Time]bool{}
+		}
+		facturados[clave][inicioDeMes(fecha)] = true
+	}
+
+	// ----- Meses generados ----- //
+	rows, err = db.Query(`
+		SELECT m.ConsecutivoCompania, m.NumeroContrato, m.FechaDeGeneracion
+		FROM dbo.mesGenerado m
+		INNER JOIN dbo.Contrato ct
+		        ON ct.ConsecutivoCompania = m.ConsecutivoCompania AND ct.NumeroContrato = m.NumeroContrato
+		`+filterQuery+`
+		ORDER BY m.FechaDeGeneracion
+	`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clave claveContrato
+		var fecha time.Time
+		if err := rows.Scan(&clave.compania, &clave.numero, &fecha); err != nil {
+			return nil, err
+		}
+		i, ok := posicion[clave]
+		if !ok {
+			continue
+		}
+		mes := fecha.Format(formatoMes)
+		if n := len(contratos[i].MesesGenerados); n == 0 || contratos[i].MesesGenerados[n-1] != mes {
+			contratos[i].MesesGenerados = append(contratos[i].MesesGenerados, mes)
+		}
+		marcar(clave, fecha)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// ----- Facturas del contrato ----- //
+	rows, err = db.Query(`
+		SELECT f.ConsecutivoCompania, f.NoContrato, f.Numero, f.Fecha, f.StatusFactura, f.TotalFactura
+		FROM dbo.factura f
+		INNER JOIN dbo.Contrato ct
+		        ON ct.ConsecutivoCompania = f.ConsecutivoCompania AND ct.NumeroContrato = f.NoContrato
+		`+filterQuery+`
+		  AND `+condicionFacturaVigente+`
+		ORDER BY f.Fecha, f.Numero
+	`, append(params, paramsFacturaVigente()...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facturas := map[claveContrato][]FacturaDeContrato{}
+	for rows.Next() {
+		var clave claveContrato
+		var f FacturaDeContrato
+		if err := rows.Scan(&clave.compania, &clave.numero, &f.Numero, &f.Fecha, &f.StatusFactura, &f.TotalFactura); err != nil {
+			return nil, err
+		}
+		facturas[clave] = append(facturas[clave], f)
+		marcar(clave, f.Fecha)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// ----- Meses pendientes ----- //
+	for i := range contratos {
+		ct := &contratos[i]
+		if ct.StatusContrato == nil {
+			activo := true
+			ct.Activo = &activo
+		} else if activos != nil {
+			activo := activos[strings.TrimSpace(*ct.StatusContrato)]
+			ct.Activo = &activo
+		}
+		if ct.Activo != nil && !*ct.Activo {
+			continue
+		}
+		clave := claveContrato{ct.ConsecutivoCompania, ct.NumeroContrato}
+		for _, mes := range mesesPendientes(*ct, corte, facturados[clave]) {
+			ct.MesesPendientes = append(ct.MesesPendientes, mes.Format(formatoMes))
+		}
+	}
+
+	return facturas, nil
+}
+
+/*
+Lee la fecha de corte (query corte, AAAA-MM-DD). Por defecto
+es hoy; se esperan facturas hasta el mes del corte incluido.
+*/
+func fechaCorte(c *gin.Context, v *respuesta.Validador) time.Time {
+	if corte := v.Fecha(c.Query("corte"), "corte"); corte != nil {
+		return *corte
+	}
+	return time.Now()
+}
+
+/*
+Lee los StatusContrato que cuentan como activos (query
+statusActivo, separados por coma). Por defecto los
+configurados; si no hay ninguno retorna nil y no se sabe
+que contratos estan activos.
+*/
+func statusActivos(c *gin.Context) map[string]bool {
+	valores := statusContratoActivo
+	if valor := c.Query("statusActivo"); valor != "" {
+		valores = strings.Split(valor, ",")
+	}
+	activos := map[string]bool{}
+	for _, valor := range valores {
+		if valor = strings.TrimSpace(valor); valor != "" {
+			activos[valor] = true
+		}
+	}
+	if len(activos) == 0 {
+		return nil
+	}
+	return activos
+}
+
+// Indica si el valor esta en la lista
+func contiene(lista []string, valor string) bool {
+	for _, v := range lista {
+		if v == valor {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Lee los filtros de contratos:
+
+codigoCliente: codigo exacto del cliente
+status: valor exacto de StatusContrato
+codigoVendedor: codigo exacto del vendedor
+
+Retorna el WHERE (alias ct) y sus parametros.
+*/
+func filtrosContrato(c *gin.Context) (string, []interface{}, map[string]interface{}) {
+	filterQuery := " WHERE 1=1"
+	params := []interface{}{}
+	aplicados := map[string]interface{}{}
+
+	for _, filtro := range []struct{ query, columna string }{
+		{"codigoCliente", "ct.CodigoCliente"},
+		{"status", "ct.StatusContrato"},
+		{"codigoVendedor", "ct.CodigoVendedor"},
+	} {
+		if valor := strings.TrimSpace(c.Query(filtro.query)); valor != "" {
+			filterQuery += " AND " + filtro.columna + " = @" + filtro.query
+			params = append(params, sql.Named(filtro.query, valor))
+			aplicados[filtro.query] = valor
+		}
+	}
+
+	return filterQuery, params, aplicados
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Lista de contratos con sus renglones y meses pendientes.
+Querys: los de filtrosContrato mas
+
+corte: fecha hasta la que se esperan facturas, por defecto hoy
+statusActivo: StatusContrato activos separados por coma, por defecto los configurados
+page, pageSize: paginacion, por defecto 1 y 100 (maximo 1000)
+*/
+func buscarContratos(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		corte := fechaCorte(c, &v)
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize, ok := v.Entero(c.Query("pageSize"), "pageSize", 1, 1000)
+		if !ok {
+			pageSize = 100
+		}
+		if !v.Valido() {
+			logError(requestID+" - Parámetros de búsqueda de contratos inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		filterQuery, params, aplicados := filtrosContrato(c)
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.Contrato ct"+filterQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de contratos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron contratos con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		rows, err := db.Query(`
+			SELECT `+columnasContrato+`
+			FROM dbo.Contrato ct`+filterQuery+`
+			ORDER BY ct.ConsecutivoCompania, ct.NumeroContrato
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`, append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)...)
+		if err != nil {
+			mensaje := "Error al consultar los contratos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		contratos := []Contrato{}
+		for rows.Next() {
+			ct, err := escanearContrato(rows)
+			if err != nil {
+				mensaje := "Error al leer datos de contratos"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			contratos = append(contratos, ct)
+		}
+		rows.Close()
+
+		if len(contratos) == 0 {
+			respuesta.SinResultados(c, "No hay contratos en la página "+strconv.Itoa(page))
+			return
+		}
+
+		// Los datos relacionados se limitan a los contratos de la pagina
+		nombres := make([]string, len(contratos))
+		paramsPagina := []interface{}{}
+		for i, ct := range contratos {
+			nombre := "n" + strconv.Itoa(i)
+			nombres[i] = "@" + nombre
+			paramsPagina = append(paramsPagina, sql.Named(nombre, ct.NumeroContrato))
+		}
+		filtroPagina := " WHERE ct.NumeroContrato IN (" + strings.Join(nombres, ", ") + ")"
+
+		if _, err := completarContratos(db, contratos, filtroPagina, paramsPagina, corte, statusActivos(c)); err != nil {
+			mensaje := "Error al consultar los renglones y meses de los contratos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		aplicados["corte"] = corte.Format("2006-01-02")
+		respuesta.Pagina(c, "Contratos encontrados", contratos, len(contratos),
+			respuesta.NuevaPaginacion(total, page, pageSize), aplicados)
+	}
+}
+
+/*
+Contratos activos (o de status desconocido) con meses
+pendientes de generar hasta el corte, o con renglones de periodicidad desconocida que no se
+pudieron revisar.
+Querys: los de filtrosContrato, corte y statusActivo.
+*/
+func contratosPendientes(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		var v respuesta.Validador
+		corte := fechaCorte(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		filterQuery, params, _ := filtrosContrato(c)
+
+		rows, err := db.Query(`
+			SELECT `+columnasContrato+`
+			FROM dbo.Contrato ct`+filterQuery+`
+			ORDER BY ct.ConsecutivoCompania, ct.NumeroContrato
+		`, params...)
+		if err != nil {
+			mensaje := "Error al consultar los contratos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		contratos := []Contrato{}
+		for rows.Next() {
+			ct, err := escanearContrato(rows)
+			if err != nil {
+				mensaje := "Error al leer datos de contratos"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			contratos = append(contratos, ct)
+		}
+		rows.Close()
+
+		if _, err := completarContratos(db, contratos, filterQuery, params, corte, statusActivos(c)); err != nil {
+			mensaje := "Error al consultar los renglones y meses de los contratos"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		pendientes := []ContratoPendiente{}
+		for _, ct := range contratos {
+			if (ct.Activo != nil && !*ct.Activo) || (len(ct.MesesPendientes) == 0 && len(ct.PeriodicidadesDesconocidas) == 0) {
+				continue
+			}
+			p := ContratoPendiente{
+				ConsecutivoCompania:        ct.ConsecutivoCompania,
+				NumeroContrato:             ct.NumeroContrato,
+				StatusContrato:             ct.StatusContrato,
+				Activo:                     ct.Activo,
+				CodigoCliente:              ct.CodigoCliente,
+				MesesPendientes:            ct.MesesPendientes,
+				PeriodicidadesDesconocidas: ct.PeriodicidadesDesconocidas,
+			}
+			if n := len(ct.MesesGenerados); n > 0 {
+				p.UltimoMesGenerado = &ct.MesesGenerados[n-1]
+			}
+			pendientes = append(pendientes, p)
+		}
+
+		if len(pendientes) == 0 {
+			respuesta.SinResultados(c, "No hay contratos con meses pendientes al "+corte.Format("2006-01-02"))
+			return
+		}
+
+		respuesta.Exito(c, "Contratos con meses pendientes al "+corte.Format("2006-01-02"), pendientes, len(pendientes))
+	}
+}
+
+/*
+Detalle de un contrato con renglones, meses generados,
+facturas y meses pendientes. Si el numero existe en varias
+companias y no se indica compania se responde 409.
+Querys: compania, corte, por defecto hoy, y statusActivo.
+*/
+func detalleContrato(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		numero := strings.TrimSpace(c.Param("numero"))
+
+		var v respuesta.Validador
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		corte := fechaCorte(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filtro, params := respuesta.FiltroCompania("ct.NumeroContrato = @numero", "ct.ConsecutivoCompania",
+			[]interface{}{sql.Named("numero", numero)}, compania, conCompania)
+		encontrados, err := respuesta.LeerUnico(db, `
+			SELECT TOP 2 `+columnasContrato+`
+			FROM dbo.Contrato ct
+			WHERE `+filtro+`
+			ORDER BY ct.ConsecutivoCompania
+		`, params, func(rows *sql.Rows) (Contrato, error) { return escanearContrato(rows) })
+		if err != nil {
+			mensaje := "Error al consultar el contrato"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		ct, ok := respuesta.Unico(c, encontrados, "el contrato "+numero)
+		if !ok {
+			return
+		}
+
+		contratos := []Contrato{ct}
+		facturas, err := completarContratos(db, contratos,
+			" WHERE ct.ConsecutivoCompania = @compania AND ct.NumeroContrato = @numero",
+			[]interface{}{sql.Named("compania", ct.ConsecutivoCompania), sql.Named("numero", ct.NumeroContrato)},
+			corte, statusActivos(c))
+		if err != nil {
+			mensaje := "Error al consultar los renglones y meses del contrato"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		detalle := DetalleContrato{Contrato: contratos[0], Facturas: []FacturaDeContrato{}}
+		if f, ok := facturas[claveContrato{ct.ConsecutivoCompania, ct.NumeroContrato}]; ok {
+			detalle.Facturas = f
+		}
+
+		respuesta.Exito(c, "Detalle del contrato "+numero, detalle, 1)
+	}
+}
@@ -0,0 +1,461 @@
+package ventas
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/respuesta"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Cotizaciones (dbo.cotizacion y dbo.renglonCotizacion).
+
+	Una cotizacion se convierte cuando alguna factura la
+	referencia en NoCotizacionDeOrigen. Solo cuentan las
+	facturas (TipoDeDocumento 0) vigentes: no borradores ni
+	canceladas.
+*/
+
+// Factura generada a partir de una cotizacion
+type FacturaDeCotizacion struct {
+	Numero        string    `json:"numero"`
+	Fecha         time.Time `json:"fecha"`
+	StatusFactura *string   `json:"statusFactura"`
+	Cancelada     bool      `json:"cancelada"`
+	TotalFactura  *float64  `json:"totalFactura"`
+	Vigente       bool      `json:"vigente"`
+}
+
+type Cotizacion struct {
+	ConsecutivoCompania  int        `json:"consecutivoCompania"`
+	Numero               string     `json:"numero"`
+	Fecha                time.Time  `json:"fecha"`
+	CodigoCliente        *string    `json:"codigoCliente"`
+	CodigoVendedor       *string    `json:"codigoVendedor"`
+	Observaciones        *string    `json:"observaciones"`
+	EstadoDeLaCotizacion *string    `json:"estadoDeLaCotizacion"`
+	CodigoMoneda         string     `json:"codigoMoneda"`
+	TotalMontoExento     *float64   `json:"totalMontoExento"`
+	TotalBaseImponible   *float64   `json:"totalBaseImponible"`
+	TotalIVA             *float64   `json:"totalIva"`
+	TotalCotizacion      *float64   `json:"totalCotizacion"`
+	FechaDeRetiro        *time.Time `json:"fechaDeRetiro"`
+
+	Convertida bool                  `json:"convertida"`
+	Facturas   []FacturaDeCotizacion `json:"facturas"`
+}
+
+type RenglonCotizacion struct {
+	ConsecutivoRenglon  int      `json:"consecutivoRenglon"`
+	CodigoArticulo      *string  `json:"codigoArticulo"`
+	Descripcion         *string  `json:"descripcion"`
+	AlicuotaIVA         *string  `json:"alicuotaIva"`
+	Cantidad            float64  `json:"cantidad"`
+	PrecioSinIVA        *float64 `json:"precioSinIva"`
+	PrecioConIVA        *float64 `json:"precioConIva"`
+	PorcentajeDescuento *float64 `json:"porcentajeDescuento"`
+	TotalRenglon        *float64 `json:"totalRenglon"`
+	CantidadDespachada  *float64 `json:"cantidadDespachada"`
+}
+
+type DetalleCotizacion struct {
+	Cotizacion
+	Renglones []RenglonCotizacion `json:"renglones"`
+}
+
+// Cotizaciones y conversiones de un vendedor en un mes
+type ConversionCotizaciones struct {
+	CodigoVendedor string  `json:"codigoVendedor"`
+	Mes            string  `json:"mes"` // AAAA-MM
+	Cotizaciones   int     `json:"cotizaciones"`
+	Convertidas    int     `json:"convertidas"`
+	Porcentaje     float64 `json:"porcentaje"`
+}
+
+type ReporteConversion struct {
+	Grupos       []ConversionCotizaciones `json:"grupos"`
+	Cotizaciones int                      `json:"cotizaciones"`
+	Convertidas  int                      `json:"convertidas"`
+	Porcentaje   float64                  `json:"porcentaje"`
+}
+
+const columnasCotizacion = `
+	ConsecutivoCompania, Numero, Fecha, CodigoCliente, CodigoVendedor, Observaciones,
+	EstadoDeLaCotizacion, CodigoMoneda, TotalMontoExento, TotalBaseImponible, TotalIVA,
+	TotalCotizacion, FechaDeRetiro`
+
+// Condicion SQL de cotizacion convertida, para la cotizacion con alias c
+const condicionConvertida = `EXISTS (
+	SELECT 1 FROM dbo.factura f
+	WHERE f.ConsecutivoCompania = c.ConsecutivoCompania
+	  AND f.NoCotizacionDeOrigen = c.Numero
+	  AND ` + condicionFacturaVigente + `)`
+
+type escaner interface {
+	Scan(dest ...interface{}) error
+}
+
+func escanearCotizacion(row escaner) (Cotizacion, error) {
+	var ct Cotizacion
+	err := row.Scan(
+		&ct.ConsecutivoCompania, &ct.Numero, &ct.Fecha, &ct.CodigoCliente, &ct.CodigoVendedor, &ct.Observaciones,
+		&ct.EstadoDeLaCotizacion, &ct.CodigoMoneda, &ct.TotalMontoExento, &ct.TotalBaseImponible, &ct.TotalIVA,
+		&ct.TotalCotizacion, &ct.FechaDeRetiro,
+	)
+	ct.Facturas = []FacturaDeCotizacion{}
+	return ct, err
+}
+
+// Porcentaje con dos decimales, 0 si no hay cotizaciones
+func porcentaje(parte, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(parte)*10000/float64(total)) / 100
+}
+
+/*
+Agrega a las cotizaciones las facturas que las referencian.
+Se buscan por numero y se filtran por compania.
+*/
+func facturasDeCotizaciones(db *sql.DB, cotizaciones []Cotizacion) error {
+	if len(cotizaciones) == 0 {
+		return nil
+	}
+
+	nombres := make([]string, len(cotizaciones))
+	params := paramsFacturaVigente()
+	posicion := map[string][]int{}
+	for i, ct := range cotizaciones {
+		nombre := "n" + strconv.Itoa(i)
+		nombres[i] = "@" + nombre
+		params = append(params, sql.Named(nombre, ct.Numero))
+		posicion[ct.Numero] = append(posicion[ct.Numero], i)
+	}
+
+	rows, err := db.Query(`
+		SELECT ConsecutivoCompania, NoCotizacionDeOrigen, Numero, Fecha, StatusFactura, Cancelada, TotalFactura
+		FROM dbo.factura
+		WHERE NoCotizacionDeOrigen IN (`+strings.Join(nombres, ", ")+`)
+		  AND TipoDeDocumento = @tipoFactura
+		ORDER BY Fecha, Numero
+	`, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var compania int
+		var origen, cancelada string
+		var f FacturaDeCotizacion
+		if err := rows.Scan(&compania, &origen, &f.Numero, &f.Fecha, &f.StatusFactura, &cancelada, &f.TotalFactura); err != nil {
+			return err
+		}
+		f.Cancelada = cancelada == "S"
+		f.Vigente = facturaVigente(f.StatusFactura, cancelada)
+
+		for _, i := range posicion[origen] {
+			if cotizaciones[i].ConsecutivoCompania != compania {
+				continue
+			}
+			cotizaciones[i].Facturas = append(cotizaciones[i].Facturas, f)
+			cotizaciones[i].Convertida = cotizaciones[i].Convertida || f.Vigente
+		}
+	}
+	return rows.Err()
+}
+
+/*
+Lee los filtros comunes de cotizaciones:
+
+codigoCliente, codigoVendedor: codigos exactos
+desde, hasta: rango de la fecha de la cotizacion
+
+Retorna el WHERE (alias c) y sus parametros.
+*/
+func filtrosCotizacion(c *gin.Context, v *respuesta.Validador) (string, []interface{}, map[string]interface{}) {
+	filterQuery := " WHERE 1=1"
+	params := []interface{}{}
+	aplicados := map[string]interface{}{}
+
+	if codigo := strings.TrimSpace(c.Query("codigoCliente")); codigo != "" {
+		filterQuery += " AND c.CodigoCliente = @codigoCliente"
+		params = append(params, sql.Named("codigoCliente", codigo))
+		aplicados["codigoCliente"] = codigo
+	}
+	if codigo := strings.TrimSpace(c.Query("codigoVendedor")); codigo != "" {
+		filterQuery += " AND c.CodigoVendedor = @codigoVendedor"
+		params = append(params, sql.Named("codigoVendedor", codigo))
+		aplicados["codigoVendedor"] = codigo
+	}
+
+	desde, hasta := v.RangoFechas(c.Query("desde"), c.Query("hasta"))
+	if desde != nil {
+		filterQuery += " AND c.Fecha >= @desde"
+		params = append(params, sql.Named("desde", *desde))
+		aplicados["desde"] = desde.Format("2006-01-02")
+	}
+	if hasta != nil {
+		filterQuery += " AND c.Fecha < @hasta"
+		params = append(params, sql.Named("hasta", *hasta))
+		aplicados["hasta"] = hasta.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+
+	return filterQuery, params, aplicados
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+Lista de cotizaciones con sus facturas.
+Querys: los de filtrosCotizacion mas
+
+convertida: si o no, para separar las cotizaciones facturadas
+page, pageSize: paginacion, por defecto 1 y 100 (maximo 1000)
+*/
+func buscarCotizaciones(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		// ----- Validacion de parametros ----- //
+		var v respuesta.Validador
+		filterQuery, params, aplicados := filtrosCotizacion(c, &v)
+		page := v.Positivo(c.Query("page"), "page", 1)
+		pageSize, ok := v.Entero(c.Query("pageSize"), "pageSize", 1, 1000)
+		if !ok {
+			pageSize = 100
+		}
+		convertida, okConvertida := v.Opcion(strings.ToLower(c.Query("convertida")), "convertida",
+			map[string]string{"si": "si", "true": "si", "no": "no", "false": "no"}, "Use: si o no")
+		if !v.Valido() {
+			logError(requestID+" - Parámetros de búsqueda de cotizaciones inválidos", nil)
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		if okConvertida {
+			if convertida == "si" {
+				filterQuery += " AND " + condicionConvertida
+			} else {
+				filterQuery += " AND NOT " + condicionConvertida
+			}
+			params = append(params, paramsFacturaVigente()...)
+			aplicados["convertida"] = convertida
+		}
+
+		// ----- Conteo ----- //
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM dbo.cotizacion c"+filterQuery, params...).Scan(&total); err != nil {
+			mensaje := "Error al obtener cantidad total de cotizaciones"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		if total == 0 {
+			respuesta.SinResultados(c, "No se encontraron cotizaciones con los filtros indicados")
+			return
+		}
+
+		// ----- Consulta ----- //
+		query := `
+			SELECT ` + columnasCotizacion + `
+			FROM dbo.cotizacion c` + filterQuery + `
+			ORDER BY c.Fecha DESC, c.Numero DESC
+			OFFSET @offset ROWS FETCH NEXT @pageSize ROWS ONLY
+		`
+		params = append(params,
+			sql.Named("offset", (page-1)*pageSize),
+			sql.Named("pageSize", pageSize),
+		)
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar las cotizaciones"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		cotizaciones := []Cotizacion{}
+		for rows.Next() {
+			ct, err := escanearCotizacion(rows)
+			if err != nil {
+				mensaje := "Error al leer datos de cotizaciones"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			cotizaciones = append(cotizaciones, ct)
+		}
+		rows.Close()
+
+		// ----- Facturas de cada cotizacion ----- //
+		if err := facturasDeCotizaciones(db, cotizaciones); err != nil {
+			mensaje := "Error al consultar las facturas de las cotizaciones"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+
+		respuesta.Pagina(c, "Cotizaciones encontradas", cotizaciones, len(cotizaciones),
+			respuesta.NuevaPaginacion(total, page, pageSize), aplicados)
+	}
+}
+
+/*
+Detalle de una cotizacion con sus renglones y facturas.
+Acepta el query compania. Si el numero existe en varias
+companias y no se indica compania se responde 409.
+*/
+func detalleCotizacion(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		numero := strings.TrimSpace(c.Param("numero"))
+
+		var v respuesta.Validador
+		compania, conCompania := v.Entero(c.Query("compania"), "compania", 0, math.MaxInt32)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+
+		filtro, params := respuesta.FiltroCompania("Numero = @numero", "ConsecutivoCompania",
+			[]interface{}{sql.Named("numero", numero)}, compania, conCompania)
+		cotizaciones, err := respuesta.LeerUnico(db, `
+			SELECT TOP 2 `+columnasCotizacion+`
+			FROM dbo.cotizacion
+			WHERE `+filtro+`
+			ORDER BY ConsecutivoCompania
+		`, params, func(rows *sql.Rows) (Cotizacion, error) { return escanearCotizacion(rows) })
+		if err != nil {
+			mensaje := "Error al consultar la cotización"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		ct, ok := respuesta.Unico(c, cotizaciones, "la cotización "+numero)
+		if !ok {
+			return
+		}
+
+		detalle := DetalleCotizacion{Cotizacion: ct, Renglones: []RenglonCotizacion{}}
+
+		// ----- Renglones ----- //
+		rows, err := db.Query(`
+			SELECT ConsecutivoRenglon, CodigoArticulo, Descripcion, AlicuotaIVA, Cantidad, PrecioSinIVA,
+			       PrecioConIVA, PorcentajeDescuento, TotalRenglon, CantidadDespachada
+			FROM dbo.renglonCotizacion
+			WHERE ConsecutivoCompania = @compania AND NumeroCotizacion = @numero
+			ORDER BY ConsecutivoRenglon
+		`, sql.Named("compania", ct.ConsecutivoCompania), sql.Named("numero", ct.Numero))
+		if err != nil {
+			mensaje := "Error al consultar los renglones de la cotización"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r RenglonCotizacion
+			if err := rows.Scan(&r.ConsecutivoRenglon, &r.CodigoArticulo, &r.Descripcion, &r.AlicuotaIVA, &r.Cantidad,
+				&r.PrecioSinIVA, &r.PrecioConIVA, &r.PorcentajeDescuento, &r.TotalRenglon, &r.CantidadDespachada); err != nil {
+				mensaje := "Error al leer los renglones de la cotización"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			detalle.Renglones = append(detalle.Renglones, r)
+		}
+		rows.Close()
+
+		// ----- Facturas ----- //
+		cotizaciones = []Cotizacion{detalle.Cotizacion}
+		if err := facturasDeCotizaciones(db, cotizaciones); err != nil {
+			mensaje := "Error al consultar las facturas de la cotización"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		detalle.Cotizacion = cotizaciones[0]
+
+		respuesta.Exito(c, "Detalle de la cotización "+numero, detalle, 1)
+	}
+}
+
+/*
+Tasa de conversion de cotizaciones a facturas por vendedor
+y mes de la cotizacion.
+Querys: los de filtrosCotizacion.
+*/
+func conversionCotizaciones(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestTime := time.Now()
+		requestID := requestTime.Format("20060102150405")
+
+		var v respuesta.Validador
+		filterQuery, params, _ := filtrosCotizacion(c, &v)
+		if !v.Valido() {
+			respuesta.ErrorValidacion(c, v.Errores())
+			return
+		}
+		params = append(params, paramsFacturaVigente()...)
+
+		// SQL Server no permite subconsultas dentro de SUM,
+		// por eso se marca cada cotizacion antes de agrupar
+		query := `
+			SELECT Vendedor, Mes, COUNT(*), SUM(Convertida)
+			FROM (
+				SELECT ISNULL(c.CodigoVendedor, '') AS Vendedor,
+					CONVERT(varchar(7), c.Fecha, 23) AS Mes,
+					CASE WHEN ` + condicionConvertida + ` THEN 1 ELSE 0 END AS Convertida
+				FROM dbo.cotizacion c` + filterQuery + `
+			) cotizaciones
+			GROUP BY Vendedor, Mes
+			ORDER BY Mes, Vendedor
+		`
+
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			mensaje := "Error al consultar la conversión de cotizaciones"
+			logError(requestID+" - "+mensaje, err)
+			respuesta.ErrorBD(c, mensaje)
+			return
+		}
+		defer rows.Close()
+
+		reporte := ReporteConversion{Grupos: []ConversionCotizaciones{}}
+		for rows.Next() {
+			var g ConversionCotizaciones
+			if err := rows.Scan(&g.CodigoVendedor, &g.Mes, &g.Cotizaciones, &g.Convertidas); err != nil {
+				mensaje := "Error al leer la conversión de cotizaciones"
+				logError(requestID+" - "+mensaje, err)
+				respuesta.ErrorBD(c, mensaje)
+				return
+			}
+			g.Porcentaje = porcentaje(g.Convertidas, g.Cotizaciones)
+			reporte.Cotizaciones += g.Cotizaciones
+			reporte.Convertidas += g.Convertidas
+			reporte.Grupos = append(reporte.Grupos, g)
+		}
+
+		if len(reporte.Grupos) == 0 {
+			respuesta.SinResultados(c, "No se encontraron cotizaciones con los filtros indicados")
+			return
+		}
+		reporte.Porcentaje = porcentaje(reporte.Convertidas, reporte.Cotizaciones)
+
+		respuesta.Exito(c, "Conversión de cotizaciones", reporte, len(reporte.Grupos))
+	}
+}
@@ -0,0 +1,93 @@
+package ventas
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"time"
+
+	"github.com/desarrolladoresnet/api_galac_bd/cache"
+	"github.com/desarrolladoresnet/api_galac_bd/facturas"
+	"github.com/gin-gonic/gin"
+)
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Logger Interno para el registro de errores y problemas.
+	Solo se instancia en este modulo y generar el archivo
+	errores_ventas.log
+*/
+
+// Logger para registrar errores en un archivo
+var errorLogger *log.Logger
+
+func initErrorLogger() {
+	logFile, err := os.OpenFile("errores_ventas.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Println("Error al abrir archivo de log de ventas:", err)
+		return
+	}
+	errorLogger = log.New(logFile, "", log.Ldate|log.Ltime)
+	log.Println("Logger de errores de ventas inicializado correctamente")
+}
+
+func logError(mensaje string, err error) {
+	if errorLogger != nil {
+		errorLogger.Printf("[ERROR] %s: %v\n", mensaje, err)
+	} else {
+		log.Printf("[ERROR] %s: %v\n", mensaje, err)
+	}
+}
+
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////
+
+/*
+	Documentos de venta previos o recurrentes a la factura
+	(cotizaciones y contratos). Se relacionan con dbo.factura por los
+	campos de origen de la factura.
+*/
+
+// Tiempo maximo que se guarda en cache una consulta de ventas
+const ttlCacheVentas = 5 * time.Minute
+
+func CotizacionRoutes(api *gin.RouterGroup, db *sql.DB) {
+	initErrorLogger()
+	api.GET("/", cache.Respuestas(db, ttlCacheVentas, "cotizacion", "factura"), buscarCotizaciones(db))
+	api.GET("/conversion", cache.Respuestas(db, ttlCacheVentas, "cotizacion", "factura"), conversionCotizaciones(db))
+	api.GET("/:numero", cache.Respuestas(db, ttlCacheVentas, "cotizacion", "renglonCotizacion", "factura"), detalleCotizacion(db))
+}
+
+// Tablas que leen las rutas de contratos
+var tablasContrato = []string{"Contrato", "RenglonContrato", "mesGenerado", "factura"}
+
+func ContratoRoutes(api *gin.RouterGroup, db *sql.DB) {
+	api.GET("/", cache.Respuestas(db, ttlCacheVentas, tablasContrato...), buscarContratos(db))
+	api.GET("/pendientes", cache.Respuestas(db, ttlCacheVentas, tablasContrato...), contratosPendientes(db))
+	api.GET("/:numero", cache.Respuestas(db, ttlCacheVentas, tablasContrato...), detalleContrato(db))
+}
+
+/*
+Condicion SQL de factura vigente, para la factura con alias f:
+factura (no nota), que no sea borrador ni este cancelada.
+Usa los parametros de paramsFacturaVigente.
+*/
+const condicionFacturaVigente = `f.TipoDeDocumento = @tipoFactura
+	  AND f.StatusFactura <> @borrador
+	  AND ISNULL(f.Cancelada, 'N') <> 'S'`
+
+func paramsFacturaVigente() []interface{} {
+	return []interface{}{
+		sql.Named("tipoFactura", facturas.TipoDocumentoFactura),
+		sql.Named("borrador", facturas.StatusBorrador),
+	}
+}
+
+// Lo mismo que condicionFacturaVigente para una factura ya leida
+func facturaVigente(statusFactura *string, cancelada string) bool {
+	return cancelada != "S" && (statusFactura == nil || *statusFactura != facturas.StatusBorrador)
+}